@@ -8,9 +8,12 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
+	"github.com/yyyar/gobetween/accesslog"
 	"github.com/yyyar/gobetween/api"
 	"github.com/yyyar/gobetween/cmd"
 	"github.com/yyyar/gobetween/config"
@@ -18,6 +21,8 @@ import (
 	"github.com/yyyar/gobetween/logging"
 	"github.com/yyyar/gobetween/manager"
 	"github.com/yyyar/gobetween/metrics"
+	"github.com/yyyar/gobetween/metrics/statsd"
+	"github.com/yyyar/gobetween/telemetry"
 	"github.com/yyyar/gobetween/utils/codec"
 )
 
@@ -79,16 +84,91 @@ func main() {
 		// Configure logging
 		logging.Configure(cfg.Logging.Output, cfg.Logging.Level, cfg.Logging.Format)
 
+		/* setup metrics, before servers start reporting to them */
+		metrics.Start((*cfg).Metrics)
+
+		// Start pushing stats to statsd, in parallel with prometheus endpoint
+		if err := statsd.Start((*cfg).Metrics.Statsd); err != nil {
+			log.Fatal("Could not start statsd: ", err)
+		}
+
+		// Start OpenTelemetry export of metrics and traces
+		if err := telemetry.Start((*cfg).OpenTelemetry); err != nil {
+			log.Fatal("Could not start OpenTelemetry export: ", err)
+		}
+
+		// Start access log, before servers accept connections
+		if err := accesslog.Start((*cfg).AccessLog); err != nil {
+			log.Fatal("Could not start access log: ", err)
+		}
+
 		// Start manager
 		manager.Initialize(*cfg)
 
-		/* setup metrics */
-		metrics.Start((*cfg).Metrics)
-
 		// Start API
 		api.Start((*cfg).Api)
 
-		// block forever
-		<-(chan string)(nil)
+		// Reload configuration on SIGHUP or when its source changes
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+
+		// Drain connections and exit on SIGTERM
+		sigterm := make(chan os.Signal, 1)
+		signal.Notify(sigterm, syscall.SIGTERM)
+
+		for {
+			select {
+			case <-sighup:
+				log.Print("Got SIGHUP, reloading configuration")
+				reload()
+			case <-cmd.Changes():
+				log.Print("Configuration source changed, reloading configuration")
+				reload()
+			case <-sigterm:
+				log.Print("Got SIGTERM, stopping gracefully (send again to force)")
+				shutdown(sigterm)
+			}
+		}
 	})
 }
+
+/**
+ * Gracefully stop all servers and exit,
+ * or exit immediately if signal is received again
+ */
+func shutdown(signals <-chan os.Signal) {
+
+	stopped := make(chan bool)
+	go func() {
+		manager.StopAll()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-signals:
+		log.Print("Got signal again, exiting without waiting for connections")
+	}
+
+	accesslog.Stop()
+	telemetry.Stop()
+	statsd.Stop()
+
+	os.Exit(0)
+}
+
+/**
+ * Read configuration again and apply it to running servers
+ */
+func reload() {
+
+	cfg, err := cmd.Reload()
+	if err != nil {
+		log.Print("Could not reload configuration: ", err)
+		return
+	}
+
+	if err := manager.Reload(*cfg); err != nil {
+		log.Print("Configuration reloaded with errors: ", err)
+	}
+}
@@ -0,0 +1,213 @@
+package accesslog
+
+/**
+ * accesslog.go - record per finished connection or udp session
+ */
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/logging"
+)
+
+/**
+ * Records waiting to be written. When queue is full,
+ * records are dropped instead of slowing down proxying
+ */
+const QUEUE_SIZE = 4096
+
+/**
+ * Record of finished client connection or udp session
+ */
+type Record struct {
+
+	/* When client connection was accepted */
+	Time time.Time
+
+	/* Server name and protocol */
+	Server   string
+	Protocol string
+
+	/* Client address and its sni */
+	Client string
+	Sni    string
+
+	/* Elected backend address, empty if none */
+	Backend string
+
+	/* Time taken to connect to backend */
+	ConnectTime time.Duration
+
+	/* Time from accepting connection until it's closed */
+	Duration time.Duration
+
+	/* Bytes received from client and sent to client */
+	RxBytes uint64
+	TxBytes uint64
+
+	/* Why connection was closed, see Termination* constants */
+	Termination string
+
+	/* Tls details if tls was terminated by server */
+	TlsVersion    string
+	TlsCipher     string
+	TlsAlpn       string
+	ClientSubject string
+}
+
+/**
+ * Termination reasons
+ */
+const (
+	TerminationClientClosed       = "client_closed"
+	TerminationBackendClosed      = "backend_closed"
+	TerminationClientIdleTimeout  = "client_idle_timeout"
+	TerminationBackendIdleTimeout = "backend_idle_timeout"
+	TerminationClientError        = "client_error"
+	TerminationBackendError       = "backend_error"
+	TerminationServerClosed       = "server_closed"
+	TerminationAccessDenied       = "access_denied"
+	TerminationMaxConnections     = "max_connections"
+	TerminationTlsError           = "tls_error"
+	TerminationSniError           = "sni_error"
+	TerminationProxyProtocolError = "proxy_protocol_error"
+	TerminationNoBackend          = "no_backend"
+	TerminationMaxResponses       = "max_responses"
+)
+
+/**
+ * Access log writing formatted records to sink
+ */
+type accessLog struct {
+	format  formatter
+	sink    sink
+	records chan Record
+	dropped uint64
+	stop    chan bool
+	done    chan bool
+}
+
+/**
+ * Current access log, nil if disabled
+ */
+var current atomic.Pointer[accessLog]
+
+/**
+ * Start writing access log. Access log is disabled if cfg is nil
+ */
+func Start(cfg *config.AccessLogConfig) error {
+
+	log := logging.For("accesslog")
+
+	if cfg == nil {
+		log.Info("Access log disabled")
+		return nil
+	}
+
+	format, err := newFormatter(cfg)
+	if err != nil {
+		return err
+	}
+
+	sink, err := newSink(cfg)
+	if err != nil {
+		return err
+	}
+
+	this := &accessLog{
+		format:  format,
+		sink:    sink,
+		records: make(chan Record, QUEUE_SIZE),
+		stop:    make(chan bool),
+		done:    make(chan bool),
+	}
+
+	go this.run()
+
+	current.Store(this)
+
+	log.Info("Writing access log to ", cfg.Output)
+
+	return nil
+}
+
+/**
+ * Stop access log, writing queued records
+ */
+func Stop() {
+
+	this := current.Swap(nil)
+	if this == nil {
+		return
+	}
+
+	close(this.stop)
+	<-this.done
+}
+
+/**
+ * Log record. Does nothing if access log is disabled
+ */
+func Log(record Record) {
+
+	this := current.Load()
+	if this == nil {
+		return
+	}
+
+	select {
+	case this.records <- record:
+	default:
+		atomic.AddUint64(&this.dropped, 1)
+	}
+}
+
+/**
+ * Write records until access log is stopped
+ */
+func (this *accessLog) run() {
+
+	defer close(this.done)
+	defer this.sink.Close()
+
+	for {
+		select {
+		case record := <-this.records:
+			this.write(record)
+		case <-this.stop:
+			// write records queued before stop
+			for {
+				select {
+				case record := <-this.records:
+					this.write(record)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+/**
+ * Format and write record to sink
+ */
+func (this *accessLog) write(record Record) {
+
+	log := logging.For("accesslog")
+
+	if dropped := atomic.SwapUint64(&this.dropped, 0); dropped > 0 {
+		log.Warn(dropped, " access log records dropped, queue is full")
+	}
+
+	b, err := this.format(record)
+	if err != nil {
+		log.Error("Could not format access log record: ", err)
+		return
+	}
+
+	if err := this.sink.Write(b); err != nil {
+		log.Error("Could not write access log record: ", err)
+	}
+}
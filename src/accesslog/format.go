@@ -0,0 +1,208 @@
+package accesslog
+
+/**
+ * format.go - access log record formats
+ */
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yyyar/gobetween/config"
+)
+
+/**
+ * Formats record to line, including trailing newline
+ */
+type formatter func(Record) ([]byte, error)
+
+/**
+ * Record field
+ */
+type field struct {
+	key   string
+	value interface{}
+}
+
+/**
+ * Make formatter for config format
+ */
+func newFormatter(cfg *config.AccessLogConfig) (formatter, error) {
+
+	switch cfg.Format {
+	case "", "json":
+		return formatJson, nil
+	case "logfmt":
+		return formatLogfmt, nil
+	case "template":
+		if cfg.Template == "" {
+			return nil, errors.New("access_log format 'template' requires template")
+		}
+		t, err := template.New("access_log").Parse(cfg.Template)
+		if err != nil {
+			return nil, errors.New("access_log template: " + err.Error())
+		}
+		return func(record Record) ([]byte, error) {
+			b := &bytes.Buffer{}
+			if err := t.Execute(b, record.escaped()); err != nil {
+				return nil, err
+			}
+			if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+				b.WriteByte('\n')
+			}
+			return b.Bytes(), nil
+		}, nil
+	default:
+		return nil, errors.New("Not supported access_log format " + cfg.Format)
+	}
+}
+
+/**
+ * Fields of record in the order they are written.
+ * Durations are in seconds, tls fields are omitted if tls was not terminated
+ */
+func (this Record) fields() []field {
+
+	fields := []field{
+		{"time", this.Time.Format(time.RFC3339Nano)},
+		{"server", this.Server},
+		{"protocol", this.Protocol},
+		{"client", this.Client},
+		{"sni", this.Sni},
+		{"backend", this.Backend},
+		{"connect_time", this.ConnectTime.Seconds()},
+		{"duration", this.Duration.Seconds()},
+		{"rx_bytes", this.RxBytes},
+		{"tx_bytes", this.TxBytes},
+		{"termination", this.Termination},
+	}
+
+	if this.TlsVersion != "" {
+		fields = append(fields,
+			field{"tls_version", this.TlsVersion},
+			field{"tls_cipher", this.TlsCipher},
+			field{"tls_alpn", this.TlsAlpn},
+			field{"client_subject", this.ClientSubject},
+		)
+	}
+
+	return fields
+}
+
+/**
+ * Format record as json object, keeping fields order
+ */
+func formatJson(record Record) ([]byte, error) {
+
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+
+	for i, f := range record.fields() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+
+	b.WriteString("}\n")
+
+	return b.Bytes(), nil
+}
+
+/**
+ * Format record as logfmt key=value pairs
+ */
+func formatLogfmt(record Record) ([]byte, error) {
+
+	b := &bytes.Buffer{}
+
+	for i, f := range record.fields() {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.key)
+		b.WriteByte('=')
+
+		switch v := f.value.(type) {
+		case string:
+			if v == "" || strings.ContainsAny(v, " =\"\\") || !printable(v) {
+				b.WriteString(strconv.Quote(v))
+			} else {
+				b.WriteString(v)
+			}
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		case uint64:
+			b.WriteString(strconv.FormatUint(v, 10))
+		}
+	}
+
+	b.WriteByte('\n')
+
+	return b.Bytes(), nil
+}
+
+/**
+ * Checks if value is valid utf-8 without control or other non-printable characters
+ */
+func printable(value string) bool {
+
+	if !utf8.ValidString(value) {
+		return false
+	}
+
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+/**
+ * Escape non-printable characters of value, like newlines
+ * sent by client in sni, so that they can't forge log lines
+ */
+func escape(value string) string {
+
+	if printable(value) {
+		return value
+	}
+
+	quoted := strconv.Quote(value)
+	return quoted[1 : len(quoted)-1]
+}
+
+/**
+ * Copy of record with string fields escaped, for use in templates
+ */
+func (this Record) escaped() Record {
+
+	this.Server = escape(this.Server)
+	this.Protocol = escape(this.Protocol)
+	this.Client = escape(this.Client)
+	this.Sni = escape(this.Sni)
+	this.Backend = escape(this.Backend)
+	this.Termination = escape(this.Termination)
+	this.TlsVersion = escape(this.TlsVersion)
+	this.TlsCipher = escape(this.TlsCipher)
+	this.TlsAlpn = escape(this.TlsAlpn)
+	this.ClientSubject = escape(this.ClientSubject)
+
+	return this
+}
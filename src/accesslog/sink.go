@@ -0,0 +1,277 @@
+package accesslog
+
+/**
+ * sink.go - access log outputs
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+)
+
+/**
+ * Sink writes formatted records
+ */
+type sink interface {
+	Write([]byte) error
+	Close() error
+}
+
+/**
+ * Make sink for config output
+ */
+func newSink(cfg *config.AccessLogConfig) (sink, error) {
+
+	switch cfg.Output {
+	case "", "stdout":
+		return &stdoutSink{}, nil
+
+	case "file":
+		if cfg.FilePath == "" {
+			return nil, errors.New("access_log output 'file' requires file_path")
+		}
+		if cfg.FileMaxSize < 0 || cfg.FileMaxBackups < 0 {
+			return nil, errors.New("access_log file_max_size and file_max_backups should not be negative")
+		}
+		return newFileSink(cfg.FilePath, int64(cfg.FileMaxSize)*1024*1024, cfg.FileMaxBackups)
+
+	case "syslog":
+		return newSyslogSink(cfg)
+
+	default:
+		return nil, errors.New("Not supported access_log output " + cfg.Output)
+	}
+}
+
+/* ----- stdout ----- */
+
+type stdoutSink struct{}
+
+func (this *stdoutSink) Write(b []byte) error {
+	_, err := os.Stdout.Write(b)
+	return err
+}
+
+func (this *stdoutSink) Close() error {
+	return nil
+}
+
+/* ----- file ----- */
+
+/**
+ * File rotated when it exceeds maxSize bytes: file.log is renamed to file.log.1,
+ * file.log.1 to file.log.2 and so on, keeping maxBackups files
+ */
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) (*fileSink, error) {
+
+	this := &fileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+
+	if err := this.open(); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+func (this *fileSink) open() error {
+
+	f, err := os.OpenFile(this.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	this.file, this.size = f, info.Size()
+
+	return nil
+}
+
+func (this *fileSink) Write(b []byte) error {
+
+	if this.maxSize > 0 && this.size > 0 && this.size+int64(len(b)) > this.maxSize {
+		if err := this.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := this.file.Write(b)
+	this.size += int64(n)
+
+	return err
+}
+
+func (this *fileSink) rotate() error {
+
+	this.file.Close()
+
+	if this.maxBackups == 0 {
+		os.Remove(this.path)
+	} else {
+		os.Remove(this.backup(this.maxBackups))
+		for i := this.maxBackups - 1; i > 0; i-- {
+			os.Rename(this.backup(i), this.backup(i+1))
+		}
+		if err := os.Rename(this.path, this.backup(1)); err != nil {
+			return err
+		}
+	}
+
+	return this.open()
+}
+
+func (this *fileSink) backup(i int) string {
+	return this.path + "." + strconv.Itoa(i)
+}
+
+func (this *fileSink) Close() error {
+	return this.file.Close()
+}
+
+/* ----- syslog ----- */
+
+/**
+ * Syslog facilities by name
+ */
+var facilities = map[string]int{
+	"kern":   0,
+	"user":   1,
+	"daemon": 3,
+	"auth":   4,
+	"local0": 16,
+	"local1": 17,
+	"local2": 18,
+	"local3": 19,
+	"local4": 20,
+	"local5": 21,
+	"local6": 22,
+	"local7": 23,
+}
+
+/**
+ * Informational severity
+ */
+const SYSLOG_SEVERITY = 6
+
+/**
+ * Syslog messages in RFC5424 format sent over unix datagram socket or udp,
+ * one message per record
+ */
+type syslogSink struct {
+	network  string
+	address  string
+	priority int
+	hostname string
+	tag      string
+	conn     net.Conn
+}
+
+func newSyslogSink(cfg *config.AccessLogConfig) (*syslogSink, error) {
+
+	this := &syslogSink{
+		network: cfg.SyslogNetwork,
+		address: cfg.SyslogAddress,
+		tag:     cfg.SyslogTag,
+	}
+
+	switch this.network {
+	case "", "unixgram":
+		this.network = "unixgram"
+		if this.address == "" {
+			this.address = "/dev/log"
+		}
+	case "udp":
+		if this.address == "" {
+			this.address = "127.0.0.1:514"
+		}
+	default:
+		return nil, errors.New("Not supported access_log syslog_network " + this.network)
+	}
+
+	facilityName := cfg.SyslogFacility
+	if facilityName == "" {
+		facilityName = "local0"
+	}
+
+	facility, ok := facilities[facilityName]
+	if !ok {
+		return nil, errors.New("Not supported access_log syslog_facility " + facilityName)
+	}
+	this.priority = facility*8 + SYSLOG_SEVERITY
+
+	if this.tag == "" {
+		this.tag = "gobetween"
+	}
+
+	this.hostname, _ = os.Hostname()
+	if this.hostname == "" {
+		this.hostname = "-"
+	}
+
+	if err := this.dial(); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+func (this *syslogSink) dial() error {
+
+	conn, err := net.Dial(this.network, this.address)
+	if err != nil {
+		return err
+	}
+
+	this.conn = conn
+
+	return nil
+}
+
+func (this *syslogSink) Write(b []byte) error {
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d access - %s",
+		this.priority, time.Now().Format(time.RFC3339Nano), this.hostname, this.tag, os.Getpid(), trimNewline(b))
+
+	if _, err := this.conn.Write([]byte(msg)); err == nil {
+		return nil
+	}
+
+	// syslog daemon may have been restarted
+	this.conn.Close()
+	if err := this.dial(); err != nil {
+		return err
+	}
+
+	_, err := this.conn.Write([]byte(msg))
+	return err
+}
+
+func (this *syslogSink) Close() error {
+	return this.conn.Close()
+}
+
+func trimNewline(b []byte) []byte {
+	if len(b) > 0 && b[len(b)-1] == '\n' {
+		return b[:len(b)-1]
+	}
+	return b
+}
@@ -43,7 +43,7 @@ func Start(cfg config.ApiConfig) {
 		corsConfig := cors.DefaultConfig()
 		corsConfig.AllowAllOrigins = true
 		corsConfig.AllowCredentials = true
-		corsConfig.AllowMethods = []string{"PUT", "POST", "DELETE", "GET", "OPTIONS"}
+		corsConfig.AllowMethods = []string{"PUT", "PATCH", "POST", "DELETE", "GET", "OPTIONS"}
 		corsConfig.AllowHeaders = []string{"Origin", "Authorization"}
 
 		app.Use(cors.New(corsConfig))
@@ -62,6 +62,7 @@ func Start(cfg config.ApiConfig) {
 	/* attach endpoints */
 	attachRoot(r)
 	attachServers(r)
+	attachEvents(r)
 
 	/* attach endpoints with no auth */
 	p := app.Group("/")
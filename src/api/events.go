@@ -0,0 +1,96 @@
+package api
+
+/**
+ * events.go - /events rest api implementation
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yyyar/gobetween/events"
+)
+
+/**
+ * Interval of comments keeping idle events stream open through proxies
+ */
+const EVENTS_KEEPALIVE_INTERVAL = 15 * time.Second
+
+/**
+ * Attaches /events handlers
+ */
+func attachEvents(app *gin.RouterGroup) {
+
+	/**
+	 * Stream events as server-sent events, optionally
+	 * filtered by comma-separated servers and types
+	 */
+	app.GET("/events", func(c *gin.Context) {
+
+		filter := events.Filter{
+			Servers: splitList(c.Query("server")),
+			Types:   splitList(c.Query("type")),
+		}
+
+		for _, t := range filter.Types {
+			if !events.IsType(t) {
+				c.IndentedJSON(http.StatusBadRequest, "Unknown event type: "+t)
+				return
+			}
+		}
+
+		subscription := events.Subscribe(filter)
+		defer events.Unsubscribe(subscription)
+
+		keepalive := time.NewTicker(EVENTS_KEEPALIVE_INTERVAL)
+		defer keepalive.Stop()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		for {
+			select {
+			case event := <-subscription.Events():
+				data, err := json.Marshal(event)
+				if err != nil {
+					return
+				}
+				if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, data); err != nil {
+					return
+				}
+
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(c.Writer, ": keepalive\n\n"); err != nil {
+					return
+				}
+
+			case <-c.Request.Context().Done():
+				return
+			}
+
+			c.Writer.Flush()
+		}
+	})
+}
+
+/**
+ * Split comma-separated query value, skipping empty items
+ */
+func splitList(value string) []string {
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -7,10 +7,12 @@ package api
  */
 
 import (
+	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
 	"github.com/yyyar/gobetween/manager"
 	"github.com/yyyar/gobetween/stats"
 )
@@ -36,11 +38,13 @@ func attachServers(app *gin.RouterGroup) {
 	})
 
 	/**
-	 * Delete server by name
+	 * Delete server by name.
+	 * With ?graceful=true active connections are drained in background
 	 */
 	app.DELETE("/servers/:name", func(c *gin.Context) {
 		name := c.Param("name")
-		manager.Delete(name)
+		graceful := c.Query("graceful") == "true"
+		manager.Delete(name, graceful)
 		c.IndentedJSON(http.StatusOK, nil)
 	})
 
@@ -66,11 +70,92 @@ func attachServers(app *gin.RouterGroup) {
 	})
 
 	/**
-	 * Get server stats
+	 * Get server stats, or stats of its pool if ?pool= is set
 	 */
 	app.GET("/servers/:name/stats", func(c *gin.Context) {
 		name := c.Param("name")
+		if pool := c.Query("pool"); pool != "" {
+			name = stats.PoolName(name, pool)
+		}
 		c.IndentedJSON(http.StatusOK, stats.GetStats(name))
 	})
 
+	/**
+	 * Get server backends with their stats and overrides,
+	 * or backends of its pool if ?pool= is set
+	 */
+	app.GET("/servers/:name/backends", func(c *gin.Context) {
+		name := c.Param("name")
+
+		backends, err := manager.Backends(name, c.Query("pool"))
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, backends)
+	})
+
+	/**
+	 * Get certificates used by server with their expiry and fingerprint
+	 */
+	app.GET("/servers/:name/certificates", func(c *gin.Context) {
+		name := c.Param("name")
+
+		certificates, err := manager.Certificates(name)
+		if err != nil {
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, certificates)
+	})
+
+	/**
+	 * Replace backend override (state, weight, priority, max_connections)
+	 */
+	app.PUT("/servers/:name/backends/:backend", func(c *gin.Context) {
+		overrideBackend(c, false)
+	})
+
+	/**
+	 * Update only passed fields of backend override
+	 */
+	app.PATCH("/servers/:name/backends/:backend", func(c *gin.Context) {
+		overrideBackend(c, true)
+	})
+
+}
+
+/**
+ * Override backend :backend (host:port) of server :name, or of its pool if ?pool= is set
+ */
+func overrideBackend(c *gin.Context, merge bool) {
+
+	name := c.Param("name")
+
+	if manager.Get(name) == nil {
+		c.IndentedJSON(http.StatusNotFound, "Server not found")
+		return
+	}
+
+	host, port, err := net.SplitHostPort(c.Param("backend"))
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	override := core.BackendOverride{}
+	if err := c.BindJSON(&override); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	backend, err := manager.OverrideBackend(name, c.Query("pool"), core.Target{Host: host, Port: port}, override, merge)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, backend)
 }
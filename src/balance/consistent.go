@@ -0,0 +1,245 @@
+package balance
+
+/**
+ * consistent.go - consistent hash (ketama ring) balance impl with bounded load
+ */
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/yyyar/gobetween/core"
+)
+
+/**
+ * Default number of points on the ring per unit of backend weight
+ */
+const CONSISTENT_DEFAULT_REPLICAS = 160
+
+/**
+ * Point on the hash ring
+ */
+type ringPoint struct {
+	hash   uint32
+	target core.Target
+}
+
+/**
+ * Consistent hash balancer.
+ * Maps clients to backends using ketama-style ring, so that adding or removing
+ * a backend remaps only ~1/N of clients. Optionally limits load of every
+ * backend to LoadFactor times its fair share of active connections,
+ * spilling over to the next backend on the ring.
+ */
+type ConsistentBalancer struct {
+
+	/* What to hash: "ip" | "ip_port" | "sni" | "client" */
+	HashKey string
+
+	/* Number of ring points per unit of weight */
+	Replicas int
+
+	/* Bounded load factor, 0 means no bound */
+	LoadFactor float64
+
+	/* Current ring of all discovered backends, sorted by hash */
+	ring []ringPoint
+
+	/* Weights of backends ring was built for */
+	nodes map[core.Target]int
+}
+
+/**
+ * Elect backend using consistent hashing
+ */
+func (b *ConsistentBalancer) Elect(context core.Context, backends []*core.Backend) (*core.Backend, error) {
+
+	if len(backends) == 0 {
+		return nil, errors.New("Can't elect backend, Backends empty")
+	}
+
+	// use only backends with the lowest priority, as weight balancer does
+	minPriority := backends[0].Priority
+	for _, backend := range backends {
+		if backend.Priority < minPriority {
+			minPriority = backend.Priority
+		}
+	}
+
+	group := make(map[core.Target]*core.Backend, len(backends))
+	totalWeight := 0
+	var totalActive uint
+	for _, backend := range backends {
+		if backend.Priority != minPriority {
+			continue
+		}
+		group[backend.Target] = backend
+		if backend.Weight > 0 {
+			totalWeight += backend.Weight
+		}
+		totalActive += backend.Stats.ActiveConnections
+	}
+
+	// ring is built for all discovered backends by UpdateBackends, elected ones
+	// are always on it. Only balancer used without it builds ring here
+	if !b.hasNodes(backends) {
+		b.build(backends)
+	}
+
+	hash := hashKey(b.key(context))
+
+	// all backends have zero weight, so nothing is on the ring
+	if totalWeight == 0 {
+		sorted := make([]*core.Backend, 0, len(group))
+		for _, backend := range group {
+			sorted = append(sorted, backend)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].Target.String() < sorted[j].Target.String()
+		})
+		return sorted[hash%uint32(len(sorted))], nil
+	}
+
+	// first point clockwise from the key
+	start := sort.Search(len(b.ring), func(i int) bool {
+		return b.ring[i].hash >= hash
+	})
+
+	var first *core.Backend
+	for i := 0; i < len(b.ring); i++ {
+		point := b.ring[(start+i)%len(b.ring)]
+
+		// backends not passed for this election (dead, overloaded,
+		// or of other priority) are skipped without rebuilding the ring
+		backend, ok := group[point.target]
+		if !ok || backend.Weight <= 0 {
+			continue
+		}
+
+		if b.LoadFactor <= 0 {
+			return backend, nil
+		}
+
+		if first == nil {
+			first = backend
+		}
+
+		capacity := math.Ceil(b.LoadFactor * float64(totalActive+1) * float64(backend.Weight) / float64(totalWeight))
+		if float64(backend.Stats.ActiveConnections+1) <= capacity {
+			return backend, nil
+		}
+	}
+
+	if first != nil {
+		return first, nil
+	}
+
+	return nil, errors.New("Can't elect backend")
+}
+
+/**
+ * Rebuild ring if set of discovered backends or their weights changed
+ */
+func (b *ConsistentBalancer) UpdateBackends(backends []*core.Backend) {
+
+	if len(backends) == len(b.nodes) && b.hasNodes(backends) {
+		return
+	}
+
+	b.build(backends)
+}
+
+/**
+ * Check if ring contains all backends with their current weights
+ */
+func (b *ConsistentBalancer) hasNodes(backends []*core.Backend) bool {
+
+	if b.nodes == nil {
+		return false
+	}
+
+	for _, backend := range backends {
+		weight, ok := b.nodes[backend.Target]
+		if !ok || weight != backend.Weight {
+			return false
+		}
+	}
+
+	return true
+}
+
+/**
+ * Build ring for backends.
+ * Points of each backend depend only on its address and weight, so backends
+ * that stay in the pool keep their points when others are added or removed
+ */
+func (b *ConsistentBalancer) build(backends []*core.Backend) {
+
+	replicas := b.Replicas
+	if replicas <= 0 {
+		replicas = CONSISTENT_DEFAULT_REPLICAS
+	}
+
+	b.nodes = make(map[core.Target]int, len(backends))
+	b.ring = b.ring[:0]
+
+	for _, backend := range backends {
+
+		b.nodes[backend.Target] = backend.Weight
+
+		if backend.Weight <= 0 {
+			continue
+		}
+
+		// every md5 digest gives 4 points, as in ketama
+		points := replicas * backend.Weight
+		for i := 0; i < (points+3)/4; i++ {
+			digest := md5.Sum([]byte(backend.Target.String() + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4 && i*4+j < points; j++ {
+				b.ring = append(b.ring, ringPoint{
+					hash:   binary.LittleEndian.Uint32(digest[j*4 : j*4+4]),
+					target: backend.Target,
+				})
+			}
+		}
+	}
+
+	sort.Slice(b.ring, func(i, j int) bool {
+		if b.ring[i].hash == b.ring[j].hash {
+			return b.ring[i].target.String() < b.ring[j].target.String()
+		}
+		return b.ring[i].hash < b.ring[j].hash
+	})
+}
+
+/**
+ * Get hash key of the client
+ */
+func (b *ConsistentBalancer) key(context core.Context) string {
+	switch b.HashKey {
+	case "ip_port":
+		return net.JoinHostPort(context.Ip().String(), strconv.Itoa(context.Port()))
+	case "sni":
+		if sni := context.Sni(); sni != "" {
+			return sni
+		}
+	case "client":
+		if client := context.Client(); client != nil {
+			return client.Subject
+		}
+	}
+	return context.Ip().String()
+}
+
+/**
+ * Hash key to the ring position
+ */
+func hashKey(key string) uint32 {
+	digest := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(digest[0:4])
+}
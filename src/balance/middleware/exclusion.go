@@ -0,0 +1,48 @@
+package middleware
+
+/**
+ * exclusion.go - excluded backends middleware
+ */
+
+import (
+	"errors"
+
+	"github.com/yyyar/gobetween/core"
+)
+
+/**
+ * ExclusionMiddleware middleware
+ * Filters out backends excluded by context, for example
+ * ones that already failed to connect for this client
+ */
+type ExclusionMiddleware struct {
+	Delegate core.Balancer
+}
+
+/**
+ * Elect backend filtering out excluded backends
+ */
+func (b *ExclusionMiddleware) Elect(ctx core.Context, backends []*core.Backend) (*core.Backend, error) {
+
+	eligible := make([]*core.Backend, 0, len(backends))
+
+	for _, backend := range backends {
+		if ctx.Excluded(backend.Target) {
+			continue
+		}
+		eligible = append(eligible, backend)
+	}
+
+	if len(eligible) == 0 {
+		return nil, errors.New("all backends are excluded")
+	}
+
+	return b.Delegate.Elect(ctx, eligible)
+}
+
+/**
+ * Pass backends to delegate
+ */
+func (b *ExclusionMiddleware) UpdateBackends(backends []*core.Backend) {
+	core.UpdateBackends(b.Delegate, backends)
+}
@@ -43,3 +43,10 @@ func (b *MaxConnectionsMiddleware) Elect(ctx core.Context, backends []*core.Back
 
 	return b.Delegate.Elect(ctx, eligible)
 }
+
+/**
+ * Pass backends to delegate
+ */
+func (b *MaxConnectionsMiddleware) UpdateBackends(backends []*core.Backend) {
+	core.UpdateBackends(b.Delegate, backends)
+}
@@ -56,6 +56,13 @@ func (sniBalancer *SniMiddleware) Elect(ctx core.Context, backends []*core.Backe
 	}
 }
 
+/**
+ * Pass backends to delegate
+ */
+func (sniBalancer *SniMiddleware) UpdateBackends(backends []*core.Backend) {
+	core.UpdateBackends(sniBalancer.Delegate, backends)
+}
+
 /**
  * Filter out backends that match requestedSni
  */
@@ -29,17 +29,30 @@ func init() {
 	typeRegistry["iphash"] = reflect.TypeOf(IphashBalancer{})
 	typeRegistry["iphash1"] = reflect.TypeOf(Iphash1Balancer{})
 	typeRegistry["leastbandwidth"] = reflect.TypeOf(LeastbandwidthBalancer{})
+	typeRegistry["consistent"] = reflect.TypeOf(ConsistentBalancer{})
 }
 
 /**
  * Create new Balancer based on balancing strategy
  * Wrap it in middlewares if needed
  */
-func New(sniConf *config.Sni, balance string) core.Balancer {
+func New(sniConf *config.Sni, balance string, consistentConf *config.Consistent) core.Balancer {
 
 	// Create the base balancer
 	balancer := reflect.New(typeRegistry[balance]).Elem().Addr().Interface().(core.Balancer)
 
+	// Configure consistent hash balancer
+	if consistent, ok := balancer.(*ConsistentBalancer); ok && consistentConf != nil {
+		consistent.HashKey = consistentConf.HashKey
+		consistent.Replicas = consistentConf.Replicas
+		consistent.LoadFactor = consistentConf.LoadFactor
+	}
+
+	// Apply exclusion middleware (always applied)
+	balancer = &middleware.ExclusionMiddleware{
+		Delegate: balancer,
+	}
+
 	// Apply max connections middleware (always applied)
 	balancer = &middleware.MaxConnectionsMiddleware{
 		Delegate: balancer,
@@ -7,6 +7,8 @@ package cmd
  */
 
 import (
+	"errors"
+
 	"github.com/yyyar/gobetween/config"
 )
 
@@ -15,6 +17,17 @@ import (
  */
 var start func(*config.Config)
 
+/**
+ * Loads configuration from the source of the command being executed.
+ * Set by command before start, and used to reload configuration
+ */
+var load func() (*config.Config, error)
+
+/**
+ * Channel notified when configuration source has changed
+ */
+var changes = make(chan bool, 1)
+
 /**
  * Execute processing flags
  */
@@ -22,3 +35,32 @@ func Execute(f func(*config.Config)) {
 	start = f
 	RootCmd.Execute()
 }
+
+/**
+ * Reload reads configuration again from the same source
+ * it was read on startup
+ */
+func Reload() (*config.Config, error) {
+	if load == nil {
+		return nil, errors.New("Configuration source does not support reloading")
+	}
+	return load()
+}
+
+/**
+ * Returns channel notified each time configuration
+ * source has changed, if watching is enabled
+ */
+func Changes() <-chan bool {
+	return changes
+}
+
+/**
+ * Notify that configuration source has changed
+ */
+func notifyChanged() {
+	select {
+	case changes <- true:
+	default:
+	}
+}
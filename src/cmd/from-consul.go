@@ -7,7 +7,9 @@ package cmd
  */
 
 import (
+	"errors"
 	"log"
+	"time"
 
 	consul "github.com/hashicorp/consul/api"
 	"github.com/spf13/cobra"
@@ -52,23 +54,17 @@ var FromConsulCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		pair, _, err := client.KV().Get(consulKey, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if pair == nil {
-			log.Fatal("Empty value for key " + consulKey)
+		load = func() (*config.Config, error) {
+			return loadFromConsul(client)
 		}
 
-		datastr := string(pair.Value)
-		if isConfigEnvVars {
-			datastr = utils.SubstituteEnvVars(datastr)
+		cfg, err := load()
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		var cfg config.Config
-		if err := codec.Decode(datastr, &cfg, format); err != nil {
-			log.Fatal(err)
+		if watchConfig {
+			go watchConsul(client)
 		}
 
 		info.Configuration = struct {
@@ -77,6 +73,63 @@ var FromConsulCmd = &cobra.Command{
 			Key  string `json:"key"`
 		}{"consul", consulConfig.Address, consulKey}
 
-		start(&cfg)
+		start(cfg)
 	},
 }
+
+/**
+ * Get config key from consul and decode it
+ */
+func loadFromConsul(client *consul.Client) (*config.Config, error) {
+
+	pair, _, err := client.KV().Get(consulKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair == nil {
+		return nil, errors.New("Empty value for key " + consulKey)
+	}
+
+	datastr := string(pair.Value)
+	if isConfigEnvVars {
+		datastr = utils.SubstituteEnvVars(datastr)
+	}
+
+	var cfg config.Config
+	if err := codec.Decode(datastr, &cfg, format); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+/**
+ * Watch config key using consul blocking queries
+ * and notify when it's modified
+ */
+func watchConsul(client *consul.Client) {
+
+	var index uint64
+
+	for {
+		_, meta, err := client.KV().Get(consulKey, &consul.QueryOptions{WaitIndex: index})
+		if err != nil {
+			log.Print("Error watching consul key ", consulKey, ": ", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		if index != 0 && meta.LastIndex != index {
+			notifyChanged()
+		}
+
+		// index went backwards (e.g. consul state reset), start over
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+
+		index = meta.LastIndex
+	}
+}
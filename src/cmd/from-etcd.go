@@ -0,0 +1,166 @@
+package cmd
+
+/**
+ * from-etcd.go - pull config from etcd and run
+ */
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/info"
+	"github.com/yyyar/gobetween/utils"
+	"github.com/yyyar/gobetween/utils/codec"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+/* Parsed options */
+var etcdKey string
+var etcdConfig clientv3.Config = clientv3.Config{DialTimeout: 5 * time.Second}
+var etcdTlsInfo transport.TLSInfo
+
+/**
+ * Add command
+ */
+func init() {
+
+	FromEtcdCmd.Flags().StringVarP(&etcdKey, "key", "k", "gobetween", "etcd Key to pull config from")
+	FromEtcdCmd.Flags().StringVarP(&etcdConfig.Username, "username", "u", "", "etcd username")
+	FromEtcdCmd.Flags().StringVarP(&etcdConfig.Password, "password", "p", "", "etcd password")
+	FromEtcdCmd.Flags().StringVar(&etcdTlsInfo.CertFile, "tls-cert", "", "Client certificate file for TLS")
+	FromEtcdCmd.Flags().StringVar(&etcdTlsInfo.KeyFile, "tls-key", "", "Client key file for TLS")
+	FromEtcdCmd.Flags().StringVar(&etcdTlsInfo.TrustedCAFile, "tls-cacert", "", "CA certificate file for TLS")
+
+	RootCmd.AddCommand(FromEtcdCmd)
+}
+
+/**
+ * FromEtcd command
+ */
+var FromEtcdCmd = &cobra.Command{
+	Use:   "from-etcd <host:port,...>",
+	Short: "Start using config from etcd",
+	Long:  `Start using config from the etcd v3 key-value storage`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if len(args) != 1 {
+			cmd.Help()
+			return
+		}
+
+		etcdConfig.Endpoints = strings.Split(args[0], ",")
+
+		if etcdTlsInfo.CertFile != "" || etcdTlsInfo.KeyFile != "" || etcdTlsInfo.TrustedCAFile != "" {
+			tlsConfig, err := etcdTlsInfo.ClientConfig()
+			if err != nil {
+				log.Fatal(err)
+			}
+			etcdConfig.TLS = tlsConfig
+		}
+
+		client, err := clientv3.New(etcdConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		load = func() (*config.Config, error) {
+			cfg, _, err := loadFromEtcd(client)
+			return cfg, err
+		}
+
+		cfg, revision, err := loadFromEtcd(client)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if watchConfig {
+			go watchEtcd(client, revision)
+		}
+
+		info.Configuration = struct {
+			Kind      string   `json:"kind"`
+			Endpoints []string `json:"endpoints"`
+			Key       string   `json:"key"`
+		}{"etcd", etcdConfig.Endpoints, etcdKey}
+
+		start(cfg)
+	},
+}
+
+/**
+ * Get config key from etcd and decode it.
+ * Returns revision of etcd the config was read at
+ */
+func loadFromEtcd(client *clientv3.Client) (*config.Config, int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), etcdConfig.DialTimeout)
+	defer cancel()
+
+	resp, err := client.Get(ctx, etcdKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if len(resp.Kvs) == 0 {
+		return nil, 0, errors.New("Empty value for key " + etcdKey)
+	}
+
+	datastr := string(resp.Kvs[0].Value)
+	if isConfigEnvVars {
+		datastr = utils.SubstituteEnvVars(datastr)
+	}
+
+	var cfg config.Config
+	if err := codec.Decode(datastr, &cfg, format); err != nil {
+		return nil, 0, err
+	}
+
+	return &cfg, resp.Header.Revision, nil
+}
+
+/**
+ * Watch config key using etcd watch starting after revision
+ * config was loaded at, and notify when it's modified
+ */
+func watchEtcd(client *clientv3.Client, revision int64) {
+
+	for {
+		opts := []clientv3.OpOption{}
+		if revision != 0 {
+			// revision seen last, to not miss changes made since then
+			opts = append(opts, clientv3.WithRev(revision+1))
+		}
+
+		// watch is closed by cancel, when it's left on error
+		ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(context.Background()))
+
+		for resp := range client.Watch(ctx, etcdKey, opts...) {
+
+			// changes since revision are lost, reload anyway
+			if resp.CompactRevision != 0 {
+				revision = 0
+				notifyChanged()
+				break
+			}
+
+			if err := resp.Err(); err != nil {
+				log.Print("Error watching etcd key ", etcdKey, ": ", err)
+				break
+			}
+
+			revision = resp.Header.Revision
+			if len(resp.Events) > 0 {
+				notifyChanged()
+			}
+		}
+
+		cancel()
+		time.Sleep(5 * time.Second)
+	}
+}
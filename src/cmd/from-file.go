@@ -15,6 +15,7 @@ import (
 	"github.com/yyyar/gobetween/info"
 	"github.com/yyyar/gobetween/utils"
 	"github.com/yyyar/gobetween/utils/codec"
+	"github.com/yyyar/gobetween/utils/watcher"
 )
 
 /**
@@ -37,27 +38,57 @@ var FromFileCmd = &cobra.Command{
 			return
 		}
 
-		data, err := ioutil.ReadFile(args[0])
-		if err != nil {
-			log.Fatal(err)
+		path := args[0]
+		load = func() (*config.Config, error) {
+			return loadFromFile(path)
 		}
 
-		var cfg config.Config
-
-		datastr := string(data)
-		if isConfigEnvVars {
-			datastr = utils.SubstituteEnvVars(datastr)
+		cfg, err := load()
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		if err = codec.Decode(datastr, &cfg, format); err != nil {
-			log.Fatal(err)
+		if watchConfig {
+			changed, err := watcher.Watch(nil, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			go func() {
+				for range changed {
+					notifyChanged()
+				}
+			}()
 		}
 
 		info.Configuration = struct {
 			Kind string `json:"kind"`
 			Path string `json:"path"`
-		}{"file", args[0]}
+		}{"file", path}
 
-		start(&cfg)
+		start(cfg)
 	},
 }
+
+/**
+ * Read and decode config file
+ */
+func loadFromFile(path string) (*config.Config, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config.Config
+
+	datastr := string(data)
+	if isConfigEnvVars {
+		datastr = utils.SubstituteEnvVars(datastr)
+	}
+
+	if err = codec.Decode(datastr, &cfg, format); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
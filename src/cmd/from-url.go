@@ -39,28 +39,18 @@ var FromUrlCmd = &cobra.Command{
 			return
 		}
 
-		client := http.Client{}
-		res, err := client.Get(args[0])
-		if err != nil {
-			log.Fatal(err)
+		url := args[0]
+		load = func() (*config.Config, error) {
+			return loadFromUrl(url)
 		}
 
-		defer res.Body.Close()
-
-		// Read response
-		content, err := ioutil.ReadAll(res.Body)
+		cfg, err := load()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		datastr := string(content)
-		if isConfigEnvVars {
-			datastr = utils.SubstituteEnvVars(datastr)
-		}
-
-		var cfg config.Config
-		if err := codec.Decode(datastr, &cfg, format); err != nil {
-			log.Fatal(err)
+		if watchConfig {
+			log.Print("Watching is not supported for url configuration source, use SIGHUP to reload")
 		}
 
 		info.Configuration = struct {
@@ -68,6 +58,38 @@ var FromUrlCmd = &cobra.Command{
 			Url  string `json:"url"`
 		}{"url", args[0]}
 
-		start(&cfg)
+		start(cfg)
 	},
 }
+
+/**
+ * Query url and decode config from response body
+ */
+func loadFromUrl(url string) (*config.Config, error) {
+
+	client := http.Client{}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	// Read response
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	datastr := string(content)
+	if isConfigEnvVars {
+		datastr = utils.SubstituteEnvVars(datastr)
+	}
+
+	var cfg config.Config
+	if err := codec.Decode(datastr, &cfg, format); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
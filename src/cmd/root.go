@@ -29,6 +29,9 @@ var showVersion bool
 /* Substitute env vars in config or not */
 var isConfigEnvVars bool
 
+/* Reload config when its source changes */
+var watchConfig bool
+
 /**
  * Add Root Command
  */
@@ -38,6 +41,7 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&pidFilePath, "pidfile", "p", "", "Write pid to specified file")
 	RootCmd.PersistentFlags().StringVarP(&format, "format", "f", "toml", "Configuration file format: \"toml\" or \"json\"")
 	RootCmd.PersistentFlags().BoolVarP(&isConfigEnvVars, "use-config-env-vars", "e", false, "Enable env variables interpretation in config file")
+	RootCmd.PersistentFlags().BoolVarP(&watchConfig, "watch", "w", false, "Reload configuration when its source changes (file, consul and etcd)")
 }
 
 /**
@@ -11,13 +11,15 @@ package config
  * Config file top-level object
  */
 type Config struct {
-	Logging  LoggingConfig     `toml:"logging" json:"logging"`
-	Api      ApiConfig         `toml:"api" json:"api"`
-	Metrics  MetricsConfig     `toml:"metrics" json:"metrics"`
-	Defaults ConnectionOptions `toml:"defaults" json:"defaults"`
-	Acme     *AcmeConfig       `toml:"acme" json:"acme"`
-	Profiler *ProfilerConfig   `toml:"profiler" json:"profiler"`
-	Servers  map[string]Server `toml:"servers" json:"servers"`
+	Logging       LoggingConfig        `toml:"logging" json:"logging"`
+	Api           ApiConfig            `toml:"api" json:"api"`
+	Metrics       MetricsConfig        `toml:"metrics" json:"metrics"`
+	OpenTelemetry *OpenTelemetryConfig `toml:"opentelemetry" json:"opentelemetry"`
+	Defaults      ConnectionOptions    `toml:"defaults" json:"defaults"`
+	Acme          *AcmeConfig          `toml:"acme" json:"acme"`
+	Profiler      *ProfilerConfig      `toml:"profiler" json:"profiler"`
+	AccessLog     *AccessLogConfig     `toml:"access_log" json:"access_log"`
+	Servers       map[string]Server    `toml:"servers" json:"servers"`
 }
 
 /**
@@ -29,6 +31,22 @@ type LoggingConfig struct {
 	Format string `toml:"format" json:"format"`
 }
 
+/**
+ * Access log config section
+ */
+type AccessLogConfig struct {
+	Format         string `toml:"format" json:"format"`
+	Template       string `toml:"template" json:"template"`
+	Output         string `toml:"output" json:"output"`
+	FilePath       string `toml:"file_path" json:"file_path"`
+	FileMaxSize    int    `toml:"file_max_size" json:"file_max_size"`
+	FileMaxBackups int    `toml:"file_max_backups" json:"file_max_backups"`
+	SyslogNetwork  string `toml:"syslog_network" json:"syslog_network"`
+	SyslogAddress  string `toml:"syslog_address" json:"syslog_address"`
+	SyslogFacility string `toml:"syslog_facility" json:"syslog_facility"`
+	SyslogTag      string `toml:"syslog_tag" json:"syslog_tag"`
+}
+
 /**
  * Api config section
  */
@@ -60,18 +78,48 @@ type ApiTlsConfig struct {
  * Metrics config section
  */
 type MetricsConfig struct {
-	Enabled bool   `toml:"enabled" json:"enabled"`
-	Bind    string `toml:"bind" json:"bind"`
+	Enabled bool          `toml:"enabled" json:"enabled"`
+	Bind    string        `toml:"bind" json:"bind"`
+	Statsd  *StatsdConfig `toml:"statsd" json:"statsd"`
+}
+
+/**
+ * StatsD (DogStatsD) metrics push config section
+ */
+type StatsdConfig struct {
+	Address  string   `toml:"address" json:"address"`
+	Interval string   `toml:"interval" json:"interval"`
+	Format   string   `toml:"format" json:"format"`
+	Prefix   string   `toml:"prefix" json:"prefix"`
+	Tags     []string `toml:"tags" json:"tags"`
+}
+
+/**
+ * OpenTelemetry (OTLP) export config section
+ */
+type OpenTelemetryConfig struct {
+	Endpoint          string            `toml:"endpoint" json:"endpoint"`
+	Protocol          string            `toml:"protocol" json:"protocol"`
+	Insecure          bool              `toml:"insecure" json:"insecure"`
+	Headers           map[string]string `toml:"headers" json:"headers"`
+	ServiceName       string            `toml:"service_name" json:"service_name"`
+	Metrics           bool              `toml:"metrics" json:"metrics"`
+	MetricsInterval   string            `toml:"metrics_interval" json:"metrics_interval"`
+	Traces            bool              `toml:"traces" json:"traces"`
+	TracesSampleRatio *float64          `toml:"traces_sample_ratio" json:"traces_sample_ratio"`
 }
 
 /**
  * Default values can be overridden in server
  */
 type ConnectionOptions struct {
-	MaxConnections           *int    `toml:"max_connections" json:"max_connections"`
-	ClientIdleTimeout        *string `toml:"client_idle_timeout" json:"client_idle_timeout"`
-	BackendIdleTimeout       *string `toml:"backend_idle_timeout" json:"backend_idle_timeout"`
-	BackendConnectionTimeout *string `toml:"backend_connection_timeout" json:"backend_connection_timeout"`
+	MaxConnections            *int    `toml:"max_connections" json:"max_connections"`
+	ClientIdleTimeout         *string `toml:"client_idle_timeout" json:"client_idle_timeout"`
+	BackendIdleTimeout        *string `toml:"backend_idle_timeout" json:"backend_idle_timeout"`
+	BackendConnectionTimeout  *string `toml:"backend_connection_timeout" json:"backend_connection_timeout"`
+	BackendConnectionRetries  *int    `toml:"backend_connection_retries" json:"backend_connection_retries"`
+	BackendConnectionDeadline *string `toml:"backend_connection_deadline" json:"backend_connection_deadline"`
+	DrainTimeout              *string `toml:"drain_timeout" json:"drain_timeout"`
 }
 
 /**
@@ -108,6 +156,9 @@ type Server struct {
 	// weight | leastconn | roundrobin
 	Balance string `toml:"balance" json:"balance"`
 
+	// Optional configuration for balance = consistent
+	Consistent *Consistent `toml:"consistent" json:"consistent"`
+
 	// Optional configuration for server name indication
 	Sni *Sni `toml:"sni" json:"sni"`
 
@@ -126,11 +177,42 @@ type Server struct {
 	// ProxyProtocol configuration
 	ProxyProtocol *ProxyProtocol `toml:"proxy_protocol" json:"proxy_protocol"`
 
+	// Accepting ProxyProtocol from trusted peers configuration
+	AcceptProxyProtocol *AcceptProxyProtocol `toml:"accept_proxy_protocol" json:"accept_proxy_protocol"`
+
 	// Discovery configuration
 	Discovery *DiscoveryConfig `toml:"discovery" json:"discovery"`
 
 	// Healthcheck configuration
 	Healthcheck *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
+
+	// Passive health checking configuration
+	OutlierDetection *OutlierDetection `toml:"outlier_detection" json:"outlier_detection"`
+
+	// Routes of connections to pools by sni, server's own backends are used if none matches
+	Routes []Route `toml:"routes" json:"routes"`
+
+	// Named backend pools for routes
+	Pools map[string]Pool `toml:"pools" json:"pools"`
+}
+
+/**
+ * Route of connections with matching sni to pool
+ */
+type Route struct {
+	Sni  []string `toml:"sni" json:"sni"`
+	Pool string   `toml:"pool" json:"pool"`
+}
+
+/**
+ * Backend pool with its own discovery, healthcheck and balancing
+ */
+type Pool struct {
+	Balance          string             `toml:"balance" json:"balance"`
+	Consistent       *Consistent        `toml:"consistent" json:"consistent"`
+	Discovery        *DiscoveryConfig   `toml:"discovery" json:"discovery"`
+	Healthcheck      *HealthcheckConfig `toml:"healthcheck" json:"healthcheck"`
+	OutlierDetection *OutlierDetection  `toml:"outlier_detection" json:"outlier_detection"`
 }
 
 /**
@@ -140,6 +222,37 @@ type ProxyProtocol struct {
 	Version string `toml:"version" json:"version"`
 }
 
+/**
+ * Accepting incoming ProxyProtocol headers configuration
+ */
+type AcceptProxyProtocol struct {
+	TrustedNetworks []string `toml:"trusted_networks" json:"trusted_networks"`
+	Required        bool     `toml:"required" json:"required"`
+	ReadTimeout     string   `toml:"read_timeout" json:"read_timeout"`
+}
+
+/**
+ * Outlier detection (passive health checking) options
+ */
+type OutlierDetection struct {
+	ConsecutiveFailures int     `toml:"consecutive_failures" json:"consecutive_failures"`
+	FailureRatio        float64 `toml:"failure_ratio" json:"failure_ratio"`
+	Window              string  `toml:"window" json:"window"`
+	MinRequests         int     `toml:"min_requests" json:"min_requests"`
+	EjectionTime        string  `toml:"ejection_time" json:"ejection_time"`
+	MaxEjectionTime     string  `toml:"max_ejection_time" json:"max_ejection_time"`
+	MaxEjectionPercent  int     `toml:"max_ejection_percent" json:"max_ejection_percent"`
+}
+
+/**
+ * Consistent hash balancer options
+ */
+type Consistent struct {
+	HashKey    string  `toml:"hash_key" json:"hash_key"`
+	Replicas   int     `toml:"replicas" json:"replicas"`
+	LoadFactor float64 `toml:"load_factor" json:"load_factor"`
+}
+
 /**
  * Server Sni options
  */
@@ -164,12 +277,27 @@ type tlsCommon struct {
  * for protocol = "tls"
  */
 type Tls struct {
-	AcmeHosts []string `toml:"acme_hosts" json:"acme_hosts"`
-	CertPath  string   `toml:"cert_path" json:"cert_path"`
-	KeyPath   string   `toml:"key_path" json:"key_path"`
+	AcmeHosts     []string         `toml:"acme_hosts" json:"acme_hosts"`
+	CertPath      string           `toml:"cert_path" json:"cert_path"`
+	KeyPath       string           `toml:"key_path" json:"key_path"`
+	Certificates  []TlsCertificate `toml:"certificates" json:"certificates"`
+	CertDir       string           `toml:"cert_dir" json:"cert_dir"`
+	Alpn          []string         `toml:"alpn" json:"alpn"`
+	ClientCaPath  string           `toml:"client_ca_path" json:"client_ca_path"`
+	ClientVerify  string           `toml:"client_verify" json:"client_verify"`
+	ClientCrlPath string           `toml:"client_crl_path" json:"client_crl_path"`
+	ClientAllowed []string         `toml:"client_allowed" json:"client_allowed"`
 	tlsCommon
 }
 
+/**
+ * Certificate and key pair of tls server
+ */
+type TlsCertificate struct {
+	CertPath string `toml:"cert_path" json:"cert_path"`
+	KeyPath  string `toml:"key_path" json:"key_path"`
+}
+
 type BackendsTls struct {
 	IgnoreVerify   bool    `toml:"ignore_verify" json:"ignore_verify"`
 	RootCaCertPath *string `toml:"root_ca_cert_path" json:"root_ca_cert_path"`
@@ -204,17 +332,23 @@ type DiscoveryConfig struct {
 	Failpolicy string `toml:"failpolicy" json:"failpolicy"`
 	Interval   string `toml:"interval" json:"interval"`
 	Timeout    string `toml:"timeout" json:"timeout"`
+	CacheDir   string `toml:"cache_dir" json:"cache_dir"`
 
 	/* Depends on Kind */
 
 	*StaticDiscoveryConfig
 	*SrvDiscoveryConfig
+	*DnsDiscoveryConfig
 	*DockerDiscoveryConfig
 	*JsonDiscoveryConfig
 	*ExecDiscoveryConfig
 	*PlaintextDiscoveryConfig
 	*ConsulDiscoveryConfig
 	*LXDDiscoveryConfig
+	*KubernetesDiscoveryConfig
+	*EtcdDiscoveryConfig
+	*MultiDiscoveryConfig
+	*FileDiscoveryConfig
 }
 
 type StaticDiscoveryConfig struct {
@@ -225,6 +359,18 @@ type SrvDiscoveryConfig struct {
 	SrvLookupServer  string `toml:"srv_lookup_server" json:"srv_lookup_server"`
 	SrvLookupPattern string `toml:"srv_lookup_pattern" json:"srv_lookup_pattern"`
 	SrvDnsProtocol   string `toml:"srv_dns_protocol" json:"srv_dns_protocol"`
+	SrvMinTtl        string `toml:"srv_min_ttl" json:"srv_min_ttl"`
+	SrvMaxTtl        string `toml:"srv_max_ttl" json:"srv_max_ttl"`
+}
+
+type DnsDiscoveryConfig struct {
+	DnsHostname string `toml:"dns_hostname" json:"dns_hostname"`
+	DnsPort     int    `toml:"dns_port" json:"dns_port"`
+	DnsServer   string `toml:"dns_server" json:"dns_server"`
+	DnsProtocol string `toml:"dns_protocol" json:"dns_protocol"`
+	DnsFamily   string `toml:"dns_family" json:"dns_family"`
+	DnsMinTtl   string `toml:"dns_min_ttl" json:"dns_min_ttl"`
+	DnsMaxTtl   string `toml:"dns_max_ttl" json:"dns_max_ttl"`
 }
 
 type ExecDiscoveryConfig struct {
@@ -297,6 +443,49 @@ type LXDDiscoveryConfig struct {
 	LXDContainerAddressType string `toml:"lxd_container_address_type" json:"lxd_container_address_type"`
 }
 
+type KubernetesDiscoveryConfig struct {
+	KubernetesService   string `toml:"kubernetes_service" json:"kubernetes_service"`
+	KubernetesNamespace string `toml:"kubernetes_namespace" json:"kubernetes_namespace"`
+	KubernetesPortName  string `toml:"kubernetes_port_name" json:"kubernetes_port_name"`
+	KubernetesResource  string `toml:"kubernetes_resource" json:"kubernetes_resource"`
+
+	KubernetesKubeconfig string `toml:"kubernetes_kubeconfig" json:"kubernetes_kubeconfig"`
+	KubernetesContext    string `toml:"kubernetes_context" json:"kubernetes_context"`
+
+	KubernetesAnnotationPrefix string `toml:"kubernetes_annotation_prefix" json:"kubernetes_annotation_prefix"`
+}
+
+type EtcdDiscoveryConfig struct {
+	EtcdEndpoints []string `toml:"etcd_endpoints" json:"etcd_endpoints"`
+	EtcdPrefix    string   `toml:"etcd_prefix" json:"etcd_prefix"`
+
+	EtcdUsername string `toml:"etcd_username" json:"etcd_username"`
+	EtcdPassword string `toml:"etcd_password" json:"etcd_password"`
+
+	EtcdTlsEnabled    bool   `toml:"etcd_tls_enabled" json:"etcd_tls_enabled"`
+	EtcdTlsCertPath   string `toml:"etcd_tls_cert_path" json:"etcd_tls_cert_path"`
+	EtcdTlsKeyPath    string `toml:"etcd_tls_key_path" json:"etcd_tls_key_path"`
+	EtcdTlsCacertPath string `toml:"etcd_tls_cacert_path" json:"etcd_tls_cacert_path"`
+}
+
+type FileDiscoveryConfig struct {
+	FilePath   string `toml:"file_path" json:"file_path"`
+	FileFormat string `toml:"file_format" json:"file_format"`
+}
+
+type MultiDiscoveryConfig struct {
+	MultiSources []MultiDiscoverySource `toml:"multi_sources" json:"multi_sources"`
+}
+
+/**
+ * Source of multi discovery, with defaults for its backends
+ */
+type MultiDiscoverySource struct {
+	DiscoveryConfig
+	Priority *int `toml:"priority" json:"priority,omitempty"`
+	Weight   *int `toml:"weight" json:"weight,omitempty"`
+}
+
 /**
  * Healthcheck configuration
  */
@@ -316,6 +505,7 @@ type HealthcheckConfig struct {
 	*PingHealthcheckConfig
 	*ExecHealthcheckConfig
 	*ProbeHealthcheckConfig
+	*HttpHealthcheckConfig
 }
 
 type PingHealthcheckConfig struct{}
@@ -328,6 +518,20 @@ type ProbeHealthcheckConfig struct {
 	ProbeRecvLen  int    `toml:"probe_recv_len" json:"probe_recv_len"`
 }
 
+type HttpHealthcheckConfig struct {
+	HttpScheme            string            `toml:"http_scheme" json:"http_scheme"`
+	HttpMethod            string            `toml:"http_method" json:"http_method"`
+	HttpPath              string            `toml:"http_path" json:"http_path"`
+	HttpHost              string            `toml:"http_host" json:"http_host"`
+	HttpHeaders           map[string]string `toml:"http_headers" json:"http_headers"`
+	HttpExpectedStatus    []string          `toml:"http_expected_status" json:"http_expected_status"`
+	HttpExpectedBody      string            `toml:"http_expected_body" json:"http_expected_body"`
+	HttpFollowRedirects   bool              `toml:"http_follow_redirects" json:"http_follow_redirects"`
+	HttpTlsSkipVerify     bool              `toml:"http_tls_skip_verify" json:"http_tls_skip_verify"`
+	HttpTlsServerName     string            `toml:"http_tls_server_name" json:"http_tls_server_name"`
+	HttpTlsRootCaCertPath string            `toml:"http_tls_root_ca_cert_path" json:"http_tls_root_ca_cert_path"`
+}
+
 type ExecHealthcheckConfig struct {
 	ExecCommand                string `toml:"exec_command" json:"exec_command,omitempty"`
 	ExecExpectedPositiveOutput string `toml:"exec_expected_positive_output" json:"exec_expected_positive_output"`
@@ -21,6 +21,39 @@ type Backend struct {
 	MaxConnections int          `json:"max_connections,omitempty"`
 	Sni            string       `json:"sni,omitempty"`
 	Stats          BackendStats `json:"stats"`
+
+	/* Priority and weight are defaults of 1, as discovery didn't set them */
+	PriorityUnset bool `json:"-"`
+	WeightUnset   bool `json:"-"`
+
+	/* Manual override of discovered properties, if any */
+	Override *BackendOverride `json:"override,omitempty"`
+}
+
+/**
+ * Backend states that can be set manually
+ */
+const (
+	/* Backend takes part in elections (default) */
+	BackendEnabled = "enabled"
+
+	/* Backend is not elected, but active connections are kept */
+	BackendDrain = "drain"
+
+	/* Backend is not elected and active connections are closed */
+	BackendDisabled = "disabled"
+)
+
+/**
+ * Runtime override of backend properties,
+ * layered on top of what discovery returns.
+ * Nil fields are not overridden
+ */
+type BackendOverride struct {
+	State          string `json:"state,omitempty"`
+	Weight         *int   `json:"weight,omitempty"`
+	Priority       *int   `json:"priority,omitempty"`
+	MaxConnections *int   `json:"max_connections,omitempty"`
 }
 
 /**
@@ -29,6 +62,8 @@ type Backend struct {
 type BackendStats struct {
 	Live               bool   `json:"live"`
 	Discovered         bool   `json:"discovered"`
+	Ejected            bool   `json:"ejected"`
+	Stale              bool   `json:"stale"`
 	TotalConnections   int64  `json:"total_connections"`
 	ActiveConnections  uint   `json:"active_connections"`
 	RefusedConnections uint64 `json:"refused_connections"`
@@ -58,6 +93,71 @@ func (this *Backend) MergeFrom(other Backend) *Backend {
 	return this
 }
 
+/**
+ * Check if backend may be elected, according to its override state
+ */
+func (this *Backend) Electable() bool {
+	return this.Override == nil || this.Override.State != BackendDrain && this.Override.State != BackendDisabled
+}
+
+/**
+ * Apply override on top of backend properties
+ */
+func (this *Backend) ApplyOverride(override *BackendOverride) *Backend {
+
+	this.Override = override
+
+	if override == nil {
+		return this
+	}
+
+	if override.Weight != nil {
+		this.Weight = *override.Weight
+	}
+
+	if override.Priority != nil {
+		this.Priority = *override.Priority
+	}
+
+	if override.MaxConnections != nil {
+		this.MaxConnections = *override.MaxConnections
+	}
+
+	return this
+}
+
+/**
+ * Merge another override to this one, taking only fields set in other
+ */
+func (this *BackendOverride) MergeFrom(other BackendOverride) *BackendOverride {
+
+	if other.State != "" {
+		this.State = other.State
+	}
+
+	if other.Weight != nil {
+		this.Weight = other.Weight
+	}
+
+	if other.Priority != nil {
+		this.Priority = other.Priority
+	}
+
+	if other.MaxConnections != nil {
+		this.MaxConnections = other.MaxConnections
+	}
+
+	return this
+}
+
+/**
+ * Check if override changes nothing
+ */
+func (this *BackendOverride) IsEmpty() bool {
+	return (this.State == "" || this.State == BackendEnabled) &&
+		this.Weight == nil && this.Priority == nil && this.MaxConnections == nil
+}
+
 /**
  * Get backends target address
  */
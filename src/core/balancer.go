@@ -10,3 +10,24 @@ type Balancer interface {
 	 */
 	Elect(Context, []*Backend) (*Backend, error)
 }
+
+/**
+ * Balancer keeping state built for all backends of the pool,
+ * not only for ones passed to election
+ */
+type BackendsObserver interface {
+
+	/**
+	 * Called with all discovered backends every time they or their properties change
+	 */
+	UpdateBackends([]*Backend)
+}
+
+/**
+ * Pass backends to balancer if it keeps state built for them
+ */
+func UpdateBackends(balancer Balancer, backends []*Backend) {
+	if observer, ok := balancer.(BackendsObserver); ok {
+		observer.UpdateBackends(backends)
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+/**
+ * certificate.go - loaded certificate details
+ */
+
+import (
+	"time"
+)
+
+/**
+ * Certificate loaded by server, for monitoring its expiry
+ */
+type CertificateInfo struct {
+
+	/* "tls", "client_ca", "backends_tls" or "backends_tls_root_ca" */
+	Type string `json:"type"`
+
+	/* File certificate is loaded from */
+	Path string `json:"path"`
+
+	Subject     string    `json:"subject"`
+	Names       []string  `json:"names"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Fingerprint string    `json:"fingerprint"`
+}
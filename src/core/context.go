@@ -6,13 +6,49 @@ package core
  * @author Yaroslav Pogrebnyak <yyyaroslav@gmail.com>
  */
 
-import "net"
+import (
+	"context"
+	"net"
+	"time"
+)
 
 type Context interface {
 	String() string
 	Ip() net.IP
 	Port() int
 	Sni() string
+	Client() *ClientIdentity
+	Excluded(target Target) bool
+}
+
+/**
+ * Identity of client got from its verified tls certificate
+ */
+type ClientIdentity struct {
+	Subject     string   `json:"subject"`
+	CommonName  string   `json:"common_name"`
+	DnsNames    []string `json:"dns_names"`
+	Emails      []string `json:"emails"`
+	Uris        []string `json:"uris"`
+	Fingerprint string   `json:"fingerprint"`
+}
+
+/**
+ * All names of client: common name and subject alternative names
+ */
+func (this *ClientIdentity) Names() []string {
+
+	names := []string{}
+
+	if this.CommonName != "" {
+		names = append(names, this.CommonName)
+	}
+
+	names = append(names, this.DnsNames...)
+	names = append(names, this.Emails...)
+	names = append(names, this.Uris...)
+
+	return names
 }
 
 /**
@@ -24,6 +60,27 @@ type TcpContext struct {
 	 * Current client connection
 	 */
 	Conn net.Conn
+
+	/**
+	 * Verified client identity, if client presented tls certificate
+	 */
+	Identity *ClientIdentity
+
+	/**
+	 * When client connection was accepted
+	 */
+	Accepted time.Time
+
+	/**
+	 * Context carrying trace span of client connection
+	 */
+	Trace context.Context
+
+	/**
+	 * Backends that should not be elected for this client,
+	 * for example because connecting to them has already failed
+	 */
+	Exclude map[Target]bool
 }
 
 func (t TcpContext) String() string {
@@ -42,6 +99,14 @@ func (t TcpContext) Sni() string {
 	return t.Hostname
 }
 
+func (t TcpContext) Client() *ClientIdentity {
+	return t.Identity
+}
+
+func (t TcpContext) Excluded(target Target) bool {
+	return t.Exclude[target]
+}
+
 /*
  * Proxy udp context
  */
@@ -68,3 +133,11 @@ func (u UdpContext) Port() int {
 func (u UdpContext) Sni() string {
 	return ""
 }
+
+func (u UdpContext) Client() *ClientIdentity {
+	return nil
+}
+
+func (u UdpContext) Excluded(target Target) bool {
+	return false
+}
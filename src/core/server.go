@@ -26,8 +26,44 @@ type Server interface {
 	 */
 	Stop()
 
+	/**
+	 * Stop accepting new connections, wait up to drain_timeout
+	 * for active ones to finish and then stop dropping the rest.
+	 * Returned channel is closed when server is completely stopped
+	 */
+	StopGraceful() <-chan bool
+
 	/**
 	 * Get server configuration
 	 */
 	Cfg() config.Server
+
+	/**
+	 * Apply new configuration to running server
+	 * without dropping active connections
+	 */
+	Reconfigure(config.Server) error
+
+	/**
+	 * Get current backends of pool with their stats.
+	 * Empty pool means server's own backends
+	 */
+	Backends(pool string) ([]Backend, error)
+
+	/**
+	 * Override backend properties of pool at runtime.
+	 * Active connections are closed if backend gets disabled
+	 */
+	OverrideBackend(pool string, target Target, override BackendOverride, merge bool) (*Backend, error)
+
+	/**
+	 * Get certificates currently used by server
+	 */
+	Certificates() []CertificateInfo
+
+	/**
+	 * Load certificates from files again without restarting listener.
+	 * Current certificates are kept if any fails to load
+	 */
+	ReloadCertificates() error
 }
@@ -0,0 +1,127 @@
+package discovery
+
+/**
+ * cache.go - on-disk cache of last discovered backends
+ */
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+)
+
+/**
+ * Cached backends of discovery
+ */
+type cacheEntry struct {
+	Kind     string         `json:"kind"`
+	Backends []core.Backend `json:"backends"`
+}
+
+/**
+ * Path of cache file for discovery configuration.
+ * File name depends on configuration, so backends cached
+ * for other source are never used
+ */
+func cachePath(cfg config.DiscoveryConfig) (string, error) {
+
+	dir := cfg.CacheDir
+	cfg.CacheDir = ""
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+
+	return filepath.Join(dir, cfg.Kind+"-"+hex.EncodeToString(hash[:8])+".json"), nil
+}
+
+/**
+ * Load backends from cache, marked stale
+ */
+func loadCache(cfg config.DiscoveryConfig) ([]core.Backend, error) {
+
+	path, err := cachePath(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+
+	backends := make([]core.Backend, len(entry.Backends))
+	for i, b := range entry.Backends {
+		b.Stats = core.BackendStats{
+			Live:  true,
+			Stale: true,
+		}
+		backends[i] = b
+	}
+
+	return backends, nil
+}
+
+/**
+ * Store backends to cache. File is replaced atomically,
+ * so cache is never left half written
+ */
+func storeCache(cfg config.DiscoveryConfig, backends []core.Backend) error {
+
+	path, err := cachePath(cfg)
+	if err != nil {
+		return err
+	}
+
+	stored := make([]core.Backend, len(backends))
+	for i, b := range backends {
+		b.Stats = core.BackendStats{}
+		b.Override = nil
+		stored[i] = b
+	}
+
+	data, err := json.Marshal(cacheEntry{cfg.Kind, stored})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(cfg.CacheDir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ package discovery
  */
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -22,16 +23,17 @@ import (
 const (
 	consulRetryWaitDuration = 2 * time.Second
 	consulTimeout           = 2 * time.Second
+	consulWaitTime          = 5 * time.Minute
 )
 
 /**
- * Create new Discovery with Consul fetch func
+ * Create new Discovery with Consul watch func
  */
 func NewConsulDiscovery(cfg config.DiscoveryConfig) interface{} {
 
 	d := Discovery{
 		opts:  DiscoveryOpts{consulRetryWaitDuration},
-		fetch: consulFetch,
+		watch: consulWatch,
 		cfg:   cfg,
 	}
 
@@ -39,20 +41,78 @@ func NewConsulDiscovery(cfg config.DiscoveryConfig) interface{} {
 }
 
 /**
- * Fetch backends from Consul API
+ * Watch backends using Consul blocking queries.
+ * If interval is set, it's the minimal time between queries
  */
-func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func consulWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
 
-	log := logging.For("consulFetch")
+	log := logging.For("consulWatch")
 
-	log.Info("Fetching ", cfg)
+	client, err := consulClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	interval := utils.ParseDurationOrDefault(cfg.Interval, 0)
+
+	var index uint64
+
+	for {
+		log.Info("Querying ", cfg.ConsulHost, " ", cfg.ConsulServiceName, " index ", index)
+
+		started := time.Now()
+
+		opts := &consul.QueryOptions{WaitIndex: index, WaitTime: consulWaitTime}
+		service, meta, err := client.Health().Service(cfg.ConsulServiceName, cfg.ConsulServiceTag, cfg.ConsulServicePassingOnly, opts.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		// index went backwards (e.g. consul state reset), start over
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+
+		if index == 0 || meta.LastIndex != index {
+			select {
+			case out <- consulBackends(service):
+			case <-stop:
+				return nil
+			}
+		}
+
+		index = meta.LastIndex
+
+		if wait := interval - time.Since(started); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+/**
+ * Create Consul client
+ */
+func consulClient(cfg config.DiscoveryConfig) (*consul.Client, error) {
 
 	// Prepare vars for http client
-	// TODO move http & consul client creation to constructor
 	scheme := "http"
-	transport := &http.Transport{
-		DisableKeepAlives: true,
-	}
+	transport := &http.Transport{}
 
 	// Enable tls if needed
 	if cfg.ConsulTlsEnabled {
@@ -70,11 +130,13 @@ func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		scheme = "https"
 	}
 
-	// Parse http timeout
+	// Parse http timeout. Blocking query may take wait time
+	// plus up to 1/16 of it added by Consul as jitter
 	timeout := utils.ParseDurationOrDefault(cfg.Timeout, consulTimeout)
+	timeout += consulWaitTime + consulWaitTime/16
 
 	// Create consul client
-	client, _ := consul.NewClient(&consul.Config{
+	return consul.NewClient(&consul.Config{
 		Token:      cfg.ConsulAclToken,
 		Scheme:     scheme,
 		Address:    cfg.ConsulHost,
@@ -85,12 +147,12 @@ func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		},
 		HttpClient: &http.Client{Timeout: timeout, Transport: transport},
 	})
+}
 
-	// Query service
-	service, _, err := client.Health().Service(cfg.ConsulServiceName, cfg.ConsulServiceTag, cfg.ConsulServicePassingOnly, nil)
-	if err != nil {
-		return nil, err
-	}
+/**
+ * Make backends of service entries
+ */
+func consulBackends(service []*consul.ServiceEntry) []core.Backend {
 
 	// Gather backends
 	backends := []core.Backend{}
@@ -123,8 +185,10 @@ func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 				Host: host,
 				Port: fmt.Sprintf("%v", s.Port),
 			},
-			Priority: 1,
-			Weight:   1,
+			Priority:      1,
+			Weight:        1,
+			PriorityUnset: true,
+			WeightUnset:   true,
 			Stats: core.BackendStats{
 				Live: true,
 			},
@@ -132,5 +196,5 @@ func consulFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		})
 	}
 
-	return &backends, nil
+	return backends
 }
@@ -7,6 +7,8 @@ package discovery
  */
 
 import (
+	"errors"
+	"os"
 	"time"
 
 	"github.com/yyyar/gobetween/config"
@@ -25,12 +27,17 @@ var registry = make(map[string]func(config.DiscoveryConfig) interface{})
 func init() {
 	registry["static"] = NewStaticDiscovery
 	registry["srv"] = NewSrvDiscovery
+	registry["dns"] = NewDnsDiscovery
 	registry["docker"] = NewDockerDiscovery
 	registry["json"] = NewJsonDiscovery
 	registry["exec"] = NewExecDiscovery
 	registry["plaintext"] = NewPlaintextDiscovery
 	registry["consul"] = NewConsulDiscovery
 	registry["lxd"] = NewLXDDiscovery
+	registry["kubernetes"] = NewKubernetesDiscovery
+	registry["etcd"] = NewEtcdDiscovery
+	registry["multi"] = NewMultiDiscovery
+	registry["file"] = NewFileDiscovery
 }
 
 /**
@@ -46,7 +53,57 @@ func New(strategy string, cfg config.DiscoveryConfig) *Discovery {
 type FetchFunc func(config.DiscoveryConfig) (*[]core.Backend, error)
 
 /**
- * Options for pull discovery
+ * Max time to wait before restarting failed watch.
+ * Wait starts from RetryWaitDuration and is doubled on every failure in a row
+ */
+const WATCH_MAX_RETRY_WAIT_DURATION = time.Minute
+
+/**
+ * Watch func for pushing backends.
+ * Sends backends to out every time they change, until stop is closed
+ * or an error occurs. Sending to out should be aborted on stop as well.
+ * Watch is restarted with backoff when it returns, so implementations
+ * don't need to handle reconnects themselves
+ */
+type WatchFunc func(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error
+
+/**
+ * Fetch func for pulling backends that are valid for limited time,
+ * like dns records. Returns backends and time after which they should be fetched again
+ */
+type TtlFetchFunc func(config.DiscoveryConfig) ([]core.Backend, time.Duration, error)
+
+/**
+ * Make watch func fetching backends again every time they expire.
+ * Errors are handled as for any other watch
+ */
+func ttlWatch(fetch TtlFetchFunc) WatchFunc {
+	return func(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+		for {
+			backends, ttl, err := fetch(cfg)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case out <- backends:
+			case <-stop:
+				return nil
+			}
+
+			t := time.NewTimer(ttl)
+			select {
+			case <-t.C:
+			case <-stop:
+				t.Stop()
+				return nil
+			}
+		}
+	}
+}
+
+/**
+ * Options for pull / push discovery
  */
 type DiscoveryOpts struct {
 	RetryWaitDuration time.Duration
@@ -67,6 +124,11 @@ type Discovery struct {
 	 */
 	fetch FetchFunc
 
+	/**
+	 * Function to watch backends, used instead of fetch if set
+	 */
+	watch WatchFunc
+
 	/**
 	 * Options for fetch
 	 */
@@ -77,11 +139,22 @@ type Discovery struct {
 	 */
 	cfg config.DiscoveryConfig
 
+	/**
+	 * Backends are loaded from discovery cache and not discovered yet
+	 */
+	stale bool
+
 	/**
 	 * Channel where to push newly discovered backends
 	 */
 	out chan ([]core.Backend)
 
+	/**
+	 * Channel where to report fetch and watch errors.
+	 * Errors are dropped if nobody reads them
+	 */
+	errs chan error
+
 	/**
 	 * Channel for stopping discovery
 	 */
@@ -96,8 +169,14 @@ func (this *Discovery) Start() {
 	log := logging.For("discovery")
 
 	this.out = make(chan []core.Backend)
+	this.errs = make(chan error, 1)
 	this.stop = make(chan bool)
 
+	if this.watch != nil {
+		go this.watchLoop()
+		return
+	}
+
 	// Prepare interval
 	interval, err := time.ParseDuration(this.cfg.Interval)
 	if err != nil {
@@ -106,6 +185,11 @@ func (this *Discovery) Start() {
 
 	// TODO: rewrite with channels for stop
 	go func() {
+		if !this.seed() {
+			log.Info("Stopping discovery ", this.cfg)
+			return
+		}
+
 		for {
 			backends, err := this.fetch(this.cfg)
 
@@ -118,14 +202,10 @@ func (this *Discovery) Start() {
 
 			if err != nil {
 				log.Error(this.cfg.Kind, " error ", err, " retrying in ", this.opts.RetryWaitDuration.String())
-				log.Info("Applying failpolicy ", this.cfg.Failpolicy)
-
-				if this.cfg.Failpolicy == "setempty" {
-					this.backends = &[]core.Backend{}
-					if !this.send() {
-						log.Info("Stopping discovery ", this.cfg)
-						return
-					}
+				this.report(err)
+				if !this.fail() {
+					log.Info("Stopping discovery ", this.cfg)
+					return
 				}
 
 				if !this.wait(this.opts.RetryWaitDuration) {
@@ -138,6 +218,7 @@ func (this *Discovery) Start() {
 
 			// cache
 			this.backends = backends
+			this.persist()
 			if !this.send() {
 				log.Info("Stopping discovery ", this.cfg)
 				return
@@ -157,17 +238,173 @@ func (this *Discovery) Start() {
 	}()
 }
 
+/**
+ * Push / watch backends loop.
+ * Restarts watch with exponential backoff if it fails
+ */
+func (this *Discovery) watchLoop() {
+
+	log := logging.For("discovery")
+
+	retryWait := this.opts.RetryWaitDuration
+
+	if !this.seed() {
+		log.Info("Stopping discovery ", this.cfg)
+		return
+	}
+
+	for {
+		updates := make(chan []core.Backend)
+		done := make(chan error, 1)
+
+		go func() {
+			done <- this.watch(this.cfg, updates, this.stop)
+		}()
+
+		var err error
+
+	watching:
+		for {
+			select {
+			case backends := <-updates:
+				// watch works, so next failure is retried fast again
+				retryWait = this.opts.RetryWaitDuration
+
+				this.backends = &backends
+				this.persist()
+				if !this.send() {
+					log.Info("Stopping discovery ", this.cfg)
+					return
+				}
+
+			case err = <-done:
+				break watching
+
+			case <-this.stop:
+				log.Info("Stopping discovery ", this.cfg)
+				return
+			}
+		}
+
+		select {
+		case <-this.stop:
+			log.Info("Stopping discovery ", this.cfg)
+			return
+		default:
+		}
+
+		if err == nil {
+			err = errors.New("watch closed")
+		}
+
+		log.Error(this.cfg.Kind, " error ", err, " retrying in ", retryWait.String())
+		this.report(err)
+		if !this.fail() {
+			log.Info("Stopping discovery ", this.cfg)
+			return
+		}
+
+		if !this.wait(retryWait) {
+			log.Info("Stopping discovery ", this.cfg)
+			return
+		}
+
+		retryWait *= 2
+		if retryWait > WATCH_MAX_RETRY_WAIT_DURATION {
+			retryWait = WATCH_MAX_RETRY_WAIT_DURATION
+		}
+	}
+}
+
+/**
+ * Send backends cached on disk by previous run, if any,
+ * so there are backends to serve until first fetch succeeds.
+ * Returns false if discovery was stopped
+ */
+func (this *Discovery) seed() bool {
+
+	if this.cfg.CacheDir == "" {
+		return true
+	}
+
+	log := logging.For("discovery")
+
+	backends, err := loadCache(this.cfg)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Could not load discovery cache: ", err)
+		}
+		return true
+	}
+
+	log.Info("Using ", len(backends), " stale backends from discovery cache until first fetch")
+
+	this.backends = &backends
+	this.stale = true
+	return this.send()
+}
+
+/**
+ * Apply failpolicy after failed fetch or watch. Stale backends from
+ * discovery cache are kept until first success, whatever the failpolicy.
+ * Returns false if discovery was stopped
+ */
+func (this *Discovery) fail() bool {
+
+	log := logging.For("discovery")
+
+	if this.stale {
+		log.Info("Keeping stale backends from discovery cache until first successful discovery")
+		return true
+	}
+
+	log.Info("Applying failpolicy ", this.cfg.Failpolicy)
+
+	if this.cfg.Failpolicy == "setempty" {
+		this.backends = &[]core.Backend{}
+		return this.send()
+	}
+
+	return true
+}
+
+/**
+ * Write current backends to disk cache, if enabled.
+ * Called on every successful discovery
+ */
+func (this *Discovery) persist() {
+
+	this.stale = false
+
+	if this.cfg.CacheDir == "" {
+		return
+	}
+
+	if err := storeCache(this.cfg, *this.backends); err != nil {
+		logging.For("discovery").Warn("Could not write discovery cache: ", err)
+	}
+}
+
 func (this *Discovery) send() bool {
 	// out if not stopped
 	select {
 	case <-this.stop:
 		return false
-	default:
-		this.out <- *this.backends
+	case this.out <- *this.backends:
 		return true
 	}
 }
 
+/**
+ * Report error to errors channel without blocking discovery
+ */
+func (this *Discovery) report(err error) {
+	select {
+	case this.errs <- err:
+	default:
+	}
+}
+
 /**
  * wait waits for interval or stop
  * returns true if waiting was successfull
@@ -191,10 +428,11 @@ func (this *Discovery) wait(interval time.Duration) bool {
 }
 
 /**
- * Stop discovery
+ * Stop discovery.
+ * Does not block, even if fetch loop has already exited
  */
 func (this *Discovery) Stop() {
-	this.stop <- true
+	close(this.stop)
 }
 
 /**
@@ -203,3 +441,10 @@ func (this *Discovery) Stop() {
 func (this *Discovery) Discover() <-chan []core.Backend {
 	return this.out
 }
+
+/**
+ * Returns errors channel
+ */
+func (this *Discovery) Errors() <-chan error {
+	return this.errs
+}
@@ -0,0 +1,240 @@
+package discovery
+
+/**
+ * dns.go - A/AAAA records DNS resolve discovery implementation
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/utils"
+)
+
+const (
+	dnsRetryWaitDuration  = 2 * time.Second
+	dnsDefaultWaitTimeout = 5 * time.Second
+	dnsResolvConf         = "/etc/resolv.conf"
+)
+
+/**
+ * Create new Discovery with dns fetch func
+ */
+func NewDnsDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{dnsRetryWaitDuration},
+		watch: ttlWatch(dnsFetch),
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Resolve hostname to all its addresses.
+ * Backends are valid for the least ttl of records, clamped to min and max ttl
+ */
+func dnsFetch(cfg config.DiscoveryConfig) ([]core.Backend, time.Duration, error) {
+
+	log := logging.For("dnsFetch")
+
+	log.Info("Resolving ", cfg.DnsHostname, " ", cfg.DnsFamily)
+
+	var types []uint16
+	fallback := false
+
+	switch cfg.DnsFamily {
+	case "ipv4":
+		types = []uint16{dns.TypeA}
+	case "ipv6":
+		types = []uint16{dns.TypeAAAA}
+	case "prefer_ipv4":
+		types = []uint16{dns.TypeA, dns.TypeAAAA}
+		fallback = true
+	case "prefer_ipv6":
+		types = []uint16{dns.TypeAAAA, dns.TypeA}
+		fallback = true
+	default:
+		types = []uint16{dns.TypeA, dns.TypeAAAA}
+	}
+
+	servers, err := dnsServers(cfg.DnsServer)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	timeout := utils.ParseDurationOrDefault(cfg.Timeout, dnsDefaultWaitTimeout)
+	name := dns.Fqdn(cfg.DnsHostname)
+
+	backends := []core.Backend{}
+	var ttl *uint32
+
+	for _, typ := range types {
+
+		r, err := dnsExchange(servers, cfg.DnsProtocol, timeout, name, typ)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		hosts, recordsTtl := dnsAddresses(r)
+		if ttl == nil || recordsTtl < *ttl {
+			ttl = &recordsTtl
+		}
+
+		for _, host := range hosts {
+			backends = append(backends, core.Backend{
+				Target: core.Target{
+					Host: host,
+					Port: strconv.Itoa(cfg.DnsPort),
+				},
+				Priority:      1,
+				Weight:        1,
+				PriorityUnset: true,
+				WeightUnset:   true,
+				Stats: core.BackendStats{
+					Live: true,
+				},
+			})
+		}
+
+		if fallback && len(hosts) > 0 {
+			break
+		}
+	}
+
+	return backends, dnsClampTtl(*ttl, cfg.DnsMinTtl, cfg.DnsMaxTtl), nil
+}
+
+/**
+ * Get addresses and the least ttl from response.
+ * For empty response ttl of negative answer from SOA record is used
+ */
+func dnsAddresses(r *dns.Msg) ([]string, uint32) {
+
+	hosts := []string{}
+	var ttl *uint32
+
+	for _, rr := range r.Answer {
+
+		if ttl == nil || rr.Header().Ttl < *ttl {
+			t := rr.Header().Ttl
+			ttl = &t
+		}
+
+		switch record := rr.(type) {
+		case *dns.A:
+			hosts = append(hosts, record.A.String())
+		case *dns.AAAA:
+			hosts = append(hosts, fmt.Sprintf("[%s]", record.AAAA.String()))
+		}
+	}
+
+	if ttl != nil {
+		return hosts, *ttl
+	}
+
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if soa.Minttl < soa.Hdr.Ttl {
+				return hosts, soa.Minttl
+			}
+			return hosts, soa.Hdr.Ttl
+		}
+	}
+
+	return hosts, 0
+}
+
+/**
+ * Clamp records ttl to min and max
+ */
+func dnsClampTtl(ttl uint32, min string, max string) time.Duration {
+
+	result := time.Duration(ttl) * time.Second
+
+	if minTtl := utils.ParseDurationOrDefault(min, 0); result < minTtl {
+		result = minTtl
+	}
+
+	if maxTtl := utils.ParseDurationOrDefault(max, 0); maxTtl > 0 && result > maxTtl {
+		result = maxTtl
+	}
+
+	return result
+}
+
+/**
+ * Get servers to query: configured one, or system resolvers
+ */
+func dnsServers(server string) ([]string, error) {
+
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		return []string{server}, nil
+	}
+
+	conf, err := dns.ClientConfigFromFile(dnsResolvConf)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []string{}
+	for _, s := range conf.Servers {
+		servers = append(servers, net.JoinHostPort(s, conf.Port))
+	}
+
+	if len(servers) == 0 {
+		return nil, errors.New("No nameservers in " + dnsResolvConf)
+	}
+
+	return servers, nil
+}
+
+/**
+ * Query servers in order until one of them responds.
+ * Truncated udp responses are queried again over tcp
+ */
+func dnsExchange(servers []string, protocol string, timeout time.Duration, name string, typ uint16) (*dns.Msg, error) {
+
+	m := dns.Msg{}
+	m.SetQuestion(name, typ)
+	m.SetEdns0(srvUdpSize, true)
+
+	var err error
+
+	for _, server := range servers {
+
+		c := dns.Client{Net: protocol, Timeout: timeout}
+
+		var r *dns.Msg
+		r, _, err = c.Exchange(&m, server)
+
+		if err == nil && r.Truncated && protocol != "tcp" {
+			c.Net = "tcp"
+			r, _, err = c.Exchange(&m, server)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		switch r.Rcode {
+		case dns.RcodeSuccess, dns.RcodeNameError:
+			return r, nil
+		}
+
+		err = errors.New("Query " + name + " " + dns.TypeToString[typ] + " to " + server + " failed: " + dns.RcodeToString[r.Rcode])
+	}
+
+	return nil, err
+}
@@ -25,13 +25,26 @@ const (
 )
 
 /**
- * Create new Discovery with Docker fetch func
+ * Container event actions that may change backends
+ */
+var dockerEventActions = map[string]bool{
+	"start":   true,
+	"die":     true,
+	"destroy": true,
+	"pause":   true,
+	"unpause": true,
+	"rename":  true,
+	"update":  true,
+}
+
+/**
+ * Create new Discovery with Docker watch func
  */
 func NewDockerDiscovery(cfg config.DiscoveryConfig) interface{} {
 
 	d := Discovery{
 		opts:  DiscoveryOpts{dockerRetryWaitDuration},
-		fetch: dockerFetch,
+		watch: dockerWatch,
 		cfg:   cfg,
 	}
 
@@ -39,13 +52,64 @@ func NewDockerDiscovery(cfg config.DiscoveryConfig) interface{} {
 }
 
 /**
- * Fetch backends from Docker API
+ * Watch backends using Docker events API.
+ * Containers are listed on start and every time container events arrive
  */
-func dockerFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func dockerWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("dockerWatch")
+
+	client, err := dockerClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// events stream is connected asynchronously, so it's requested since the time
+	// before first listing, and events of changes made meanwhile are replayed by docker
+	since := time.Now()
+	events := make(chan *docker.APIEvents, 64)
+	err = client.AddEventListenerWithOptions(docker.EventsOptions{
+		Since:   fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond()),
+		Filters: map[string][]string{"type": {"container"}},
+	}, events)
+	if err != nil {
+		return err
+	}
+	defer client.RemoveEventListener(events)
+
+	for {
+		log.Info("Fetching ", cfg.DockerEndpoint, " ", cfg.DockerContainerLabel, " ", cfg.DockerContainerPrivatePort)
+
+		backends, err := dockerFetch(client, cfg)
+		if err != nil {
+			return err
+		}
 
-	log := logging.For("dockerFetch")
+		select {
+		case out <- backends:
+		case <-stop:
+			return nil
+		}
 
-	log.Info("Fetching ", cfg.DockerEndpoint, " ", cfg.DockerContainerLabel, " ", cfg.DockerContainerPrivatePort)
+		// wait for relevant event
+		for changed := false; !changed; {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return errors.New("Docker events stream closed")
+				}
+				changed = dockerEventActions[event.Action]
+			case <-stop:
+				return nil
+			}
+		}
+	}
+}
+
+/**
+ * Create Docker API client
+ */
+func dockerClient(cfg config.DiscoveryConfig) (*docker.Client, error) {
 
 	var client *docker.Client
 	var err error
@@ -71,6 +135,14 @@ func dockerFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 	/* Set timeout */
 	client.HTTPClient.Timeout = utils.ParseDurationOrDefault(cfg.Timeout, dockerTimeout)
 
+	return client, nil
+}
+
+/**
+ * Fetch backends from Docker API
+ */
+func dockerFetch(client *docker.Client, cfg config.DiscoveryConfig) ([]core.Backend, error) {
+
 	/* Add filter labels if any */
 	var filters map[string][]string
 	if cfg.DockerContainerLabel != "" {
@@ -101,8 +173,10 @@ func dockerFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 					Host: containerHost,
 					Port: fmt.Sprintf("%v", port.PublicPort),
 				},
-				Priority: 1,
-				Weight:   1,
+				Priority:      1,
+				Weight:        1,
+				PriorityUnset: true,
+				WeightUnset:   true,
 				Stats: core.BackendStats{
 					Live: true,
 				},
@@ -111,7 +185,7 @@ func dockerFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		}
 	}
 
-	return &backends, nil
+	return backends, nil
 }
 
 /**
@@ -0,0 +1,257 @@
+package discovery
+
+/**
+ * etcd.go - etcd v3 key prefix discovery implementation
+ */
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/utils"
+	"github.com/yyyar/gobetween/utils/parsers"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	etcdRetryWaitDuration = 2 * time.Second
+	etcdTimeout           = 5 * time.Second
+)
+
+/**
+ * Create new Discovery with etcd watch func
+ */
+func NewEtcdDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{etcdRetryWaitDuration},
+		watch: etcdWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Backend stored as json object value
+ */
+type etcdBackend struct {
+	Host           string      `json:"host"`
+	Port           json.Number `json:"port"`
+	Weight         *int        `json:"weight"`
+	Priority       *int        `json:"priority"`
+	MaxConnections int         `json:"max_connections"`
+	Sni            string      `json:"sni"`
+}
+
+/**
+ * Watch keys under prefix, every key holds single backend.
+ * Gets all keys, then watches changes from the revision they were got at
+ */
+func etcdWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("etcdWatch")
+
+	timeout := utils.ParseDurationOrDefault(cfg.Timeout, etcdTimeout)
+
+	clientConfig := clientv3.Config{
+		Endpoints:   cfg.EtcdEndpoints,
+		DialTimeout: timeout,
+		Username:    cfg.EtcdUsername,
+		Password:    cfg.EtcdPassword,
+	}
+
+	if cfg.EtcdTlsEnabled {
+		tlsInfo := transport.TLSInfo{
+			CertFile:      cfg.EtcdTlsCertPath,
+			KeyFile:       cfg.EtcdTlsKeyPath,
+			TrustedCAFile: cfg.EtcdTlsCacertPath,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return err
+		}
+		clientConfig.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	publish := func(backends map[string]core.Backend) bool {
+
+		result := make([]core.Backend, 0, len(backends))
+		for _, backend := range backends {
+			result = append(result, backend)
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Target.String() < result[j].Target.String()
+		})
+
+		select {
+		case out <- result:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	for {
+		log.Info("Getting ", cfg.EtcdEndpoints, " ", cfg.EtcdPrefix)
+
+		getCtx, getCancel := context.WithTimeout(ctx, timeout)
+		resp, err := client.Get(getCtx, cfg.EtcdPrefix, clientv3.WithPrefix())
+		getCancel()
+
+		if err != nil {
+			return err
+		}
+
+		backends := map[string]core.Backend{}
+		for _, kv := range resp.Kvs {
+			etcdApply(backends, string(kv.Key), kv.Value)
+		}
+
+		if !publish(backends) {
+			return nil
+		}
+
+		watch := client.Watch(clientv3.WithRequireLeader(ctx), cfg.EtcdPrefix,
+			clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+
+		err = nil
+		for wresp := range watch {
+
+			if wresp.CompactRevision != 0 {
+				log.Info("Revision ", resp.Header.Revision+1, " is compacted, getting keys again")
+				err = rpctypes.ErrCompacted
+				break
+			}
+
+			if err = wresp.Err(); err != nil {
+				break
+			}
+
+			for _, event := range wresp.Events {
+				if event.Type == clientv3.EventTypeDelete {
+					delete(backends, string(event.Kv.Key))
+				} else {
+					etcdApply(backends, string(event.Kv.Key), event.Kv.Value)
+				}
+			}
+
+			if !publish(backends) {
+				return nil
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		if err == rpctypes.ErrCompacted {
+			continue
+		}
+
+		if err == nil {
+			err = errors.New("Watch closed")
+		}
+
+		return err
+	}
+}
+
+/**
+ * Parse key value and put backend to backends,
+ * or remove key from backends if value is not valid
+ */
+func etcdApply(backends map[string]core.Backend, key string, value []byte) {
+
+	log := logging.For("etcdWatch")
+
+	backend, err := etcdParseBackend(value)
+	if err != nil {
+		log.Warn("Skipping key ", key, ": ", err)
+		delete(backends, key)
+		return
+	}
+
+	backends[key] = *backend
+}
+
+/**
+ * Parse backend from json object, or line in default backend format
+ */
+func etcdParseBackend(value []byte) (*core.Backend, error) {
+
+	value = bytes.TrimSpace(value)
+
+	if !bytes.HasPrefix(value, []byte("{")) {
+		return parsers.ParseBackendDefault(string(value))
+	}
+
+	var b etcdBackend
+	if err := json.Unmarshal(value, &b); err != nil {
+		return nil, err
+	}
+
+	if b.Host == "" || b.Port == "" {
+		return nil, errors.New("host and port are required")
+	}
+
+	if _, err := strconv.ParseUint(b.Port.String(), 10, 16); err != nil {
+		return nil, errors.New("Invalid port " + b.Port.String())
+	}
+
+	backend := core.Backend{
+		Target: core.Target{
+			Host: b.Host,
+			Port: b.Port.String(),
+		},
+		Priority:       1,
+		Weight:         1,
+		PriorityUnset:  b.Priority == nil,
+		WeightUnset:    b.Weight == nil,
+		MaxConnections: b.MaxConnections,
+		Sni:            b.Sni,
+		Stats: core.BackendStats{
+			Live: true,
+		},
+	}
+
+	if b.Weight != nil {
+		backend.Weight = *b.Weight
+	}
+
+	if b.Priority != nil {
+		backend.Priority = *b.Priority
+	}
+
+	return &backend, nil
+}
@@ -0,0 +1,138 @@
+package discovery
+
+/**
+ * file.go - local file discovery implementation
+ */
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/utils"
+	"github.com/yyyar/gobetween/utils/parsers"
+	"github.com/yyyar/gobetween/utils/watcher"
+)
+
+const (
+	fileRetryWaitDuration = 2 * time.Second
+)
+
+/**
+ * Create new Discovery with file watch func
+ */
+func NewFileDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	if cfg.FileFormat == "json" {
+		cfg.JsonDiscoveryConfig = jsonPatterns(cfg.JsonDiscoveryConfig)
+	}
+
+	d := Discovery{
+		opts:  DiscoveryOpts{fileRetryWaitDuration},
+		watch: fileWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Read backends from file and read them again every time file changes.
+ * If changed file is not valid, it's rejected as a whole and last good backends are kept.
+ * If interval is set, file is also read every interval, for file systems without change notifications
+ */
+func fileWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("fileWatch")
+
+	// watching is stopped on return, as failed attempts are retried
+	done := make(chan bool)
+	defer close(done)
+
+	changes, err := watcher.Watch(done, cfg.FilePath)
+	if err != nil {
+		return err
+	}
+
+	// read first time after watching is started, so no change is missed
+	backends, err := fileRead(cfg)
+	if err != nil {
+		return err
+	}
+
+	var tick <-chan time.Time
+	if interval := utils.ParseDurationOrDefault(cfg.Interval, 0); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case out <- backends:
+		case <-stop:
+			return nil
+		}
+
+		for {
+			select {
+			case <-changes:
+			case <-tick:
+			case <-stop:
+				return nil
+			}
+
+			backends, err = fileRead(cfg)
+			if err == nil {
+				break
+			}
+
+			log.Error("Keeping last backends, could not read ", cfg.FilePath, ": ", err)
+		}
+	}
+}
+
+/**
+ * Read and parse backends from file
+ */
+func fileRead(cfg config.DiscoveryConfig) ([]core.Backend, error) {
+
+	content, err := os.ReadFile(cfg.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.FileFormat == "json" {
+		backends, err := jsonParseBackends(content, cfg.JsonDiscoveryConfig)
+		if err != nil {
+			return nil, err
+		}
+		if backends == nil {
+			backends = []core.Backend{}
+		}
+		return backends, nil
+	}
+
+	backends := []core.Backend{}
+
+	for i, line := range strings.Split(string(content), "\n") {
+
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		backend, err := parsers.ParseBackendDefault(line)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", i+1, err)
+		}
+
+		backends = append(backends, *backend)
+	}
+
+	return backends, nil
+}
@@ -38,39 +38,54 @@ const (
  */
 func NewJsonDiscovery(cfg config.DiscoveryConfig) interface{} {
 
-	/* replace with defaults if needed */
+	cfg.JsonDiscoveryConfig = jsonPatterns(cfg.JsonDiscoveryConfig)
 
-	if cfg.JsonHostPattern == "" {
-		cfg.JsonHostPattern = jsonDefaultHostPattern
+	d := Discovery{
+		opts:  DiscoveryOpts{jsonRetryWaitDuration},
+		fetch: jsonFetch,
+		cfg:   cfg,
 	}
 
-	if cfg.JsonPortPattern == "" {
-		cfg.JsonPortPattern = jsonDefaultPortPattern
+	return &d
+}
+
+/**
+ * Returns json config with default patterns set where not specified
+ */
+func jsonPatterns(cfg *config.JsonDiscoveryConfig) *config.JsonDiscoveryConfig {
+
+	result := config.JsonDiscoveryConfig{}
+	if cfg != nil {
+		result = *cfg
 	}
 
-	if cfg.JsonWeightPattern == "" {
-		cfg.JsonWeightPattern = jsonDefaultWeightPattern
+	/* replace with defaults if needed */
+
+	if result.JsonHostPattern == "" {
+		result.JsonHostPattern = jsonDefaultHostPattern
 	}
 
-	if cfg.JsonPriorityPattern == "" {
-		cfg.JsonPriorityPattern = jsonDefaultPriorityPattern
+	if result.JsonPortPattern == "" {
+		result.JsonPortPattern = jsonDefaultPortPattern
 	}
 
-	if cfg.JsonSniPattern == "" {
-		cfg.JsonSniPattern = jsonDefaultSniPattern
+	if result.JsonWeightPattern == "" {
+		result.JsonWeightPattern = jsonDefaultWeightPattern
 	}
 
-	if cfg.JsonMaxConnectionsPattern == "" {
-		cfg.JsonMaxConnectionsPattern = jsonDefaultMaxConnectionsPattern
+	if result.JsonPriorityPattern == "" {
+		result.JsonPriorityPattern = jsonDefaultPriorityPattern
 	}
 
-	d := Discovery{
-		opts:  DiscoveryOpts{jsonRetryWaitDuration},
-		fetch: jsonFetch,
-		cfg:   cfg,
+	if result.JsonSniPattern == "" {
+		result.JsonSniPattern = jsonDefaultSniPattern
 	}
 
-	return &d
+	if result.JsonMaxConnectionsPattern == "" {
+		result.JsonMaxConnectionsPattern = jsonDefaultMaxConnectionsPattern
+	}
+
+	return &result
 }
 
 /**
@@ -98,6 +113,21 @@ func jsonFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		return nil, err
 	}
 
+	backends, err := jsonParseBackends(content, cfg.JsonDiscoveryConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info(backends)
+
+	return &backends, nil
+}
+
+/**
+ * Parse backends from json array using patterns
+ */
+func jsonParseBackends(content []byte, cfg *config.JsonDiscoveryConfig) ([]core.Backend, error) {
+
 	// Build query
 	parsed, err := gojq.NewStringQuery(string(content))
 	if err != nil {
@@ -117,8 +147,10 @@ func jsonFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		var key = "[" + strconv.Itoa(k) + "]."
 
 		backend := core.Backend{
-			Weight:   1,
-			Priority: 1,
+			Weight:        1,
+			Priority:      1,
+			WeightUnset:   true,
+			PriorityUnset: true,
 			Stats: core.BackendStats{
 				Live: true,
 			},
@@ -139,10 +171,12 @@ func jsonFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 		if weight, err := parsed.QueryToInt64(key + cfg.JsonWeightPattern); err == nil {
 			backend.Weight = int(weight)
+			backend.WeightUnset = false
 		}
 
 		if priority, err := parsed.QueryToFloat64(key + cfg.JsonPriorityPattern); err == nil {
 			backend.Priority = int(priority)
+			backend.PriorityUnset = false
 		}
 
 		if sni, err := parsed.QueryToString(key + cfg.JsonSniPattern); err == nil {
@@ -156,7 +190,5 @@ func jsonFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		backends = append(backends, backend)
 	}
 
-	log.Info(backends)
-
-	return &backends, nil
+	return backends, nil
 }
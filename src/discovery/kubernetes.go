@@ -0,0 +1,866 @@
+package discovery
+
+/**
+ * kubernetes.go - Kubernetes EndpointSlices / Endpoints discovery implementation
+ */
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/utils"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	kubernetesRetryWaitDuration = 2 * time.Second
+	kubernetesTimeout           = 10 * time.Second
+	kubernetesWatchTimeout      = 5 * time.Minute
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+/**
+ * Returned by watch when resource version is too old and resources should be listed again
+ */
+var errKubernetesGone = errors.New("resource version is gone")
+
+/**
+ * Create new Discovery with Kubernetes watch func
+ */
+func NewKubernetesDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{kubernetesRetryWaitDuration},
+		watch: kubernetesWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Watch endpoints of the service.
+ * Lists resources, then streams changes until watch times out and lists again
+ */
+func kubernetesWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("kubernetesWatch")
+
+	client, namespace, err := newKubernetesClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	w := &kubernetesWatcher{
+		cfg:       cfg,
+		client:    client,
+		namespace: namespace,
+		timeout:   utils.ParseDurationOrDefault(cfg.Timeout, kubernetesTimeout),
+		out:       out,
+		stop:      stop,
+	}
+
+	for {
+		log.Info("Listing ", cfg.KubernetesResource, " of ", namespace, "/", cfg.KubernetesService)
+
+		version, err := w.list(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !w.publish(ctx) {
+			return nil
+		}
+
+		err = w.watch(ctx, version)
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		if err == errKubernetesGone {
+			log.Info("Resource version ", version, " is gone, listing again")
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/**
+ * Endpoint of the service, as found in EndpointSlice or Endpoints
+ */
+type kubernetesEndpoint struct {
+	address string
+	port    int
+	pod     *kubernetesObjectRef
+}
+
+type kubernetesObjectRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Uid       string `json:"uid"`
+}
+
+type kubernetesMetadata struct {
+	Name            string            `json:"name"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Annotations     map[string]string `json:"annotations"`
+}
+
+type kubernetesPort struct {
+	Name *string `json:"name"`
+	Port *int    `json:"port"`
+}
+
+type kubernetesEndpointSlice struct {
+	Metadata  kubernetesMetadata `json:"metadata"`
+	Endpoints []struct {
+		Addresses  []string `json:"addresses"`
+		Conditions struct {
+			Ready *bool `json:"ready"`
+		} `json:"conditions"`
+		TargetRef *kubernetesObjectRef `json:"targetRef"`
+	} `json:"endpoints"`
+	Ports []kubernetesPort `json:"ports"`
+}
+
+type kubernetesEndpoints struct {
+	Metadata kubernetesMetadata `json:"metadata"`
+	Subsets  []struct {
+		Addresses []struct {
+			Ip        string               `json:"ip"`
+			TargetRef *kubernetesObjectRef `json:"targetRef"`
+		} `json:"addresses"`
+		Ports []kubernetesPort `json:"ports"`
+	} `json:"subsets"`
+}
+
+type kubernetesList struct {
+	Metadata kubernetesMetadata `json:"metadata"`
+	Items    []json.RawMessage  `json:"items"`
+}
+
+type kubernetesEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type kubernetesStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type kubernetesPod struct {
+	Metadata kubernetesMetadata `json:"metadata"`
+}
+
+/**
+ * Watches endpoints of single service and publishes them as backends
+ */
+type kubernetesWatcher struct {
+	cfg       config.DiscoveryConfig
+	client    *kubernetesClient
+	namespace string
+	timeout   time.Duration
+
+	/* Endpoints by name of EndpointSlice / Endpoints object */
+	objects map[string][]kubernetesEndpoint
+
+	/* Pod annotations cache by pod uid, cleared on every list and for pods of changed objects */
+	pods map[string]map[string]string
+
+	/* Last published backends */
+	last []core.Backend
+
+	out  chan<- []core.Backend
+	stop <-chan bool
+}
+
+/**
+ * Path and selector of watched resource
+ */
+func (this *kubernetesWatcher) resource() (string, url.Values) {
+
+	ns := url.PathEscape(this.namespace)
+
+	if this.cfg.KubernetesResource == "endpoints" {
+		return "/api/v1/namespaces/" + ns + "/endpoints",
+			url.Values{"fieldSelector": {"metadata.name=" + this.cfg.KubernetesService}}
+	}
+
+	return "/apis/discovery.k8s.io/v1/namespaces/" + ns + "/endpointslices",
+		url.Values{"labelSelector": {"kubernetes.io/service-name=" + this.cfg.KubernetesService}}
+}
+
+/**
+ * List all objects and return resource version to watch from
+ */
+func (this *kubernetesWatcher) list(ctx context.Context) (string, error) {
+
+	path, query := this.resource()
+
+	ctx, cancel := context.WithTimeout(ctx, this.timeout)
+	defer cancel()
+
+	resp, err := this.client.get(ctx, path, query)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list kubernetesList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", err
+	}
+
+	this.objects = map[string][]kubernetesEndpoint{}
+	this.pods = map[string]map[string]string{}
+
+	for _, item := range list.Items {
+		name, endpoints, err := this.parse(item)
+		if err != nil {
+			return "", err
+		}
+		this.objects[name] = endpoints
+	}
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+/**
+ * Stream changes starting from resource version, publishing backends on every change.
+ * Returns nil when watch times out
+ */
+func (this *kubernetesWatcher) watch(ctx context.Context, version string) error {
+
+	path, query := this.resource()
+	query.Set("watch", "1")
+	query.Set("resourceVersion", version)
+	query.Set("allowWatchBookmarks", "true")
+	query.Set("timeoutSeconds", strconv.Itoa(int(kubernetesWatchTimeout/time.Second)))
+
+	ctx, cancel := context.WithTimeout(ctx, kubernetesWatchTimeout+this.timeout)
+	defer cancel()
+
+	resp, err := this.client.get(ctx, path, query)
+	if err != nil {
+		if status, ok := err.(*kubernetesStatusError); ok && status.code == http.StatusGone {
+			return errKubernetesGone
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+
+	for {
+		var event kubernetesEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch event.Type {
+
+		case "ADDED", "MODIFIED", "DELETED":
+			name, endpoints, err := this.parse(event.Object)
+			if err != nil {
+				return err
+			}
+
+			// annotations may have changed along with pods, so they are read again
+			this.forget(this.objects[name])
+			this.forget(endpoints)
+
+			if event.Type == "DELETED" {
+				delete(this.objects, name)
+			} else {
+				this.objects[name] = endpoints
+			}
+
+			if !this.publish(ctx) {
+				return nil
+			}
+
+		case "BOOKMARK":
+
+		case "ERROR":
+			var status kubernetesStatus
+			if err := json.Unmarshal(event.Object, &status); err != nil {
+				return err
+			}
+			if status.Code == http.StatusGone {
+				return errKubernetesGone
+			}
+			return fmt.Errorf("Watch error %d: %s", status.Code, status.Message)
+
+		default:
+			return errors.New("Unexpected watch event type " + event.Type)
+		}
+	}
+}
+
+/**
+ * Parse EndpointSlice or Endpoints object into ready endpoints matching port name
+ */
+func (this *kubernetesWatcher) parse(raw json.RawMessage) (string, []kubernetesEndpoint, error) {
+
+	endpoints := []kubernetesEndpoint{}
+
+	if this.cfg.KubernetesResource == "endpoints" {
+
+		var object kubernetesEndpoints
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return "", nil, err
+		}
+
+		for _, subset := range object.Subsets {
+			port, ok := kubernetesMatchPort(this.cfg.KubernetesPortName, subset.Ports)
+			if !ok {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				endpoints = append(endpoints, kubernetesEndpoint{address.Ip, port, address.TargetRef})
+			}
+		}
+
+		return object.Metadata.Name, endpoints, nil
+	}
+
+	var object kubernetesEndpointSlice
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return "", nil, err
+	}
+
+	port, ok := kubernetesMatchPort(this.cfg.KubernetesPortName, object.Ports)
+	if !ok {
+		return object.Metadata.Name, endpoints, nil
+	}
+
+	for _, endpoint := range object.Endpoints {
+		// ready condition is considered true when unknown
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, address := range endpoint.Addresses {
+			endpoints = append(endpoints, kubernetesEndpoint{address, port, endpoint.TargetRef})
+		}
+	}
+
+	return object.Metadata.Name, endpoints, nil
+}
+
+/**
+ * Find port by name. Empty name matches unnamed port, or the only port
+ */
+func kubernetesMatchPort(name string, ports []kubernetesPort) (int, bool) {
+
+	for _, port := range ports {
+		portName := ""
+		if port.Name != nil {
+			portName = *port.Name
+		}
+		if portName == name && port.Port != nil {
+			return *port.Port, true
+		}
+	}
+
+	if name == "" && len(ports) == 1 && ports[0].Port != nil {
+		return *ports[0].Port, true
+	}
+
+	return 0, false
+}
+
+/**
+ * Publish current backends if they changed.
+ * Returns false if discovery was stopped
+ */
+func (this *kubernetesWatcher) publish(ctx context.Context) bool {
+
+	backends := this.backends(ctx)
+
+	if this.last != nil && reflect.DeepEqual(this.last, backends) {
+		return true
+	}
+
+	this.last = backends
+
+	select {
+	case this.out <- backends:
+		return true
+	case <-this.stop:
+		return false
+	}
+}
+
+/**
+ * Make backends of all current endpoints, applying pod annotations
+ */
+func (this *kubernetesWatcher) backends(ctx context.Context) []core.Backend {
+
+	log := logging.For("kubernetesWatch")
+
+	backends := []core.Backend{}
+	seen := map[core.Target]bool{}
+	used := map[string]bool{}
+
+	for _, endpoints := range this.objects {
+		for _, endpoint := range endpoints {
+
+			target := core.Target{
+				Host: endpoint.address,
+				Port: strconv.Itoa(endpoint.port),
+			}
+
+			// same endpoint may transiently be in two slices
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+
+			backend := core.Backend{
+				Target:        target,
+				Priority:      1,
+				Weight:        1,
+				PriorityUnset: true,
+				WeightUnset:   true,
+				Stats: core.BackendStats{
+					Live: true,
+				},
+			}
+
+			if endpoint.pod != nil && endpoint.pod.Kind == "Pod" {
+				used[endpoint.pod.Uid] = true
+				annotations := this.annotations(ctx, endpoint.pod)
+				prefix := this.cfg.KubernetesAnnotationPrefix
+
+				if v, ok := annotations[prefix+"weight"]; ok {
+					if weight, err := strconv.Atoi(v); err == nil && weight >= 0 {
+						backend.Weight = weight
+						backend.WeightUnset = false
+					} else {
+						log.Warn("Invalid ", prefix, "weight annotation ", v, " of pod ", endpoint.pod.Name)
+					}
+				}
+
+				if v, ok := annotations[prefix+"priority"]; ok {
+					if priority, err := strconv.Atoi(v); err == nil && priority >= 0 {
+						backend.Priority = priority
+						backend.PriorityUnset = false
+					} else {
+						log.Warn("Invalid ", prefix, "priority annotation ", v, " of pod ", endpoint.pod.Name)
+					}
+				}
+
+				if v, ok := annotations[prefix+"max-connections"]; ok {
+					if maxConnections, err := strconv.Atoi(v); err == nil && maxConnections >= 0 {
+						backend.MaxConnections = maxConnections
+					} else {
+						log.Warn("Invalid ", prefix, "max-connections annotation ", v, " of pod ", endpoint.pod.Name)
+					}
+				}
+
+				backend.Sni = annotations[prefix+"sni"]
+			}
+
+			backends = append(backends, backend)
+		}
+	}
+
+	for uid := range this.pods {
+		if !used[uid] {
+			delete(this.pods, uid)
+		}
+	}
+
+	sort.Slice(backends, func(i, j int) bool {
+		if backends[i].Host == backends[j].Host {
+			return backends[i].Port < backends[j].Port
+		}
+		return backends[i].Host < backends[j].Host
+	})
+
+	return backends
+}
+
+/**
+ * Drop cached annotations of pods of endpoints
+ */
+func (this *kubernetesWatcher) forget(endpoints []kubernetesEndpoint) {
+
+	for _, endpoint := range endpoints {
+		if endpoint.pod != nil {
+			delete(this.pods, endpoint.pod.Uid)
+		}
+	}
+}
+
+/**
+ * Get annotations of the pod, cached by pod uid.
+ * Pods that can't be fetched are treated as having no annotations
+ * and are fetched again next time
+ */
+func (this *kubernetesWatcher) annotations(ctx context.Context, ref *kubernetesObjectRef) map[string]string {
+
+	log := logging.For("kubernetesWatch")
+
+	if annotations, ok := this.pods[ref.Uid]; ok {
+		return annotations
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = this.namespace
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, this.timeout)
+	defer cancel()
+
+	pod := kubernetesPod{}
+
+	resp, err := this.client.get(ctx, "/api/v1/namespaces/"+url.PathEscape(namespace)+"/pods/"+url.PathEscape(ref.Name), nil)
+	if err == nil {
+		err = json.NewDecoder(resp.Body).Decode(&pod)
+		resp.Body.Close()
+	}
+
+	if err != nil {
+		log.Warn("Could not get annotations of pod ", namespace, "/", ref.Name, ": ", err)
+		return nil
+	}
+
+	this.pods[ref.Uid] = pod.Metadata.Annotations
+
+	return pod.Metadata.Annotations
+}
+
+/* ----- API client ----- */
+
+/**
+ * Non-200 response of Kubernetes API
+ */
+type kubernetesStatusError struct {
+	code    int
+	message string
+}
+
+func (this *kubernetesStatusError) Error() string {
+	return fmt.Sprintf("Kubernetes API responded %d: %s", this.code, this.message)
+}
+
+/**
+ * Minimal Kubernetes API client
+ */
+type kubernetesClient struct {
+	server string
+	http   *http.Client
+
+	token     string
+	tokenFile string
+	username  string
+	password  string
+}
+
+/**
+ * Subset of kubeconfig file used for authentication
+ */
+type kubernetesKubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			TlsServerName            string `yaml:"tls-server-name"`
+			InsecureSkipTlsVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			Username              string `yaml:"username"`
+			Password              string `yaml:"password"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster   string `yaml:"cluster"`
+			User      string `yaml:"user"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+/**
+ * Create client from kubeconfig if configured, or from in-cluster service account.
+ * Also returns namespace to watch
+ */
+func newKubernetesClient(cfg config.DiscoveryConfig) (*kubernetesClient, string, error) {
+
+	if cfg.KubernetesKubeconfig != "" {
+		return newKubernetesKubeconfigClient(cfg)
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", errors.New("Not running in cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set. Set kubernetes_kubeconfig")
+	}
+
+	ca, err := os.ReadFile(filepath.Join(kubernetesServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	tlsConfig := &tls.Config{RootCAs: x509.NewCertPool()}
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+		return nil, "", errors.New("Could not parse service account ca.crt")
+	}
+
+	namespace := cfg.KubernetesNamespace
+	if namespace == "" {
+		if data, err := os.ReadFile(filepath.Join(kubernetesServiceAccountDir, "namespace")); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	return &kubernetesClient{
+		server:    "https://" + net.JoinHostPort(host, port),
+		http:      kubernetesHttpClient(tlsConfig),
+		tokenFile: filepath.Join(kubernetesServiceAccountDir, "token"),
+	}, namespace, nil
+}
+
+/**
+ * Create client from kubeconfig file.
+ * Token, basic and client certificate auth are supported
+ */
+func newKubernetesKubeconfigClient(cfg config.DiscoveryConfig) (*kubernetesClient, string, error) {
+
+	data, err := os.ReadFile(cfg.KubernetesKubeconfig)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var kubeconfig kubernetesKubeconfig
+	if err := yaml.Unmarshal(data, &kubeconfig); err != nil {
+		return nil, "", fmt.Errorf("Could not parse kubeconfig %s: %v", cfg.KubernetesKubeconfig, err)
+	}
+
+	// relative paths in kubeconfig are relative to its directory
+	dir := filepath.Dir(cfg.KubernetesKubeconfig)
+	readFileOrData := func(path, data string) ([]byte, error) {
+		if data != "" {
+			return base64.StdEncoding.DecodeString(data)
+		}
+		if path == "" {
+			return nil, nil
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		return os.ReadFile(path)
+	}
+
+	contextName := cfg.KubernetesContext
+	if contextName == "" {
+		contextName = kubeconfig.CurrentContext
+	}
+
+	client := &kubernetesClient{}
+	namespace := cfg.KubernetesNamespace
+	tlsConfig := &tls.Config{}
+
+	found := false
+	for _, c := range kubeconfig.Contexts {
+		if c.Name != contextName {
+			continue
+		}
+		found = true
+
+		if namespace == "" {
+			namespace = c.Context.Namespace
+		}
+
+		for _, cluster := range kubeconfig.Clusters {
+			if cluster.Name != c.Context.Cluster {
+				continue
+			}
+
+			client.server = strings.TrimSuffix(cluster.Cluster.Server, "/")
+			tlsConfig.ServerName = cluster.Cluster.TlsServerName
+			tlsConfig.InsecureSkipVerify = cluster.Cluster.InsecureSkipTlsVerify
+
+			ca, err := readFileOrData(cluster.Cluster.CertificateAuthority, cluster.Cluster.CertificateAuthorityData)
+			if err != nil {
+				return nil, "", err
+			}
+			if ca != nil {
+				tlsConfig.RootCAs = x509.NewCertPool()
+				if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+					return nil, "", errors.New("Could not parse certificate authority of cluster " + cluster.Name)
+				}
+			}
+		}
+
+		for _, user := range kubeconfig.Users {
+			if user.Name != c.Context.User {
+				continue
+			}
+
+			client.token = user.User.Token
+			client.tokenFile = user.User.TokenFile
+			client.username = user.User.Username
+			client.password = user.User.Password
+
+			if client.tokenFile != "" && !filepath.IsAbs(client.tokenFile) {
+				client.tokenFile = filepath.Join(dir, client.tokenFile)
+			}
+
+			cert, err := readFileOrData(user.User.ClientCertificate, user.User.ClientCertificateData)
+			if err != nil {
+				return nil, "", err
+			}
+			key, err := readFileOrData(user.User.ClientKey, user.User.ClientKeyData)
+			if err != nil {
+				return nil, "", err
+			}
+			if cert != nil || key != nil {
+				pair, err := tls.X509KeyPair(cert, key)
+				if err != nil {
+					return nil, "", err
+				}
+				tlsConfig.Certificates = []tls.Certificate{pair}
+			}
+		}
+	}
+
+	if !found {
+		return nil, "", errors.New("Context " + contextName + " not found in kubeconfig " + cfg.KubernetesKubeconfig)
+	}
+
+	if client.server == "" {
+		return nil, "", errors.New("No cluster server for context " + contextName + " in kubeconfig " + cfg.KubernetesKubeconfig)
+	}
+
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	client.http = kubernetesHttpClient(tlsConfig)
+
+	return client, namespace, nil
+}
+
+/**
+ * Http client for API. Has no timeout since watch requests are long,
+ * requests are limited with contexts instead
+ */
+func kubernetesHttpClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+/**
+ * Perform GET request to API, returning error if response is not 200
+ */
+func (this *kubernetesClient) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+
+	u := this.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	token := this.token
+	if this.tokenFile != "" {
+		// service account tokens are rotated, so file is read every time
+		data, err := os.ReadFile(this.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if this.username != "" {
+		req.SetBasicAuth(this.username, this.password)
+	}
+
+	resp, err := this.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		status := kubernetesStatus{Code: resp.StatusCode}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(body))
+		}
+		return nil, &kubernetesStatusError{resp.StatusCode, status.Message}
+	}
+
+	return resp, nil
+}
@@ -139,6 +139,8 @@ func lxdFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 			},
 			Priority: 1,
 			Weight:   1,
+			PriorityUnset: true,
+			WeightUnset:   true,
 			Stats: core.BackendStats{
 				Live: true,
 			},
@@ -0,0 +1,141 @@
+package discovery
+
+/**
+ * multi.go - composite discovery merging backends of several sources
+ */
+
+import (
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+)
+
+const (
+	multiRetryWaitDuration = 2 * time.Second
+)
+
+/**
+ * Create new Discovery with multi watch func
+ */
+func NewMultiDiscovery(cfg config.DiscoveryConfig) interface{} {
+
+	d := Discovery{
+		opts:  DiscoveryOpts{multiRetryWaitDuration},
+		watch: multiWatch,
+		cfg:   cfg,
+	}
+
+	return &d
+}
+
+/**
+ * Backends discovered by one of sources
+ */
+type multiUpdate struct {
+	source   int
+	backends []core.Backend
+}
+
+/**
+ * Run discovery for every source and publish merged backends
+ * every time any of sources discovers new ones.
+ * Failpolicy is applied by every source on its own, so failing
+ * source doesn't affect backends of others
+ */
+func multiWatch(cfg config.DiscoveryConfig, out chan<- []core.Backend, stop <-chan bool) error {
+
+	log := logging.For("multiWatch")
+
+	updates := make(chan multiUpdate)
+
+	for i, source := range cfg.MultiSources {
+
+		d := New(source.Kind, source.DiscoveryConfig)
+		d.Start()
+		defer d.Stop()
+
+		go func(i int, d *Discovery) {
+			for {
+				select {
+				case backends := <-d.Discover():
+					select {
+					case updates <- multiUpdate{i, backends}:
+					case <-stop:
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(i, d)
+	}
+
+	// latest backends of sources that already discovered something
+	discovered := make([][]core.Backend, len(cfg.MultiSources))
+
+	for {
+		select {
+		case update := <-updates:
+			source := cfg.MultiSources[update.source]
+			log.Debug("Source ", update.source, " ", source.Kind, " discovered ", len(update.backends), " backends")
+
+			discovered[update.source] = multiApplyDefaults(source, update.backends)
+
+			select {
+			case out <- multiMerge(discovered):
+			case <-stop:
+				return nil
+			}
+
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+/**
+ * Set source default priority and weight to backends
+ * that have none set by discovery
+ */
+func multiApplyDefaults(source config.MultiDiscoverySource, backends []core.Backend) []core.Backend {
+
+	result := make([]core.Backend, len(backends))
+
+	for i, backend := range backends {
+		if source.Priority != nil && backend.PriorityUnset {
+			backend.Priority = *source.Priority
+			backend.PriorityUnset = false
+		}
+		if source.Weight != nil && backend.WeightUnset {
+			backend.Weight = *source.Weight
+			backend.WeightUnset = false
+		}
+		result[i] = backend
+	}
+
+	return result
+}
+
+/**
+ * Merge backends of all sources.
+ * If same target is discovered by several sources, first source wins
+ */
+func multiMerge(discovered [][]core.Backend) []core.Backend {
+
+	backends := []core.Backend{}
+	seen := map[core.Target]bool{}
+
+	for _, source := range discovered {
+		for _, backend := range source {
+			if seen[backend.Target] {
+				continue
+			}
+			seen[backend.Target] = true
+			backends = append(backends, backend)
+		}
+	}
+
+	return backends
+}
@@ -25,21 +25,42 @@ const (
 	srvUdpSize            = 4096
 )
 
+/**
+ * Create new Discovery with srv fetch func.
+ * Records are fetched every interval if it's set, otherwise when their ttl expires
+ */
 func NewSrvDiscovery(cfg config.DiscoveryConfig) interface{} {
 
 	d := Discovery{
-		opts:  DiscoveryOpts{srvRetryWaitDuration},
-		fetch: srvFetch,
-		cfg:   cfg,
+		opts: DiscoveryOpts{srvRetryWaitDuration},
+		cfg:  cfg,
+	}
+
+	if interval, err := time.ParseDuration(cfg.Interval); err == nil && interval > 0 {
+		d.fetch = srvIntervalFetch
+	} else {
+		d.watch = ttlWatch(srvFetch)
 	}
 
 	return &d
 }
 
 /**
- * Create new Discovery with Srv fetch func
+ * Fetch backends from SRV records ignoring their ttl
  */
-func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+func srvIntervalFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
+	backends, _, err := srvFetch(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &backends, nil
+}
+
+/**
+ * Fetch backends from SRV records.
+ * Backends are valid for the least ttl of records, clamped to min and max ttl
+ */
+func srvFetch(cfg config.DiscoveryConfig) ([]core.Backend, time.Duration, error) {
 
 	log := logging.For("srvFetch")
 
@@ -49,12 +70,14 @@ func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 
 	r, err := srvDnsLookup(cfg, cfg.SrvLookupPattern, dns.TypeSRV)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	_, ttl := dnsAddresses(r)
+
 	if len(r.Answer) == 0 {
 		log.Warn("Empty response from", cfg.SrvLookupServer, cfg.SrvLookupPattern)
-		return &[]core.Backend{}, nil
+		return []core.Backend{}, dnsClampTtl(ttl, cfg.SrvMinTtl, cfg.SrvMaxTtl), nil
 	}
 
 	/* ----- try to get IPs from additional section ------ */
@@ -66,6 +89,11 @@ func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 			hosts[record.Header().Name] = record.A.String()
 		case *dns.AAAA:
 			hosts[record.Header().Name] = fmt.Sprintf("[%s]", record.AAAA.String())
+		default:
+			continue
+		}
+		if ans.Header().Ttl < ttl {
+			ttl = ans.Header().Ttl
 		}
 	}
 
@@ -75,7 +103,7 @@ func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 	for _, ans := range r.Answer {
 		record, ok := ans.(*dns.SRV)
 		if !ok {
-			return nil, errors.New("Non-SRV record in SRV answer")
+			return nil, 0, errors.New("Non-SRV record in SRV answer")
 		}
 
 		// If there were no A/AAAA record in additional SRV response,
@@ -83,18 +111,22 @@ func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		if _, ok := hosts[record.Target]; !ok {
 			log.Debug("Fetching ", cfg.SrvLookupServer, " A/AAAA ", record.Target)
 
-			ip, err := srvIPLookup(cfg, record.Target, dns.TypeA)
+			ip, ipTtl, err := srvIPLookup(cfg, record.Target, dns.TypeA)
 			if err != nil {
 				log.Warn("Error fetching A record for ", record.Target, ": ", err)
 			}
 
 			if ip == "" {
-				ip, err = srvIPLookup(cfg, record.Target, dns.TypeAAAA)
+				ip, ipTtl, err = srvIPLookup(cfg, record.Target, dns.TypeAAAA)
 				if err != nil {
 					log.Warn("Error fetching AAAA record for ", record.Target, ": ", err)
 				}
 			}
 
+			if err == nil && ipTtl < ttl {
+				ttl = ipTtl
+			}
+
 			if ip != "" {
 				hosts[record.Target] = ip
 			} else {
@@ -118,7 +150,7 @@ func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
 		})
 	}
 
-	return &backends, nil
+	return backends, dnsClampTtl(ttl, cfg.SrvMinTtl, cfg.SrvMaxTtl), nil
 }
 
 /**
@@ -126,35 +158,22 @@ func srvFetch(cfg config.DiscoveryConfig) (*[]core.Backend, error) {
  */
 func srvDnsLookup(cfg config.DiscoveryConfig, pattern string, typ uint16) (*dns.Msg, error) {
 	timeout := utils.ParseDurationOrDefault(cfg.Timeout, srvDefaultWaitTimeout)
-	c := dns.Client{Net: cfg.SrvDnsProtocol, Timeout: timeout}
-	m := dns.Msg{}
-
-	m.SetQuestion(pattern, typ)
-	m.SetEdns0(srvUdpSize, true)
-	r, _, err := c.Exchange(&m, cfg.SrvLookupServer)
-
-	return r, err
+	return dnsExchange([]string{cfg.SrvLookupServer}, cfg.SrvDnsProtocol, timeout, pattern, typ)
 }
 
 /**
- * Perform DNS lookup and extract IP address
+ * Perform DNS lookup and extract IP address with its ttl
  */
-func srvIPLookup(cfg config.DiscoveryConfig, pattern string, typ uint16) (string, error) {
+func srvIPLookup(cfg config.DiscoveryConfig, pattern string, typ uint16) (string, uint32, error) {
 	resp, err := srvDnsLookup(cfg, pattern, typ)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	if len(resp.Answer) == 0 {
-		return "", nil
+	hosts, ttl := dnsAddresses(resp)
+	if len(hosts) == 0 {
+		return "", ttl, nil
 	}
 
-	switch ans := resp.Answer[0].(type) {
-	case *dns.A:
-		return ans.A.String(), nil
-	case *dns.AAAA:
-		return fmt.Sprintf("[%s]", ans.AAAA.String()), nil
-	default:
-		return "", nil
-	}
+	return hosts[0], ttl, nil
 }
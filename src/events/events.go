@@ -0,0 +1,195 @@
+package events
+
+/**
+ * events.go - stream of servers and backends changes to subscribers
+ */
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yyyar/gobetween/core"
+)
+
+/**
+ * Event types
+ */
+const (
+	ServerCreated  = "server_created"
+	ServerDeleted  = "server_deleted"
+	BackendAdded   = "backend_added"
+	BackendRemoved = "backend_removed"
+	BackendLive    = "backend_live"
+	BackendDead    = "backend_dead"
+	DiscoveryError = "discovery_error"
+	MaxConnections = "max_connections"
+)
+
+/**
+ * Returns true if typ is one of event types
+ */
+func IsType(typ string) bool {
+	switch typ {
+	case ServerCreated, ServerDeleted, BackendAdded, BackendRemoved,
+		BackendLive, BackendDead, DiscoveryError, MaxConnections:
+		return true
+	}
+	return false
+}
+
+/**
+ * Events buffered for every subscriber.
+ * Events are dropped for subscribers that can't keep up
+ */
+const SUBSCRIBER_BUFFER_SIZE = 256
+
+/**
+ * Event of server or its backend. Server is name of the server,
+ * or server/pool for backends of sni pools
+ */
+type Event struct {
+	Id      uint64       `json:"id"`
+	Time    time.Time    `json:"time"`
+	Type    string       `json:"type"`
+	Server  string       `json:"server"`
+	Backend *core.Target `json:"backend,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+/**
+ * Filter of events, empty lists match everything
+ */
+type Filter struct {
+	Servers []string
+	Types   []string
+}
+
+/**
+ * Returns true if event passes the filter. Server matches events
+ * of the server itself and of its pools
+ */
+func (this Filter) Match(event Event) bool {
+
+	if len(this.Types) > 0 {
+		found := false
+		for _, t := range this.Types {
+			if t == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(this.Servers) > 0 {
+		found := false
+		for _, s := range this.Servers {
+			if s == event.Server || strings.HasPrefix(event.Server, s+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+/**
+ * Subscription to events
+ */
+type Subscription struct {
+	filter Filter
+	out    chan Event
+}
+
+/**
+ * Returns channel of subscribed events
+ */
+func (this *Subscription) Events() <-chan Event {
+	return this.out
+}
+
+var (
+	mu          sync.Mutex
+	lastId      uint64
+	subscribers = map[*Subscription]bool{}
+)
+
+/**
+ * Subscribe to events matching the filter
+ */
+func Subscribe(filter Filter) *Subscription {
+
+	s := &Subscription{filter: filter, out: make(chan Event, SUBSCRIBER_BUFFER_SIZE)}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	subscribers[s] = true
+
+	return s
+}
+
+/**
+ * Stop receiving events and close subscription channel
+ */
+func Unsubscribe(s *Subscription) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !subscribers[s] {
+		return
+	}
+
+	delete(subscribers, s)
+	close(s.out)
+}
+
+/**
+ * Publish event to all matching subscribers. Never blocks
+ */
+func Publish(event Event) {
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	lastId++
+	event.Id = lastId
+	event.Time = time.Now()
+
+	for s := range subscribers {
+		if !s.filter.Match(event) {
+			continue
+		}
+
+		select {
+		case s.out <- event:
+		default:
+		}
+	}
+}
+
+/**
+ * Publish event of server
+ */
+func PublishServer(typ string, server string, message string) {
+	Publish(Event{Type: typ, Server: server, Message: message})
+}
+
+/**
+ * Publish event of backend of server
+ */
+func PublishBackend(typ string, server string, target core.Target) {
+	Publish(Event{Type: typ, Server: server, Backend: &target})
+}
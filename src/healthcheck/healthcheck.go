@@ -67,6 +67,13 @@ type Healthcheck struct {
  */
 var registry = make(map[string]CheckFunc)
 
+/**
+ * Registry of checks preparing their config once, when healthcheck is created
+ */
+var builders = map[string]func(config.HealthcheckConfig) CheckFunc{
+	"http": newHttpCheck,
+}
+
 /**
  * Initialize type registry
  */
@@ -83,6 +90,9 @@ func init() {
 func New(strategy string, cfg config.HealthcheckConfig) *Healthcheck {
 
 	check := registry[strategy]
+	if build, ok := builders[strategy]; ok {
+		check = build(cfg)
+	}
 
 	/* Create healthcheck */
 
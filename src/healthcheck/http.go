@@ -0,0 +1,251 @@
+package healthcheck
+
+/**
+ * http.go - HTTP/HTTPS healthcheck
+ */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+)
+
+/**
+ * Max size of response body read to match http_expected_body
+ */
+const HTTP_MAX_BODY_SIZE = 64 * 1024
+
+/**
+ * Http healthcheck with parts of config prepared once
+ */
+type httpChecker struct {
+
+	/* Compiled http_expected_body, nil if it's not set */
+	expectedBody *regexp.Regexp
+
+	/* Certificates of http_tls_root_ca_cert_path, nil if it's not set */
+	rootCAs *x509.CertPool
+
+	/* Error reading root ca certificates, checks fail while it's set */
+	rootCAsErr error
+}
+
+/**
+ * Make http check compiling expected body regexp and reading
+ * root ca certificates once, when healthcheck is created
+ */
+func newHttpCheck(cfg config.HealthcheckConfig) CheckFunc {
+
+	log := logging.For("healthcheck/http")
+
+	checker := &httpChecker{}
+
+	// syntax is validated by manager
+	if cfg.HttpExpectedBody != "" {
+		checker.expectedBody = regexp.MustCompile(cfg.HttpExpectedBody)
+	}
+
+	if cfg.HttpScheme == "https" && cfg.HttpTlsRootCaCertPath != "" {
+		checker.rootCAs, checker.rootCAsErr = httpRootCAs(cfg.HttpTlsRootCaCertPath)
+		if checker.rootCAsErr != nil {
+			log.Error(checker.rootCAsErr)
+		}
+	}
+
+	return checker.check
+}
+
+/**
+ * Http healthcheck
+ */
+func (this *httpChecker) check(t core.Target, cfg config.HealthcheckConfig, result chan<- CheckResult) {
+
+	log := logging.For("healthcheck/http")
+
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+
+	checkResult := CheckResult{
+		Status: Unhealthy,
+		Target: t,
+	}
+
+	defer func() {
+		select {
+		case result <- checkResult:
+		default:
+			log.Warn("Channel is full. Discarding value")
+		}
+	}()
+
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: timeout,
+		}).DialContext,
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+
+	if cfg.HttpScheme == "https" {
+		if this.rootCAsErr != nil {
+			log.Debugf("Not checking %v: %v", t, this.rootCAsErr)
+			return
+		}
+		transport.TLSClientConfig = httpTlsConfig(t, cfg, this.rootCAs)
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+
+	if !cfg.HttpFollowRedirects {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+
+	// ipv6 hosts may come from discovery with or without brackets
+	url := cfg.HttpScheme + "://" + net.JoinHostPort(strings.Trim(t.Host, "[]"), t.Port) + cfg.HttpPath
+
+	req, err := http.NewRequest(cfg.HttpMethod, url, nil)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for name, value := range cfg.HttpHeaders {
+		req.Header.Set(name, value)
+	}
+
+	if cfg.HttpHost != "" {
+		req.Host = cfg.HttpHost
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Debugf("Request to %v failed: %v", t, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if !MatchesHttpStatus(resp.StatusCode, cfg.HttpExpectedStatus) {
+		log.Debugf("Unexpected status %d from %v", resp.StatusCode, t)
+		return
+	}
+
+	if this.expectedBody != nil {
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, HTTP_MAX_BODY_SIZE))
+		if err != nil {
+			log.Debugf("Could not read response body from %v: %v", t, err)
+			return
+		}
+
+		if !this.expectedBody.Match(body) {
+			log.Debugf("Response body from %v did not match %v", t, cfg.HttpExpectedBody)
+			return
+		}
+	}
+
+	checkResult.Status = Healthy
+}
+
+/**
+ * Make tls config for https check
+ */
+func httpTlsConfig(t core.Target, cfg config.HealthcheckConfig, rootCAs *x509.CertPool) *tls.Config {
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.HttpTlsSkipVerify,
+		ServerName:         cfg.HttpTlsServerName,
+		RootCAs:            rootCAs,
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = strings.Trim(t.Host, "[]")
+		if cfg.HttpHost != "" {
+			tlsConfig.ServerName = cfg.HttpHost
+			if host, _, err := net.SplitHostPort(cfg.HttpHost); err == nil {
+				tlsConfig.ServerName = host
+			}
+		}
+	}
+
+	return tlsConfig
+}
+
+/**
+ * Read root ca certificates from pem file
+ */
+func httpRootCAs(path string) (*x509.CertPool, error) {
+
+	caCertPem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	caCertPool := x509.NewCertPool()
+	if ok := caCertPool.AppendCertsFromPEM(caCertPem); !ok {
+		return nil, errors.New("No certificates found in " + path)
+	}
+
+	return caCertPool, nil
+}
+
+/**
+ * Parses expected status "<code>" or "<from>-<to>"
+ */
+func ParseHttpStatus(status string) (from int, to int, err error) {
+
+	parts := strings.SplitN(status, "-", 2)
+
+	from, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not parse http status %s", status)
+	}
+
+	to = from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Could not parse http status %s", status)
+		}
+	}
+
+	if from < 100 || to > 599 || from > to {
+		return 0, 0, fmt.Errorf("Invalid http status %s", status)
+	}
+
+	return from, to, nil
+}
+
+/**
+ * Checks if status code is one of expected statuses
+ */
+func MatchesHttpStatus(code int, expected []string) bool {
+
+	for _, status := range expected {
+		from, to, err := ParseHttpStatus(status)
+		if err != nil {
+			continue
+		}
+		if code >= from && code <= to {
+			return true
+		}
+	}
+
+	return false
+}
@@ -9,6 +9,7 @@ package manager
 import (
 	"errors"
 	"os"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -17,18 +18,23 @@ import (
 
 	"github.com/yyyar/gobetween/config"
 	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/events"
+	"github.com/yyyar/gobetween/healthcheck"
 	"github.com/yyyar/gobetween/logging"
 	"github.com/yyyar/gobetween/server"
 	"github.com/yyyar/gobetween/service"
 	"github.com/yyyar/gobetween/utils/codec"
 	"github.com/yyyar/gobetween/utils/profiler"
+	"github.com/yyyar/gobetween/utils/proxyprotocol"
+	"github.com/yyyar/gobetween/utils/tls/sni"
 )
 
-/* Map of app current servers */
+/* Map of app current servers, and configs of servers to be created again once drained */
 var servers = struct {
 	sync.RWMutex
-	m map[string]core.Server
-}{m: make(map[string]core.Server)}
+	m          map[string]core.Server
+	recreating map[string]config.Server
+}{m: make(map[string]core.Server), recreating: make(map[string]config.Server)}
 
 /* default configuration for server */
 var defaults config.ConnectionOptions
@@ -51,7 +57,7 @@ func Initialize(cfg config.Config) {
 
 	// save defaults for futher reuse
 	defaults = cfg.Defaults
-	initDefaults()
+	initDefaults(&defaults)
 
 	//Initialize global sections
 	initConfigGlobals(&cfg)
@@ -73,7 +79,7 @@ func Initialize(cfg config.Config) {
 	log.Info("Initialized")
 }
 
-func initDefaults() {
+func initDefaults(defaults *config.ConnectionOptions) {
 	//defaults
 	if defaults.MaxConnections == nil {
 		defaults.MaxConnections = new(int)
@@ -93,6 +99,20 @@ func initDefaults() {
 		defaults.BackendConnectionTimeout = new(string)
 		*defaults.BackendConnectionTimeout = "0"
 	}
+
+	if defaults.BackendConnectionRetries == nil {
+		defaults.BackendConnectionRetries = new(int)
+	}
+
+	if defaults.BackendConnectionDeadline == nil {
+		defaults.BackendConnectionDeadline = new(string)
+		*defaults.BackendConnectionDeadline = "0"
+	}
+
+	if defaults.DrainTimeout == nil {
+		defaults.DrainTimeout = new(string)
+		*defaults.DrainTimeout = "30s"
+	}
 }
 
 func initConfigGlobals(cfg *config.Config) {
@@ -125,6 +145,169 @@ func initProfiler(cfg *config.Config) {
 	profiler.Start(cfg.Profiler.Bind)
 }
 
+/**
+ * Reload applies new configuration to running servers.
+ * New servers are created and missing ones are deleted. Changed servers are
+ * reconfigured in place keeping active connections, unless bind, protocol, tls
+ * or udp section is changed; such servers are recreated.
+ * Nothing, including logging, is applied if any of the servers has invalid configuration
+ */
+func Reload(cfg config.Config) error {
+
+	log := logging.For("manager")
+	log.Info("Reloading...")
+
+	newDefaults := cfg.Defaults
+	initDefaults(&newDefaults)
+
+	initConfigGlobals(&cfg)
+
+	// Validate everything before touching running servers
+	prepared := map[string]config.Server{}
+	for name, serverCfg := range cfg.Servers {
+		c, err := prepareConfig(name, serverCfg, newDefaults)
+		if err != nil {
+			return err
+		}
+		prepared[name] = c
+	}
+
+	globals := map[string]bool{
+		"api":           !reflect.DeepEqual(cfg.Api, originalCfg.Api),
+		"metrics":       !reflect.DeepEqual(cfg.Metrics, originalCfg.Metrics),
+		"opentelemetry": !reflect.DeepEqual(cfg.OpenTelemetry, originalCfg.OpenTelemetry),
+		"acme":          !reflect.DeepEqual(cfg.Acme, originalCfg.Acme),
+		"profiler":      !reflect.DeepEqual(cfg.Profiler, originalCfg.Profiler),
+		"access_log":    !reflect.DeepEqual(cfg.AccessLog, originalCfg.AccessLog),
+	}
+	for section, changed := range globals {
+		if changed {
+			log.Warn("[", section, "] section changed, restart is required to apply it")
+		}
+	}
+
+	// logging is applied only once configuration is known to be valid
+	logging.Configure(cfg.Logging.Output, cfg.Logging.Level, cfg.Logging.Format)
+
+	defaults = newDefaults
+	originalCfg.Logging = cfg.Logging
+	originalCfg.Defaults = cfg.Defaults
+
+	servers.Lock()
+	defer servers.Unlock()
+
+	errs := []string{}
+
+	for name, server := range servers.m {
+		if _, ok := prepared[name]; ok {
+			continue
+		}
+		log.Info("Removing server ", name)
+		remove(name, server, true)
+	}
+
+	for name := range servers.recreating {
+		if _, ok := prepared[name]; !ok {
+			log.Info("Removing server ", name, " waiting to be recreated")
+			delete(servers.recreating, name)
+		}
+	}
+
+	for name, c := range prepared {
+
+		// still draining, so it will be created with the latest config
+		if _, ok := servers.recreating[name]; ok {
+			servers.recreating[name] = c
+			continue
+		}
+
+		server, ok := servers.m[name]
+		if !ok {
+			log.Info("Adding server ", name)
+			if err := create(name, c); err != nil {
+				errs = append(errs, name+": "+err.Error())
+			}
+			continue
+		}
+
+		old := server.Cfg()
+		if reflect.DeepEqual(old, c) {
+			// certificates files may be rotated without config change
+			if err := server.ReloadCertificates(); err != nil {
+				errs = append(errs, name+": "+err.Error())
+			}
+			continue
+		}
+
+		if requiresRecreate(old, c) {
+			log.Info("Recreating server ", name)
+			done := remove(name, server, true)
+			// udp server keeps its socket open while draining,
+			// so it's created again in background once drained
+			if old.Protocol == "udp" && old.Bind == c.Bind {
+				servers.recreating[name] = c
+				go recreateDrained(name, done)
+				continue
+			}
+			if err := create(name, c); err != nil {
+				errs = append(errs, name+": "+err.Error())
+			}
+			continue
+		}
+
+		log.Info("Reconfiguring server ", name)
+		if err := server.Reconfigure(c); err != nil {
+			errs = append(errs, name+": "+err.Error())
+			continue
+		}
+
+		if err := server.ReloadCertificates(); err != nil {
+			errs = append(errs, name+": "+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("Errors applying configuration: " + strings.Join(errs, "; "))
+	}
+
+	log.Info("Reloaded")
+
+	return nil
+}
+
+/**
+ * Create server again from its pending config once old one is stopped,
+ * unless it was removed by another reload meanwhile
+ */
+func recreateDrained(name string, done <-chan bool) {
+
+	<-done
+
+	servers.Lock()
+	defer servers.Unlock()
+
+	c, ok := servers.recreating[name]
+	if !ok {
+		return
+	}
+	delete(servers.recreating, name)
+
+	if err := create(name, c); err != nil {
+		logging.For("manager").Error("Could not recreate server ", name, ": ", err)
+	}
+}
+
+/**
+ * Checks if server can't be reconfigured in place
+ * and should be stopped and created again
+ */
+func requiresRecreate(old config.Server, new config.Server) bool {
+	return old.Bind != new.Bind ||
+		old.Protocol != new.Protocol ||
+		!reflect.DeepEqual(old.Tls, new.Tls) ||
+		!reflect.DeepEqual(old.Udp, new.Udp)
+}
+
 /**
  * Dumps current [servers] section to
  * the config file
@@ -190,11 +373,24 @@ func Create(name string, cfg config.Server) error {
 		return errors.New("Server with this name already exists: " + name)
 	}
 
+	if _, ok := servers.recreating[name]; ok {
+		return errors.New("Server with this name is draining to be recreated: " + name)
+	}
+
 	c, err := prepareConfig(name, cfg, defaults)
 	if err != nil {
 		return err
 	}
 
+	return create(name, c)
+}
+
+/**
+ * Create and launch server from prepared config.
+ * Should be called with servers locked
+ */
+func create(name string, c config.Server) error {
+
 	server, err := server.New(name, c)
 
 	if err != nil {
@@ -214,13 +410,16 @@ func Create(name string, cfg config.Server) error {
 
 	servers.m[name] = server
 
+	events.PublishServer(events.ServerCreated, name, "")
+
 	return nil
 }
 
 /**
- * Delete server stopping all active connections
+ * Delete server stopping all active connections,
+ * or waiting for them to finish in background if graceful
  */
-func Delete(name string) error {
+func Delete(name string, graceful bool) error {
 
 	servers.Lock()
 	defer servers.Unlock()
@@ -230,14 +429,61 @@ func Delete(name string) error {
 		return errors.New("Server not found")
 	}
 
-	server.Stop()
+	remove(name, server, graceful)
+
+	return nil
+}
+
+/**
+ * Gracefully stop all servers and wait until they are stopped
+ */
+func StopAll() {
+
+	log := logging.For("manager")
+	log.Info("Stopping all servers...")
+
+	servers.Lock()
+	servers.recreating = make(map[string]config.Server)
+	stopping := []<-chan bool{}
+	for name, server := range servers.m {
+		stopping = append(stopping, remove(name, server, true))
+	}
+	servers.Unlock()
+
+	for _, done := range stopping {
+		<-done
+	}
+
+	log.Info("All servers stopped")
+}
+
+/**
+ * Stop server and forget it. Returned channel is closed
+ * when server is completely stopped.
+ * Should be called with servers locked
+ */
+func remove(name string, server core.Server, graceful bool) <-chan bool {
+
+	var done <-chan bool
+
+	if graceful {
+		done = server.StopGraceful()
+	} else {
+		server.Stop()
+		stopped := make(chan bool)
+		close(stopped)
+		done = stopped
+	}
+
 	delete(servers.m, name)
 
 	for _, s := range services {
 		s.Disable(server)
 	}
 
-	return nil
+	events.PublishServer(events.ServerDeleted, name, "")
+
+	return done
 }
 
 /**
@@ -253,134 +499,146 @@ func Stats(name string) interface{} {
 }
 
 /**
- * Prepare config (merge default configuration, and try to validate)
- * TODO: make validation better
+ * Returns current backends of the server
  */
-func prepareConfig(name string, server config.Server, defaults config.ConnectionOptions) (config.Server, error) {
+func Backends(name string, pool string) ([]core.Backend, error) {
 
-	/* ----- Prerequisites ----- */
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
 
-	if server.Bind == "" {
-		return config.Server{}, errors.New("No bind specified for server " + name)
+	if !ok {
+		return nil, errors.New("Server not found")
 	}
 
-	if server.Discovery == nil {
-		return config.Server{}, errors.New("No .discovery specified for server " + name)
+	return server.Backends(pool)
+}
+
+/**
+ * Returns certificates currently used by the server
+ */
+func Certificates(name string) ([]core.CertificateInfo, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
 	}
 
-	if server.Healthcheck == nil {
-		server.Healthcheck = &config.HealthcheckConfig{
-			Kind:     "none",
-			Interval: "0",
-			Timeout:  "0",
-		}
+	return server.Certificates(), nil
+}
+
+/**
+ * Override backend properties of the server at runtime
+ */
+func OverrideBackend(name string, pool string, target core.Target, override core.BackendOverride, merge bool) (*core.Backend, error) {
+
+	servers.RLock()
+	server, ok := servers.m[name]
+	servers.RUnlock()
+
+	if !ok {
+		return nil, errors.New("Server not found")
 	}
 
-	switch server.Healthcheck.Kind {
+	switch override.State {
 	case
-		"ping",
-		"probe",
-		"exec",
-		"none":
+		"",
+		core.BackendEnabled,
+		core.BackendDrain,
+		core.BackendDisabled:
 	default:
-		return config.Server{}, errors.New("Not supported healthcheck type " + server.Healthcheck.Kind)
+		return nil, errors.New("Not supported backend state " + override.State)
 	}
 
-	if server.Healthcheck.Interval == "" {
-		server.Healthcheck.Interval = "0"
+	if override.Weight != nil && *override.Weight < 0 {
+		return nil, errors.New("Backend weight should not be negative")
 	}
 
-	if server.Healthcheck.Timeout == "" {
-		server.Healthcheck.Timeout = "0"
+	if override.Priority != nil && *override.Priority < 0 {
+		return nil, errors.New("Backend priority should not be negative")
 	}
 
-	if server.Healthcheck.Fails <= 0 {
-		server.Healthcheck.Fails = 1
+	if override.MaxConnections != nil && *override.MaxConnections < 0 {
+		return nil, errors.New("Backend max_connections should not be negative")
 	}
 
-	if server.Healthcheck.Passes <= 0 {
-		server.Healthcheck.Passes = 1
-	}
+	return server.OverrideBackend(pool, target, override, merge)
+}
 
-	if server.Healthcheck.Kind != "none" {
-		d, err := time.ParseDuration(server.Healthcheck.Interval)
-		if err != nil {
-			return config.Server{}, errors.New("Could not parse healtcheck interval: " + err.Error())
-		}
+/**
+ * Prepare config (merge default configuration, and try to validate)
+ * TODO: make validation better
+ */
+func prepareConfig(name string, server config.Server, defaults config.ConnectionOptions) (config.Server, error) {
 
-		if d <= 0 {
-			return config.Server{}, errors.New("Healthcheck interval should be greater than 0s")
-		}
+	/* ----- Prerequisites ----- */
+
+	if server.Bind == "" {
+		return config.Server{}, errors.New("No bind specified for server " + name)
 	}
 
-	if server.Healthcheck.InitialStatus != nil {
-		switch *server.Healthcheck.InitialStatus {
-		case "healthy", "unhealthy":
-		default:
-			return config.Server{}, errors.New("Unsupported healthcheck initial_status")
-		}
+	if server.Discovery == nil {
+		return config.Server{}, errors.New("No .discovery specified for server " + name)
 	}
 
-	if server.Healthcheck.Kind == "probe" {
+	var err error
+	server.Healthcheck, err = prepareHealthcheckConfig(server.Protocol, server.Healthcheck)
+	if err != nil {
+		return config.Server{}, err
+	}
 
-		switch server.Healthcheck.ProbeProtocol {
-		case "tcp", "udp", "tls":
-		default:
-			return config.Server{}, errors.New("Unsupported probe_protocol")
+	if server.OutlierDetection != nil {
+		if err := prepareOutlierDetection(server.Protocol, server.OutlierDetection); err != nil {
+			return config.Server{}, err
 		}
+	}
 
-		if server.Healthcheck.ProbeSend == "" || server.Healthcheck.ProbeRecv == "" {
-			return config.Server{}, errors.New("probe healthcheck should have both probe_send and probe_recv specified")
-		}
+	if server.ProxyProtocol != nil {
 
-		if server.Healthcheck.ProbeStrategy == "" {
-			server.Healthcheck.ProbeStrategy = "starts_with"
+		switch server.Protocol {
+		case "tcp", "tls", "udp":
+		default:
+			return config.Server{}, errors.New("proxy_protocol may be used only with 'tcp', 'tls' or 'udp' protocol, not with " + server.Protocol)
 		}
 
-		var err error
-		server.Healthcheck.ProbeSend, err = strconv.Unquote("\"" + server.Healthcheck.ProbeSend + "\"")
-		if err != nil {
-			return config.Server{}, errors.New("probe_send has invalid syntax " + err.Error())
+		if server.ProxyProtocol.Version == "" {
+			return config.Server{}, errors.New("version field for proxy_protocol is not specified")
 		}
 
-		switch server.Healthcheck.ProbeStrategy {
-		case "starts_with":
-			if server.Healthcheck.ProbeRecvLen > 0 {
-				return config.Server{}, errors.New("probe_recv_len is redundant for 'starts_with' strategy")
-			}
-
-			var err error
-			server.Healthcheck.ProbeRecv, err = strconv.Unquote("\"" + server.Healthcheck.ProbeRecv + "\"")
-			if err != nil {
-				return config.Server{}, errors.New("probe_recv has invalid syntax " + err.Error())
-			}
-		case "regexp":
-			if server.Healthcheck.ProbeRecvLen == 0 {
-				return config.Server{}, errors.New("probe_recv_len required")
-			}
-
-			_, err := regexp.Compile(server.Healthcheck.ProbeRecv)
-			if err != nil {
-				return config.Server{}, errors.New("probe_recv has invalid syntax " + err.Error())
+		switch server.ProxyProtocol.Version {
+		case "1":
+			if server.Protocol == "udp" {
+				return config.Server{}, errors.New("proxy_protocol version 1 does not support udp, use version 2")
 			}
+		case "2":
 		default:
-			return config.Server{}, errors.New("Unsupported probe_strategy " + server.Healthcheck.ProbeStrategy)
+			return config.Server{}, errors.New("Unsupported proxy_protocol version " + server.ProxyProtocol.Version)
 		}
-
 	}
 
-	if server.ProxyProtocol != nil {
+	if server.AcceptProxyProtocol != nil {
 
-		if server.Protocol != "tcp" {
-			return config.Server{}, errors.New("proxy_protocol may be used only with 'tcp' protocol, not with " + server.Protocol)
+		if server.Protocol != "tcp" && server.Protocol != "tls" {
+			return config.Server{}, errors.New("accept_proxy_protocol may be used only with 'tcp' or 'tls' protocol, not with " + server.Protocol)
 		}
 
-		if server.ProxyProtocol.Version == "" {
-			return config.Server{}, errors.New("version field for proxy_protocol is not specified")
+		if len(server.AcceptProxyProtocol.TrustedNetworks) == 0 {
+			return config.Server{}, errors.New("accept_proxy_protocol requires at least one of trusted_networks")
 		}
 
-		if server.ProxyProtocol.Version != "1" {
-			return config.Server{}, errors.New("Unsupported proxy_protocol version " + server.ProxyProtocol.Version)
+		if _, err := proxyprotocol.ParseNetworks(server.AcceptProxyProtocol.TrustedNetworks); err != nil {
+			return config.Server{}, errors.New("accept_proxy_protocol " + err.Error())
+		}
+
+		if server.AcceptProxyProtocol.ReadTimeout == "" {
+			server.AcceptProxyProtocol.ReadTimeout = "2s"
+		}
+
+		if _, err := time.ParseDuration(server.AcceptProxyProtocol.ReadTimeout); err != nil {
+			return config.Server{}, errors.New("accept_proxy_protocol read_timeout parsing error: " + err.Error())
 		}
 	}
 
@@ -420,22 +678,52 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		}
 	}
 
-	if _, err := time.ParseDuration(server.Healthcheck.Timeout); err != nil {
-		return config.Server{}, errors.New("timeout parsing error")
-	}
-
-	if _, err := time.ParseDuration(server.Healthcheck.Interval); err != nil {
-		return config.Server{}, errors.New("interval parsing error")
-	}
-
 	if server.BackendsTls != nil && ((server.BackendsTls.KeyPath == nil) != (server.BackendsTls.CertPath == nil)) {
 		return config.Server{}, errors.New("backend_tls.cert_path and .key_path should be specified together")
 	}
 
 	if server.Tls != nil {
 
-		if (len(server.Tls.AcmeHosts) == 0) && ((server.Tls.KeyPath == "") || (server.Tls.CertPath == "")) {
-			return config.Server{}, errors.New("tls requires specify either acme hosts or both key and cert paths")
+		if (server.Tls.KeyPath == "") != (server.Tls.CertPath == "") {
+			return config.Server{}, errors.New("tls cert_path and key_path should be specified together")
+		}
+
+		for _, c := range server.Tls.Certificates {
+			if c.CertPath == "" || c.KeyPath == "" {
+				return config.Server{}, errors.New("tls certificates require both cert_path and key_path")
+			}
+		}
+
+		if len(server.Tls.AcmeHosts) == 0 && server.Tls.CertPath == "" && len(server.Tls.Certificates) == 0 && server.Tls.CertDir == "" {
+			return config.Server{}, errors.New("tls requires specify either acme hosts, cert and key paths, certificates or cert_dir")
+		}
+
+		if server.Tls.ClientVerify == "" {
+			server.Tls.ClientVerify = "none"
+			if server.Tls.ClientCaPath != "" {
+				server.Tls.ClientVerify = "require"
+			}
+		}
+
+		switch server.Tls.ClientVerify {
+		case "none":
+			if server.Tls.ClientCaPath != "" || server.Tls.ClientCrlPath != "" || len(server.Tls.ClientAllowed) > 0 {
+				return config.Server{}, errors.New("tls client_ca_path, client_crl_path and client_allowed require client_verify")
+			}
+		case
+			"request",
+			"require":
+			if server.Tls.ClientCaPath == "" {
+				return config.Server{}, errors.New("tls client_verify requires client_ca_path")
+			}
+		default:
+			return config.Server{}, errors.New("Not supported tls client_verify " + server.Tls.ClientVerify)
+		}
+
+		if len(server.Tls.ClientAllowed) > 0 {
+			if _, err := sni.NewMatcher(server.Tls.ClientAllowed); err != nil {
+				return config.Server{}, errors.New("tls client_allowed: " + err.Error())
+			}
 		}
 
 	}
@@ -469,94 +757,75 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		return config.Server{}, errors.New("Not supported protocol " + server.Protocol)
 	}
 
-	/* Healthcheck and protocol match */
-
-	if server.Healthcheck.Kind == "ping" && server.Protocol == "udp" {
-		return config.Server{}, errors.New("Cant use ping healthcheck with udp server")
-	}
-
 	/* Balance */
-	switch server.Balance {
-	case
-		"weight",
-		"leastconn",
-		"roundrobin",
-		"leastbandwidth",
-		"iphash1",
-		"iphash":
-	case "":
-		server.Balance = "weight"
-	default:
-		return config.Server{}, errors.New("Not supported balance type " + server.Balance)
+	server.Balance, server.Consistent, err = prepareBalance(server.Balance, server.Consistent, server.Sni != nil || len(server.Routes) > 0)
+	if err != nil {
+		return config.Server{}, err
 	}
 
 	/* Discovery */
-	switch server.Discovery.Failpolicy {
-	case
-		"keeplast",
-		"setempty":
-	case "":
-		server.Discovery.Failpolicy = "keeplast"
-	default:
-		return config.Server{}, errors.New("Not supported failpolicy " + server.Discovery.Failpolicy)
+	discoveryConfig, err := prepareDiscoveryConfig(*server.Discovery)
+	if err != nil {
+		return config.Server{}, err
 	}
+	server.Discovery = &discoveryConfig
 
-	if server.Discovery.Interval == "" {
-		server.Discovery.Interval = "0"
+	/* Routes and pools */
+	if (len(server.Routes) > 0 || len(server.Pools) > 0) && server.Protocol == "udp" {
+		return config.Server{}, errors.New("routes and pools are unavailable for udp protocol")
 	}
 
-	if server.Discovery.Timeout == "" {
-		server.Discovery.Timeout = "0"
-	}
+	if server.Pools != nil {
+		pools := make(map[string]config.Pool, len(server.Pools))
 
-	/* SRV Discovery */
-	if server.Discovery.Kind == "srv" {
-		switch server.Discovery.SrvDnsProtocol {
-		case
-			"udp",
-			"tcp":
-		case "":
-			server.Discovery.SrvDnsProtocol = "udp"
-		default:
-			return config.Server{}, errors.New("Not supported srv_dns_protocol " + server.Discovery.SrvDnsProtocol)
-		}
-	}
+		for poolName, pool := range server.Pools {
 
-	/* LXD Discovery */
-	if server.Discovery.Kind == "lxd" {
+			// empty pool name refers to server's own backends
+			if poolName == "" {
+				return config.Server{}, errors.New("Pool name should not be empty")
+			}
 
-		if server.Discovery.LXDServerAddress == "" {
-			return config.Server{}, errors.New("lxd_server_address is required" + server.Discovery.LXDServerAddress)
-		}
+			if pool.Discovery == nil {
+				return config.Server{}, errors.New("No .discovery specified for pool " + poolName)
+			}
 
-		if !(strings.HasPrefix(server.Discovery.LXDServerAddress, "https:") ||
-			strings.HasPrefix(server.Discovery.LXDServerAddress, "unix:")) {
+			pool.Healthcheck, err = prepareHealthcheckConfig(server.Protocol, pool.Healthcheck)
+			if err != nil {
+				return config.Server{}, errors.New("pool " + poolName + ": " + err.Error())
+			}
 
-			return config.Server{}, errors.New("lxd_server_address should start with either unix:// or https:// but got " + server.Discovery.LXDServerAddress)
-		}
+			if pool.OutlierDetection != nil {
+				if err := prepareOutlierDetection(server.Protocol, pool.OutlierDetection); err != nil {
+					return config.Server{}, errors.New("pool " + poolName + ": " + err.Error())
+				}
+			}
 
-		if server.Discovery.LXDServerRemoteName == "" {
-			server.Discovery.LXDServerRemoteName = "local"
-		}
+			pool.Balance, pool.Consistent, err = prepareBalance(pool.Balance, pool.Consistent, true)
+			if err != nil {
+				return config.Server{}, errors.New("pool " + poolName + ": " + err.Error())
+			}
 
-		if server.Discovery.LXDConfigDirectory == "" {
-			server.Discovery.LXDConfigDirectory = os.ExpandEnv("$HOME/.config/lxc")
-		}
+			poolDiscovery, err := prepareDiscoveryConfig(*pool.Discovery)
+			if err != nil {
+				return config.Server{}, errors.New("pool " + poolName + ": " + err.Error())
+			}
+			pool.Discovery = &poolDiscovery
 
-		if server.Discovery.LXDContainerInterface == "" {
-			server.Discovery.LXDContainerInterface = "eth0"
+			pools[poolName] = pool
 		}
 
-		switch server.Discovery.LXDContainerAddressType {
-		case
-			"IPv4",
-			"IPv6":
-		case "":
-			server.Discovery.LXDContainerAddressType = "IPv4"
-		default:
-			return config.Server{}, errors.New("Invalid lxd_container_address_type. Must be IPv4 or IPv6")
+		server.Pools = pools
+	}
+
+	for _, route := range server.Routes {
+
+		if _, ok := server.Pools[route.Pool]; !ok {
+			return config.Server{}, errors.New("route refers to unknown pool '" + route.Pool + "'")
 		}
 
+		if _, err := sni.NewMatcher(route.Sni); err != nil {
+			return config.Server{}, errors.New("route to pool " + route.Pool + ": " + err.Error())
+		}
 	}
 
 	/* TODO: Still need to decide how to get rid of this */
@@ -581,5 +850,583 @@ func prepareConfig(name string, server config.Server, defaults config.Connection
 		*server.BackendConnectionTimeout = *defaults.BackendConnectionTimeout
 	}
 
+	if server.BackendConnectionRetries == nil {
+		server.BackendConnectionRetries = new(int)
+		*server.BackendConnectionRetries = *defaults.BackendConnectionRetries
+	}
+
+	if *server.BackendConnectionRetries < 0 {
+		return config.Server{}, errors.New("backend_connection_retries should be >= 0")
+	}
+
+	if server.BackendConnectionDeadline == nil {
+		server.BackendConnectionDeadline = new(string)
+		*server.BackendConnectionDeadline = *defaults.BackendConnectionDeadline
+	}
+
+	if _, err := time.ParseDuration(*server.BackendConnectionDeadline); err != nil {
+		return config.Server{}, errors.New("backend_connection_deadline parsing error")
+	}
+
+	if server.DrainTimeout == nil {
+		server.DrainTimeout = new(string)
+		*server.DrainTimeout = *defaults.DrainTimeout
+	}
+
+	if _, err := time.ParseDuration(*server.DrainTimeout); err != nil {
+		return config.Server{}, errors.New("drain_timeout parsing error")
+	}
+
 	return server, nil
 }
+
+/**
+ * Validate healthcheck config and fill its defaults
+ */
+func prepareHealthcheckConfig(protocol string, hc *config.HealthcheckConfig) (*config.HealthcheckConfig, error) {
+
+	if hc == nil {
+		hc = &config.HealthcheckConfig{
+			Kind:     "none",
+			Interval: "0",
+			Timeout:  "0",
+		}
+	}
+
+	switch hc.Kind {
+	case
+		"ping",
+		"probe",
+		"exec",
+		"http",
+		"none":
+	default:
+		return nil, errors.New("Not supported healthcheck type " + hc.Kind)
+	}
+
+	if hc.Interval == "" {
+		hc.Interval = "0"
+	}
+
+	if hc.Timeout == "" {
+		hc.Timeout = "0"
+	}
+
+	if hc.Fails <= 0 {
+		hc.Fails = 1
+	}
+
+	if hc.Passes <= 0 {
+		hc.Passes = 1
+	}
+
+	if hc.Kind != "none" {
+		d, err := time.ParseDuration(hc.Interval)
+		if err != nil {
+			return nil, errors.New("Could not parse healtcheck interval: " + err.Error())
+		}
+
+		if d <= 0 {
+			return nil, errors.New("Healthcheck interval should be greater than 0s")
+		}
+	}
+
+	if hc.InitialStatus != nil {
+		switch *hc.InitialStatus {
+		case "healthy", "unhealthy":
+		default:
+			return nil, errors.New("Unsupported healthcheck initial_status")
+		}
+	}
+
+	if hc.Kind == "probe" {
+
+		switch hc.ProbeProtocol {
+		case "tcp", "udp", "tls":
+		default:
+			return nil, errors.New("Unsupported probe_protocol")
+		}
+
+		if hc.ProbeSend == "" || hc.ProbeRecv == "" {
+			return nil, errors.New("probe healthcheck should have both probe_send and probe_recv specified")
+		}
+
+		if hc.ProbeStrategy == "" {
+			hc.ProbeStrategy = "starts_with"
+		}
+
+		var err error
+		hc.ProbeSend, err = strconv.Unquote("\"" + hc.ProbeSend + "\"")
+		if err != nil {
+			return nil, errors.New("probe_send has invalid syntax " + err.Error())
+		}
+
+		switch hc.ProbeStrategy {
+		case "starts_with":
+			if hc.ProbeRecvLen > 0 {
+				return nil, errors.New("probe_recv_len is redundant for 'starts_with' strategy")
+			}
+
+			var err error
+			hc.ProbeRecv, err = strconv.Unquote("\"" + hc.ProbeRecv + "\"")
+			if err != nil {
+				return nil, errors.New("probe_recv has invalid syntax " + err.Error())
+			}
+		case "regexp":
+			if hc.ProbeRecvLen == 0 {
+				return nil, errors.New("probe_recv_len required")
+			}
+
+			_, err := regexp.Compile(hc.ProbeRecv)
+			if err != nil {
+				return nil, errors.New("probe_recv has invalid syntax " + err.Error())
+			}
+		default:
+			return nil, errors.New("Unsupported probe_strategy " + hc.ProbeStrategy)
+		}
+
+	}
+
+	if hc.Kind == "http" {
+
+		if protocol == "udp" {
+			return nil, errors.New("http healthcheck is unavailable for udp protocol")
+		}
+
+		if hc.HttpHealthcheckConfig == nil {
+			hc.HttpHealthcheckConfig = &config.HttpHealthcheckConfig{}
+		}
+
+		if hc.HttpScheme == "" {
+			hc.HttpScheme = "http"
+		}
+
+		switch hc.HttpScheme {
+		case "http", "https":
+		default:
+			return nil, errors.New("Unsupported http_scheme " + hc.HttpScheme)
+		}
+
+		if hc.HttpMethod == "" {
+			hc.HttpMethod = "GET"
+		}
+
+		if hc.HttpPath == "" {
+			hc.HttpPath = "/"
+		}
+
+		if !strings.HasPrefix(hc.HttpPath, "/") {
+			return nil, errors.New("http_path should start with /")
+		}
+
+		if len(hc.HttpExpectedStatus) == 0 {
+			hc.HttpExpectedStatus = []string{"200-399"}
+		}
+
+		for _, status := range hc.HttpExpectedStatus {
+			if _, _, err := healthcheck.ParseHttpStatus(status); err != nil {
+				return nil, err
+			}
+		}
+
+		if _, err := regexp.Compile(hc.HttpExpectedBody); err != nil {
+			return nil, errors.New("http_expected_body has invalid syntax " + err.Error())
+		}
+
+		if hc.HttpScheme != "https" && (hc.HttpTlsSkipVerify ||
+			hc.HttpTlsServerName != "" || hc.HttpTlsRootCaCertPath != "") {
+			return nil, errors.New("http_tls_* options require http_scheme = \"https\"")
+		}
+	}
+
+	if _, err := time.ParseDuration(hc.Timeout); err != nil {
+		return nil, errors.New("timeout parsing error")
+	}
+
+	if _, err := time.ParseDuration(hc.Interval); err != nil {
+		return nil, errors.New("interval parsing error")
+	}
+
+	if hc.Kind == "ping" && protocol == "udp" {
+		return nil, errors.New("Cant use ping healthcheck with udp server")
+	}
+
+	return hc, nil
+}
+
+/**
+ * Validate outlier detection config and fill its defaults
+ */
+func prepareOutlierDetection(protocol string, od *config.OutlierDetection) error {
+
+	if protocol == "udp" {
+		return errors.New("outlier_detection is unavailable for udp protocol")
+	}
+
+	if od.ConsecutiveFailures < 0 {
+		return errors.New("outlier_detection consecutive_failures should be >= 0")
+	}
+
+	if od.FailureRatio < 0 || od.FailureRatio > 1 {
+		return errors.New("outlier_detection failure_ratio should be between 0 and 1")
+	}
+
+	if od.ConsecutiveFailures == 0 && od.FailureRatio == 0 {
+		od.ConsecutiveFailures = 5
+	}
+
+	if od.Window == "" {
+		od.Window = "10s"
+	}
+
+	if od.MinRequests <= 0 {
+		od.MinRequests = 10
+	}
+
+	if od.EjectionTime == "" {
+		od.EjectionTime = "30s"
+	}
+
+	if od.MaxEjectionTime == "" {
+		od.MaxEjectionTime = "5m"
+	}
+
+	if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+		return errors.New("outlier_detection max_ejection_percent should be between 0 and 100")
+	}
+
+	if od.MaxEjectionPercent == 0 {
+		od.MaxEjectionPercent = 50
+	}
+
+	for _, d := range []string{od.Window, od.EjectionTime, od.MaxEjectionTime} {
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return errors.New("outlier_detection durations should be greater than 0s, got " + d)
+		}
+	}
+
+	ejectionTime, _ := time.ParseDuration(od.EjectionTime)
+	maxEjectionTime, _ := time.ParseDuration(od.MaxEjectionTime)
+	if maxEjectionTime < ejectionTime {
+		return errors.New("outlier_detection max_ejection_time should be >= ejection_time")
+	}
+
+	return nil
+}
+
+/**
+ * Validate balance and consistent hash configs, filling defaults.
+ * Sni tells if hostname is known for connections
+ */
+func prepareBalance(balance string, consistent *config.Consistent, sni bool) (string, *config.Consistent, error) {
+
+	switch balance {
+	case
+		"weight",
+		"leastconn",
+		"roundrobin",
+		"leastbandwidth",
+		"iphash1",
+		"iphash",
+		"consistent":
+	case "":
+		balance = "weight"
+	default:
+		return "", nil, errors.New("Not supported balance type " + balance)
+	}
+
+	/* Consistent balance */
+	if balance == "consistent" {
+
+		if consistent == nil {
+			consistent = &config.Consistent{}
+		}
+
+		switch consistent.HashKey {
+		case
+			"ip",
+			"ip_port",
+			"client":
+		case "sni":
+			if !sni {
+				return "", nil, errors.New("consistent hash_key 'sni' requires sni section")
+			}
+		case "":
+			consistent.HashKey = "ip"
+		default:
+			return "", nil, errors.New("Not supported consistent hash_key " + consistent.HashKey)
+		}
+
+		if consistent.Replicas < 0 {
+			return "", nil, errors.New("consistent replicas should not be negative")
+		}
+
+		if consistent.Replicas == 0 {
+			consistent.Replicas = 160
+		}
+
+		if consistent.LoadFactor != 0 && consistent.LoadFactor <= 1 {
+			return "", nil, errors.New("consistent load_factor should be greater than 1, or 0 to disable bounded load")
+		}
+	}
+
+	return balance, consistent, nil
+}
+
+/**
+ * Check min and max ttl are valid durations, min is positive and not greater than max
+ */
+func checkTtlRange(min string, max string) error {
+
+	minTtl, err := time.ParseDuration(min)
+	if err != nil {
+		return err
+	}
+
+	maxTtl, err := time.ParseDuration(max)
+	if err != nil {
+		return err
+	}
+
+	if minTtl <= 0 {
+		return errors.New("min ttl should be greater than 0")
+	}
+
+	if maxTtl < minTtl {
+		return errors.New("max ttl should not be less than min ttl")
+	}
+
+	return nil
+}
+
+/**
+ * Prepare discovery config, validating it and setting defaults
+ */
+func prepareDiscoveryConfig(cfg config.DiscoveryConfig) (config.DiscoveryConfig, error) {
+
+	switch cfg.Failpolicy {
+	case
+		"keeplast",
+		"setempty":
+	case "":
+		cfg.Failpolicy = "keeplast"
+	default:
+		return config.DiscoveryConfig{}, errors.New("Not supported failpolicy " + cfg.Failpolicy)
+	}
+
+	if cfg.Interval == "" {
+		cfg.Interval = "0"
+	}
+
+	if cfg.Timeout == "" {
+		cfg.Timeout = "0"
+	}
+
+	/* SRV Discovery */
+	if cfg.Kind == "srv" {
+		switch cfg.SrvDnsProtocol {
+		case
+			"udp",
+			"tcp":
+		case "":
+			cfg.SrvDnsProtocol = "udp"
+		default:
+			return config.DiscoveryConfig{}, errors.New("Not supported srv_dns_protocol " + cfg.SrvDnsProtocol)
+		}
+
+		if cfg.SrvMinTtl == "" {
+			cfg.SrvMinTtl = "5s"
+		}
+
+		if cfg.SrvMaxTtl == "" {
+			cfg.SrvMaxTtl = "5m"
+		}
+
+		if err := checkTtlRange(cfg.SrvMinTtl, cfg.SrvMaxTtl); err != nil {
+			return config.DiscoveryConfig{}, errors.New("srv_min_ttl and srv_max_ttl: " + err.Error())
+		}
+	}
+
+	/* DNS Discovery */
+	if cfg.Kind == "dns" {
+
+		if cfg.DnsDiscoveryConfig == nil || cfg.DnsHostname == "" {
+			return config.DiscoveryConfig{}, errors.New("dns_hostname is required")
+		}
+
+		if cfg.DnsPort <= 0 || cfg.DnsPort > 65535 {
+			return config.DiscoveryConfig{}, errors.New("dns_port is required and should be valid port")
+		}
+
+		switch cfg.DnsProtocol {
+		case
+			"udp",
+			"tcp":
+		case "":
+			cfg.DnsProtocol = "udp"
+		default:
+			return config.DiscoveryConfig{}, errors.New("Not supported dns_protocol " + cfg.DnsProtocol)
+		}
+
+		switch cfg.DnsFamily {
+		case
+			"any",
+			"ipv4",
+			"ipv6",
+			"prefer_ipv4",
+			"prefer_ipv6":
+		case "":
+			cfg.DnsFamily = "any"
+		default:
+			return config.DiscoveryConfig{}, errors.New("Not supported dns_family " + cfg.DnsFamily)
+		}
+
+		if cfg.DnsMinTtl == "" {
+			cfg.DnsMinTtl = "5s"
+		}
+
+		if cfg.DnsMaxTtl == "" {
+			cfg.DnsMaxTtl = "5m"
+		}
+
+		if err := checkTtlRange(cfg.DnsMinTtl, cfg.DnsMaxTtl); err != nil {
+			return config.DiscoveryConfig{}, errors.New("dns_min_ttl and dns_max_ttl: " + err.Error())
+		}
+	}
+
+	/* LXD Discovery */
+	if cfg.Kind == "lxd" {
+
+		if cfg.LXDServerAddress == "" {
+			return config.DiscoveryConfig{}, errors.New("lxd_server_address is required" + cfg.LXDServerAddress)
+		}
+
+		if !(strings.HasPrefix(cfg.LXDServerAddress, "https:") ||
+			strings.HasPrefix(cfg.LXDServerAddress, "unix:")) {
+
+			return config.DiscoveryConfig{}, errors.New("lxd_server_address should start with either unix:// or https:// but got " + cfg.LXDServerAddress)
+		}
+
+		if cfg.LXDServerRemoteName == "" {
+			cfg.LXDServerRemoteName = "local"
+		}
+
+		if cfg.LXDConfigDirectory == "" {
+			cfg.LXDConfigDirectory = os.ExpandEnv("$HOME/.config/lxc")
+		}
+
+		if cfg.LXDContainerInterface == "" {
+			cfg.LXDContainerInterface = "eth0"
+		}
+
+		switch cfg.LXDContainerAddressType {
+		case
+			"IPv4",
+			"IPv6":
+		case "":
+			cfg.LXDContainerAddressType = "IPv4"
+		default:
+			return config.DiscoveryConfig{}, errors.New("Invalid lxd_container_address_type. Must be IPv4 or IPv6")
+		}
+
+	}
+
+	/* Kubernetes Discovery */
+	if cfg.Kind == "kubernetes" {
+
+		if cfg.KubernetesDiscoveryConfig == nil {
+			cfg.KubernetesDiscoveryConfig = &config.KubernetesDiscoveryConfig{}
+		}
+
+		if cfg.KubernetesService == "" {
+			return config.DiscoveryConfig{}, errors.New("kubernetes_service is required")
+		}
+
+		switch cfg.KubernetesResource {
+		case
+			"endpointslices",
+			"endpoints":
+		case "":
+			cfg.KubernetesResource = "endpointslices"
+		default:
+			return config.DiscoveryConfig{}, errors.New("Not supported kubernetes_resource " + cfg.KubernetesResource)
+		}
+
+		if cfg.KubernetesAnnotationPrefix == "" {
+			cfg.KubernetesAnnotationPrefix = "gobetween.io/"
+		}
+	}
+
+	/* Etcd Discovery */
+	if cfg.Kind == "etcd" {
+
+		if cfg.EtcdDiscoveryConfig == nil || len(cfg.EtcdEndpoints) == 0 {
+			return config.DiscoveryConfig{}, errors.New("etcd_endpoints is required")
+		}
+
+		if cfg.EtcdPrefix == "" {
+			return config.DiscoveryConfig{}, errors.New("etcd_prefix is required")
+		}
+
+		if (cfg.EtcdTlsCertPath == "") != (cfg.EtcdTlsKeyPath == "") {
+			return config.DiscoveryConfig{}, errors.New("etcd_tls_cert_path and etcd_tls_key_path should be specified together")
+		}
+	}
+
+	/* File Discovery */
+	if cfg.Kind == "file" {
+
+		if cfg.FileDiscoveryConfig == nil || cfg.FilePath == "" {
+			return config.DiscoveryConfig{}, errors.New("file_path is required")
+		}
+
+		switch cfg.FileFormat {
+		case
+			"default",
+			"json":
+		case "":
+			cfg.FileFormat = "default"
+		default:
+			return config.DiscoveryConfig{}, errors.New("Not supported file_format " + cfg.FileFormat)
+		}
+	}
+
+	/* Multi Discovery */
+	if cfg.Kind == "multi" {
+
+		if cfg.MultiDiscoveryConfig == nil || len(cfg.MultiSources) == 0 {
+			return config.DiscoveryConfig{}, errors.New("multi_sources is required")
+		}
+
+		sources := make([]config.MultiDiscoverySource, len(cfg.MultiSources))
+		for i, source := range cfg.MultiSources {
+
+			switch source.Kind {
+			case "":
+				return config.DiscoveryConfig{}, errors.New("kind is required for multi_sources")
+			case "multi":
+				return config.DiscoveryConfig{}, errors.New("multi discovery can not be nested")
+			}
+
+			if source.Priority != nil && *source.Priority < 0 {
+				return config.DiscoveryConfig{}, errors.New("multi_sources priority should not be negative")
+			}
+
+			if source.Weight != nil && *source.Weight < 0 {
+				return config.DiscoveryConfig{}, errors.New("multi_sources weight should not be negative")
+			}
+
+			prepared, err := prepareDiscoveryConfig(source.DiscoveryConfig)
+			if err != nil {
+				return config.DiscoveryConfig{}, err
+			}
+
+			sources[i] = source
+			sources[i].DiscoveryConfig = prepared
+		}
+
+		cfg.MultiDiscoveryConfig = &config.MultiDiscoveryConfig{MultiSources: sources}
+	}
+
+	return cfg, nil
+}
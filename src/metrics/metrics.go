@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -19,8 +22,13 @@ const (
 )
 
 var (
-	metricsDisabled bool = false
-	log                  = logging.For("metrics")
+	metricsDisabled atomic.Bool
+	log             = logging.For("metrics")
+	registerOnce    sync.Once
+
+	// last reported cumulative totals, to add their growth to counters
+	totalsMutex sync.Mutex
+	totals      = map[totalKey]uint64{}
 
 	buildInfo *prometheus.GaugeVec
 	version   string
@@ -34,6 +42,12 @@ var (
 	serverRxSecond          *prometheus.GaugeVec
 	serverTxSecond          *prometheus.GaugeVec
 
+	serverRxBytes             *prometheus.CounterVec
+	serverTxBytes             *prometheus.CounterVec
+	serverConnectionsAccepted *prometheus.CounterVec
+	serverConnectionsRejected *prometheus.CounterVec
+	serverElectDuration       *prometheus.HistogramVec
+
 	backendActiveConnections  *prometheus.GaugeVec
 	backendRefusedConnections *prometheus.GaugeVec
 	backendTotalConnections   *prometheus.GaugeVec
@@ -42,8 +56,36 @@ var (
 	backendRxSecond           *prometheus.GaugeVec
 	backendTxSecond           *prometheus.GaugeVec
 	backendLive               *prometheus.GaugeVec
+	backendEjected            *prometheus.GaugeVec
+
+	backendRxBytesTotal       *prometheus.CounterVec
+	backendTxBytesTotal       *prometheus.CounterVec
+	backendDialFailures       *prometheus.CounterVec
+	backendConnectDuration    *prometheus.HistogramVec
+	backendConnectionDuration *prometheus.HistogramVec
+	backendConnectionRxBytes  *prometheus.HistogramVec
+	backendConnectionTxBytes  *prometheus.HistogramVec
+
+	certificateExpiry *prometheus.GaugeVec
 )
 
+/**
+ * Key of cumulative total reported as counter
+ */
+type totalKey struct {
+	name   string
+	server string
+	target core.Target
+}
+
+/**
+ * Metrics are defined on init, so that reporting is safe before Start
+ * and from goroutines running while metrics are started or disabled
+ */
+func init() {
+	defineMetrics()
+}
+
 func defineMetrics() {
 
 	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -73,14 +115,14 @@ func defineMetrics() {
 		Namespace: namespace,
 		Subsystem: "server",
 		Name:      "rx_total",
-		Help:      "Server Rx Total.",
+		Help:      "Server Rx Total. Deprecated, use gobetween_server_rx_bytes_total.",
 	}, []string{"server"})
 
 	serverTxTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Subsystem: "server",
 		Name:      "tx_total",
-		Help:      "Server Tx Total.",
+		Help:      "Server Tx Total. Deprecated, use gobetween_server_tx_bytes_total.",
 	}, []string{"server"})
 
 	serverRxSecond = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -97,6 +139,42 @@ func defineMetrics() {
 		Help:      "Server Tx per Second.",
 	}, []string{"server"})
 
+	serverRxBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "rx_bytes_total",
+		Help:      "Server Bytes Received.",
+	}, []string{"server"})
+
+	serverTxBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "tx_bytes_total",
+		Help:      "Server Bytes Sent.",
+	}, []string{"server"})
+
+	serverConnectionsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "connections_accepted_total",
+		Help:      "Server Accepted Connections (Udp Sessions), Including Rejected Later.",
+	}, []string{"server"})
+
+	serverConnectionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "connections_rejected_total",
+		Help:      "Server Rejected Connections (Udp Packets) by Reason.",
+	}, []string{"server", "reason"})
+
+	serverElectDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "server",
+		Name:      "elect_duration_seconds",
+		Help:      "Server Backend Election Time.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"server"})
+
 	backendActiveConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Subsystem: "backend",
@@ -122,14 +200,14 @@ func defineMetrics() {
 		Namespace: namespace,
 		Subsystem: "backend",
 		Name:      "rx_bytes",
-		Help:      "Backend Rx Bytes.",
+		Help:      "Backend Rx Bytes. Deprecated, use gobetween_backend_rx_bytes_total.",
 	}, []string{"server", "host", "port"})
 
 	backendTxBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: namespace,
 		Subsystem: "backend",
 		Name:      "tx_bytes",
-		Help:      "Backend Tx Bytes.",
+		Help:      "Backend Tx Bytes. Deprecated, use gobetween_backend_tx_bytes_total.",
 	}, []string{"server", "host", "port"})
 
 	backendRxSecond = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -153,18 +231,104 @@ func defineMetrics() {
 		Help:      "Backend Alive.",
 	}, []string{"server", "host", "port"})
 
+	backendEjected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "ejected",
+		Help:      "Backend Ejected by Outlier Detection.",
+	}, []string{"server", "host", "port"})
+
+	backendRxBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "rx_bytes_total",
+		Help:      "Backend Bytes Received.",
+	}, []string{"server", "host", "port"})
+
+	backendTxBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "tx_bytes_total",
+		Help:      "Backend Bytes Sent.",
+	}, []string{"server", "host", "port"})
+
+	backendDialFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "dial_failures_total",
+		Help:      "Backend Dial Failures by Reason.",
+	}, []string{"server", "host", "port", "reason"})
+
+	backendConnectDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "connect_duration_seconds",
+		Help:      "Backend Connect Time.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"server", "host", "port"})
+
+	backendConnectionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "connection_duration_seconds",
+		Help:      "Backend Connection (Udp Session) Duration.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 12),
+	}, []string{"server", "host", "port"})
+
+	backendConnectionRxBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "connection_rx_bytes",
+		Help:      "Backend Connection (Udp Session) Bytes Received from Client.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 12),
+	}, []string{"server", "host", "port"})
+
+	backendConnectionTxBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "connection_tx_bytes",
+		Help:      "Backend Connection (Udp Session) Bytes Sent to Client.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 12),
+	}, []string{"server", "host", "port"})
+
+	certificateExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "certificate",
+		Name:      "expiry_timestamp_seconds",
+		Help:      "Certificate NotAfter Unix Timestamp.",
+	}, []string{"server", "type", "path"})
+
 }
 
 func Start(cfg config.MetricsConfig) {
 
+	metricsDisabled.Store(!cfg.Enabled)
+
 	if !cfg.Enabled {
 		log.Info("Metrics disabled")
-		metricsDisabled = true
 		return
 	}
 
 	log.Info("Starting up Metrics server ", cfg.Bind)
-	defineMetrics()
+	Collect()
+
+	go func() {
+		log.Errorf("Failed to listen and serve prometeus metrics endpoint: %v", http.ListenAndServe(cfg.Bind, nil))
+	}()
+}
+
+/**
+ * Collect metrics, without serving them, so that they can be exported otherwise
+ */
+func Collect() {
+
+	metricsDisabled.Store(false)
+
+	// metrics are defined and registered once, even if started again
+	registerOnce.Do(register)
+}
+
+func register() {
 
 	prometheus.MustRegister(buildInfo)
 	buildInfo.WithLabelValues(info.Version, info.Revision, info.Branch, runtime.Version()).Set(1)
@@ -175,6 +339,11 @@ func Start(cfg config.MetricsConfig) {
 	prometheus.MustRegister(serverTxTotal)
 	prometheus.MustRegister(serverRxSecond)
 	prometheus.MustRegister(serverTxSecond)
+	prometheus.MustRegister(serverRxBytes)
+	prometheus.MustRegister(serverTxBytes)
+	prometheus.MustRegister(serverConnectionsAccepted)
+	prometheus.MustRegister(serverConnectionsRejected)
+	prometheus.MustRegister(serverElectDuration)
 
 	prometheus.MustRegister(backendActiveConnections)
 	prometheus.MustRegister(backendRefusedConnections)
@@ -184,15 +353,22 @@ func Start(cfg config.MetricsConfig) {
 	prometheus.MustRegister(backendRxSecond)
 	prometheus.MustRegister(backendTxSecond)
 	prometheus.MustRegister(backendLive)
+	prometheus.MustRegister(backendEjected)
+	prometheus.MustRegister(backendRxBytesTotal)
+	prometheus.MustRegister(backendTxBytesTotal)
+	prometheus.MustRegister(backendDialFailures)
+	prometheus.MustRegister(backendConnectDuration)
+	prometheus.MustRegister(backendConnectionDuration)
+	prometheus.MustRegister(backendConnectionRxBytes)
+	prometheus.MustRegister(backendConnectionTxBytes)
+
+	prometheus.MustRegister(certificateExpiry)
 
 	http.Handle("/metrics", promhttp.Handler())
-	go func() {
-		log.Errorf("Failed to listen and serve prometeus metrics endpoint: %v", http.ListenAndServe(cfg.Bind, nil))
-	}()
 }
 
 func RemoveServer(server string, backends map[core.Target]*core.Backend) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -202,6 +378,12 @@ func RemoveServer(server string, backends map[core.Target]*core.Backend) {
 	serverTxTotal.DeleteLabelValues(server)
 	serverRxSecond.DeleteLabelValues(server)
 	serverTxSecond.DeleteLabelValues(server)
+	serverRxBytes.DeleteLabelValues(server)
+	serverTxBytes.DeleteLabelValues(server)
+	removeTotals(totalKey{"rx", server, core.Target{}}, totalKey{"tx", server, core.Target{}})
+	serverConnectionsAccepted.DeleteLabelValues(server)
+	serverConnectionsRejected.DeletePartialMatch(prometheus.Labels{"server": server})
+	serverElectDuration.DeleteLabelValues(server)
 
 	for _, backend := range backends {
 		RemoveBackend(server, backend)
@@ -209,7 +391,7 @@ func RemoveServer(server string, backends map[core.Target]*core.Backend) {
 }
 
 func RemoveBackend(server string, backend *core.Backend) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -221,10 +403,116 @@ func RemoveBackend(server string, backend *core.Backend) {
 	backendRxSecond.DeleteLabelValues(server, backend.Host, backend.Port)
 	backendTxSecond.DeleteLabelValues(server, backend.Host, backend.Port)
 	backendLive.DeleteLabelValues(server, backend.Host, backend.Port)
+	backendEjected.DeleteLabelValues(server, backend.Host, backend.Port)
+	backendRxBytesTotal.DeleteLabelValues(server, backend.Host, backend.Port)
+	backendTxBytesTotal.DeleteLabelValues(server, backend.Host, backend.Port)
+	removeTotals(totalKey{"rx", server, backend.Target}, totalKey{"tx", server, backend.Target})
+	backendDialFailures.DeletePartialMatch(prometheus.Labels{"server": server, "host": backend.Host, "port": backend.Port})
+	backendConnectDuration.DeleteLabelValues(server, backend.Host, backend.Port)
+	backendConnectionDuration.DeleteLabelValues(server, backend.Host, backend.Port)
+	backendConnectionRxBytes.DeleteLabelValues(server, backend.Host, backend.Port)
+	backendConnectionTxBytes.DeleteLabelValues(server, backend.Host, backend.Port)
+}
+
+/**
+ * Adds growth of cumulative total since last report to counter.
+ * Total lower than last one was started over, so it's added whole
+ */
+func addTotal(counter prometheus.Counter, key totalKey, total uint64) {
+
+	totalsMutex.Lock()
+	last := totals[key]
+	totals[key] = total
+	totalsMutex.Unlock()
+
+	if total < last {
+		last = 0
+	}
+
+	counter.Add(float64(total - last))
+}
+
+func removeTotals(keys ...totalKey) {
+
+	totalsMutex.Lock()
+	defer totalsMutex.Unlock()
+
+	for _, key := range keys {
+		delete(totals, key)
+	}
+}
+
+func ReportCertificates(server string, certificates []core.CertificateInfo) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	certificateExpiry.DeletePartialMatch(prometheus.Labels{"server": server})
+	for _, c := range certificates {
+		certificateExpiry.WithLabelValues(server, c.Type, c.Path).Set(float64(c.NotAfter.Unix()))
+	}
+}
+
+func RemoveCertificates(server string) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	certificateExpiry.DeletePartialMatch(prometheus.Labels{"server": server})
+}
+
+func ReportConnectionAccepted(server string) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	serverConnectionsAccepted.WithLabelValues(server).Inc()
+}
+
+func ReportConnectionRejected(server string, reason string) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	serverConnectionsRejected.WithLabelValues(server, reason).Inc()
+}
+
+func ReportBackendElect(server string, duration time.Duration) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	serverElectDuration.WithLabelValues(server).Observe(duration.Seconds())
+}
+
+func ReportBackendDialFailure(server string, target core.Target, reason string) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	backendDialFailures.WithLabelValues(server, target.Host, target.Port, reason).Inc()
+}
+
+func ReportBackendConnect(server string, target core.Target, duration time.Duration) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	backendConnectDuration.WithLabelValues(server, target.Host, target.Port).Observe(duration.Seconds())
+}
+
+func ReportConnectionEnd(server string, target core.Target, duration time.Duration, rx uint64, tx uint64) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	backendConnectionDuration.WithLabelValues(server, target.Host, target.Port).Observe(duration.Seconds())
+	backendConnectionRxBytes.WithLabelValues(server, target.Host, target.Port).Observe(float64(rx))
+	backendConnectionTxBytes.WithLabelValues(server, target.Host, target.Port).Observe(float64(tx))
 }
 
 func ReportHandleBackendLiveChange(server string, target core.Target, live bool) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -236,8 +524,21 @@ func ReportHandleBackendLiveChange(server string, target core.Target, live bool)
 	backendLive.WithLabelValues(server, target.Host, target.Port).Set(float64(intLive))
 }
 
+func ReportHandleBackendEjectedChange(server string, target core.Target, ejected bool) {
+	if metricsDisabled.Load() {
+		return
+	}
+
+	intEjected := int(0)
+	if ejected {
+		intEjected = 1
+	}
+
+	backendEjected.WithLabelValues(server, target.Host, target.Port).Set(float64(intEjected))
+}
+
 func ReportHandleConnectionsChange(server string, connections uint) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -245,7 +546,7 @@ func ReportHandleConnectionsChange(server string, connections uint) {
 }
 
 func ReportHandleStatsChange(server string, bs counters.BandwidthStats) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -253,10 +554,13 @@ func ReportHandleStatsChange(server string, bs counters.BandwidthStats) {
 	serverTxTotal.WithLabelValues(server).Set(float64(bs.TxTotal))
 	serverRxSecond.WithLabelValues(server).Set(float64(bs.RxSecond))
 	serverTxSecond.WithLabelValues(server).Set(float64(bs.TxSecond))
+
+	addTotal(serverRxBytes.WithLabelValues(server), totalKey{"rx", server, core.Target{}}, bs.RxTotal)
+	addTotal(serverTxBytes.WithLabelValues(server), totalKey{"tx", server, core.Target{}}, bs.TxTotal)
 }
 
 func ReportHandleBackendStatsChange(server string, target core.Target, backends map[core.Target]*core.Backend) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -268,10 +572,13 @@ func ReportHandleBackendStatsChange(server string, target core.Target, backends
 	backendTxBytes.WithLabelValues(server, target.Host, target.Port).Set(float64(backend.Stats.TxBytes))
 	backendRxSecond.WithLabelValues(server, target.Host, target.Port).Set(float64(backend.Stats.RxSecond))
 	backendTxSecond.WithLabelValues(server, target.Host, target.Port).Set(float64(backend.Stats.TxSecond))
+
+	addTotal(backendRxBytesTotal.WithLabelValues(server, target.Host, target.Port), totalKey{"rx", server, target}, backend.Stats.RxBytes)
+	addTotal(backendTxBytesTotal.WithLabelValues(server, target.Host, target.Port), totalKey{"tx", server, target}, backend.Stats.TxBytes)
 }
 
 func ReportHandleOp(server string, target core.Target, backends map[core.Target]*core.Backend) {
-	if metricsDisabled {
+	if metricsDisabled.Load() {
 		return
 	}
 
@@ -0,0 +1,317 @@
+package statsd
+
+/**
+ * statsd.go - push of servers and backends stats to StatsD or DogStatsD
+ */
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/stats"
+	"github.com/yyyar/gobetween/utils"
+)
+
+/**
+ * Max size of udp packet with metrics, fits into ethernet mtu
+ */
+const MAX_PACKET_SIZE = 1432
+
+/**
+ * Emitter pushing stats at interval
+ */
+type emitter struct {
+	conn      net.Conn
+	interval  time.Duration
+	dogstatsd bool
+	prefix    string
+	tags      []string
+
+	/* Totals pushed last time, to push counters increments */
+	previous map[string]uint64
+
+	stop chan bool
+	done chan bool
+}
+
+var (
+	mu      sync.Mutex
+	current *emitter
+)
+
+/**
+ * Start pushing stats. Push is disabled if cfg is nil
+ */
+func Start(cfg *config.StatsdConfig) error {
+
+	log := logging.For("statsd")
+
+	if cfg == nil {
+		return nil
+	}
+
+	this := &emitter{
+		interval: utils.ParseDurationOrDefault(cfg.Interval, 10*time.Second),
+		prefix:   cfg.Prefix,
+		tags:     cfg.Tags,
+		previous: map[string]uint64{},
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+
+	switch cfg.Format {
+	case "", "statsd":
+		if len(cfg.Tags) > 0 {
+			return errors.New("statsd tags are supported only in dogstatsd format")
+		}
+	case "dogstatsd":
+		this.dogstatsd = true
+	default:
+		return errors.New("Not supported statsd format " + cfg.Format)
+	}
+
+	if this.interval <= 0 {
+		return errors.New("statsd interval should be positive")
+	}
+
+	if this.prefix == "" {
+		this.prefix = "gobetween"
+	}
+
+	address := cfg.Address
+	if address == "" {
+		address = "127.0.0.1:8125"
+	}
+
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	this.conn = conn
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if current != nil {
+		return errors.New("statsd is already started")
+	}
+	current = this
+
+	go this.run()
+
+	log.Info("Pushing stats to statsd ", address, " every ", this.interval)
+
+	return nil
+}
+
+/**
+ * Stop pushing stats, pushing them last time
+ */
+func Stop() {
+
+	mu.Lock()
+	this := current
+	current = nil
+	mu.Unlock()
+
+	if this == nil {
+		return
+	}
+
+	close(this.stop)
+	<-this.done
+}
+
+/**
+ * Push stats until stopped
+ */
+func (this *emitter) run() {
+
+	defer close(this.done)
+	defer this.conn.Close()
+
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			this.push()
+		case <-this.stop:
+			this.push()
+			return
+		}
+	}
+}
+
+/**
+ * Push current stats of all servers and their backends
+ */
+func (this *emitter) push() {
+
+	all := stats.All()
+
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	totals := make(map[string]uint64, len(this.previous))
+	packet := &packet{conn: this.conn}
+
+	for _, name := range names {
+		s := all[name]
+
+		server := this.metric(packet, totals, ".server."+nameSegment(name)+".", ".server.", "server:"+tagValue(name))
+
+		server.gauge("active_connections", uint64(s.ActiveConnections))
+		server.gauge("backends", uint64(len(s.Backends)))
+		server.counter("rx_bytes", s.RxTotal)
+		server.counter("tx_bytes", s.TxTotal)
+		server.gauge("rx_second", uint64(s.RxSecond))
+		server.gauge("tx_second", uint64(s.TxSecond))
+
+		for _, b := range s.Backends {
+			backend := this.metric(packet, totals,
+				".backend."+nameSegment(name)+"."+nameSegment(b.Host)+"_"+nameSegment(b.Port)+".",
+				".backend.", "server:"+tagValue(name), "host:"+tagValue(b.Host), "port:"+tagValue(b.Port))
+
+			live := uint64(0)
+			if b.Stats.Live {
+				live = 1
+			}
+
+			backend.gauge("live", live)
+			backend.gauge("active_connections", uint64(b.Stats.ActiveConnections))
+			backend.counter("connections", uint64(b.Stats.TotalConnections))
+			backend.counter("refused_connections", b.Stats.RefusedConnections)
+			backend.counter("rx_bytes", b.Stats.RxBytes)
+			backend.counter("tx_bytes", b.Stats.TxBytes)
+			backend.gauge("rx_second", uint64(b.Stats.RxSecond))
+			backend.gauge("tx_second", uint64(b.Stats.TxSecond))
+		}
+	}
+
+	this.previous = totals
+
+	if err := packet.flush(); err != nil {
+		logging.For("statsd").Warn("Could not push stats: ", err)
+	}
+}
+
+/**
+ * Make metrics of one server or backend. In statsd format its identity is part of metric
+ * name after prefix, in dogstatsd format metric name is common and identity is in tags
+ */
+func (this *emitter) metric(packet *packet, totals map[string]uint64, statsdPath string, dogstatsdPath string, tags ...string) metric {
+
+	if !this.dogstatsd {
+		return metric{emitter: this, packet: packet, totals: totals, prefix: this.prefix + statsdPath}
+	}
+
+	return metric{
+		emitter: this,
+		packet:  packet,
+		totals:  totals,
+		prefix:  this.prefix + dogstatsdPath,
+		tags:    "|#" + strings.Join(append(append([]string{}, this.tags...), tags...), ","),
+	}
+}
+
+/**
+ * Metrics of one server or backend
+ */
+type metric struct {
+	emitter *emitter
+	packet  *packet
+	totals  map[string]uint64
+	prefix  string
+	tags    string
+}
+
+func (this metric) gauge(name string, value uint64) {
+	this.write(name, value, "g")
+}
+
+/**
+ * Counter is pushed as increment of total since last push
+ */
+func (this metric) counter(name string, total uint64) {
+
+	key := this.prefix + name + this.tags
+
+	delta := total
+	if previous, ok := this.emitter.previous[key]; ok && previous <= total {
+		delta = total - previous
+	}
+	this.totals[key] = total
+
+	this.write(name, delta, "c")
+}
+
+func (this metric) write(name string, value uint64, typ string) {
+
+	this.packet.add(this.prefix + name + ":" + strconv.FormatUint(value, 10) + "|" + typ + this.tags)
+}
+
+/**
+ * Udp packets of newline separated metrics, sent when full.
+ * First write error is remembered and returned by final flush
+ */
+type packet struct {
+	conn net.Conn
+	buf  bytes.Buffer
+	err  error
+}
+
+func (this *packet) add(line string) {
+
+	if this.buf.Len() > 0 && this.buf.Len()+1+len(line) > MAX_PACKET_SIZE {
+		this.send()
+	}
+
+	if this.buf.Len() > 0 {
+		this.buf.WriteByte('\n')
+	}
+	this.buf.WriteString(line)
+}
+
+func (this *packet) send() {
+
+	if this.buf.Len() == 0 {
+		return
+	}
+
+	if _, err := this.conn.Write(this.buf.Bytes()); err != nil && this.err == nil {
+		this.err = err
+	}
+	this.buf.Reset()
+}
+
+func (this *packet) flush() error {
+	this.send()
+	return this.err
+}
+
+/**
+ * Make name usable as segment of dot-separated metric name
+ */
+func nameSegment(name string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "/", "_", " ", "_", "|", "_", "@", "_").Replace(name)
+}
+
+/**
+ * Make name usable as dogstatsd tag value
+ */
+func tagValue(value string) string {
+	return strings.NewReplacer(",", "_", "|", "_", " ", "_").Replace(value)
+}
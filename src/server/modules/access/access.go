@@ -11,6 +11,7 @@ import (
 	"net"
 
 	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
 )
 
 /**
@@ -56,12 +57,34 @@ func NewAccess(cfg *config.AccessConfig) (*Access, error) {
 }
 
 /**
- * Checks if ip is allowed
+ * Checks if client with ip and identity, if it's known, is allowed
  */
-func (this *Access) Allows(ip *net.IP) bool {
+func (this *Access) Allows(ip *net.IP, client *core.ClientIdentity) bool {
 
 	for _, r := range this.Rules {
-		if r.Matches(ip) {
+		if r.Matches(ip, client) {
+			return r.Allows()
+		}
+	}
+
+	return this.AllowDefault
+}
+
+/**
+ * Checks if client with ip may be allowed before its identity is known.
+ * False means client is denied whatever identity it has, so it can be
+ * rejected before tls handshake
+ */
+func (this *Access) MayAllow(ip *net.IP) bool {
+
+	for _, r := range this.Rules {
+		if r.Client != nil {
+			if r.Allows() {
+				return true
+			}
+			continue
+		}
+		if r.Matches(ip, nil) {
 			return r.Allows()
 		}
 	}
@@ -10,17 +10,21 @@ import (
 	"errors"
 	"net"
 	"strings"
+
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/utils/tls/sni"
 )
 
 /**
  * AccessRule defines order (access, deny)
- * and IP or Network
+ * and IP or Network, or client certificate name pattern
  */
 type AccessRule struct {
 	Allow     bool
 	IsNetwork bool
 	Ip        *net.IP
 	Network   *net.IPNet
+	Client    *sni.Matcher
 }
 
 /**
@@ -29,7 +33,7 @@ type AccessRule struct {
 func ParseAccessRule(rule string) (*AccessRule, error) {
 
 	parts := strings.Split(rule, " ")
-	if len(parts) != 2 {
+	if len(parts) != 2 && (len(parts) != 3 || parts[1] != "client") {
 		return nil, errors.New("Bad access rule format: " + rule)
 	}
 
@@ -40,6 +44,18 @@ func ParseAccessRule(rule string) (*AccessRule, error) {
 		return nil, errors.New("Cant parse rule definition " + rule)
 	}
 
+	// client certificate name pattern
+	if len(parts) == 3 {
+		client, err := sni.NewMatcher([]string{parts[2]})
+		if err != nil {
+			return nil, errors.New("Cant parse access rule client pattern " + parts[2] + ": " + err.Error())
+		}
+		return &AccessRule{
+			Allow:  r == "allow",
+			Client: client,
+		}, nil
+	}
+
 	// try check if cidrOrIp is ip and handle
 
 	ipShould := net.ParseIP(cidrOrIp)
@@ -67,9 +83,21 @@ func ParseAccessRule(rule string) (*AccessRule, error) {
 }
 
 /**
- * Checks if ip matches access rule
+ * Checks if ip, or client identity if it's known, matches access rule
  */
-func (this *AccessRule) Matches(ip *net.IP) bool {
+func (this *AccessRule) Matches(ip *net.IP, client *core.ClientIdentity) bool {
+
+	if this.Client != nil {
+		if client == nil {
+			return false
+		}
+		for _, name := range client.Names() {
+			if this.Client.Match(name) {
+				return true
+			}
+		}
+		return false
+	}
 
 	switch this.IsNetwork {
 	case true:
@@ -0,0 +1,245 @@
+package scheduler
+
+/**
+ * outlier.go - passive health checking, ejects backends failing real connections
+ */
+
+import (
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/utils"
+)
+
+/**
+ * Connection results of a backend and its ejection state
+ */
+type outlierState struct {
+
+	/* Failures in a row */
+	consecutiveFailures int
+
+	/* Results in current window */
+	windowStart    time.Time
+	windowFailures int
+	windowTotal    int
+
+	/* Number of ejections in a row, used for backoff */
+	ejections int
+
+	/* Time until ejected backend is out of rotation, zero if not ejected */
+	ejectedUntil time.Time
+
+	/* Time backend was ejected last time */
+	lastEjection time.Time
+}
+
+/**
+ * OutlierDetector tracks results of connections to backends
+ * and decides when backend should be ejected and re-admitted
+ */
+type OutlierDetector struct {
+	consecutiveFailures int
+	failureRatio        float64
+	window              time.Duration
+	minRequests         int
+	ejectionTime        time.Duration
+	maxEjectionTime     time.Duration
+	maxEjectionPercent  int
+
+	states map[core.Target]*outlierState
+}
+
+/**
+ * Creates new OutlierDetector based on config,
+ * nil config makes detector that never ejects
+ */
+func NewOutlierDetector(cfg *config.OutlierDetection) *OutlierDetector {
+
+	detector := &OutlierDetector{
+		states: make(map[core.Target]*outlierState),
+	}
+
+	if cfg == nil {
+		return detector
+	}
+
+	detector.consecutiveFailures = cfg.ConsecutiveFailures
+	detector.failureRatio = cfg.FailureRatio
+	detector.window = utils.ParseDurationOrDefault(cfg.Window, 0)
+	detector.minRequests = cfg.MinRequests
+	detector.ejectionTime = utils.ParseDurationOrDefault(cfg.EjectionTime, 0)
+	detector.maxEjectionTime = utils.ParseDurationOrDefault(cfg.MaxEjectionTime, 0)
+	detector.maxEjectionPercent = cfg.MaxEjectionPercent
+
+	return detector
+}
+
+/**
+ * Returns true if detector may eject backends
+ */
+func (this *OutlierDetector) Enabled() bool {
+	return this.consecutiveFailures > 0 || this.failureRatio > 0
+}
+
+/**
+ * Get or create state of target
+ */
+func (this *OutlierDetector) state(target core.Target) *outlierState {
+
+	s, ok := this.states[target]
+	if !ok {
+		s = &outlierState{}
+		this.states[target] = s
+	}
+
+	return s
+}
+
+/**
+ * Count result in current window, starting next one if it's over
+ */
+func (this *OutlierDetector) count(s *outlierState, failed bool, now time.Time) {
+
+	if now.Sub(s.windowStart) > this.window {
+		s.windowStart = now
+		s.windowFailures = 0
+		s.windowTotal = 0
+	}
+
+	s.windowTotal++
+	if failed {
+		s.windowFailures++
+	}
+}
+
+/**
+ * Record successful connection to target
+ */
+func (this *OutlierDetector) Success(target core.Target, now time.Time) {
+
+	if !this.Enabled() {
+		return
+	}
+
+	s := this.state(target)
+	s.consecutiveFailures = 0
+	this.count(s, false, now)
+}
+
+/**
+ * Returns true if one more of backends may be ejected without exceeding
+ * max ejection percent. At least one backend may always be ejected
+ */
+func (this *OutlierDetector) mayEject(backends int) bool {
+
+	if this.maxEjectionPercent <= 0 {
+		return true
+	}
+
+	ejected := 0
+	for _, s := range this.states {
+		if !s.ejectedUntil.IsZero() {
+			ejected++
+		}
+	}
+
+	max := backends * this.maxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+
+	return ejected < max
+}
+
+/**
+ * Record failed connection to target, one of backends.
+ * Returns true if target should be ejected
+ */
+func (this *OutlierDetector) Failure(target core.Target, backends int, now time.Time) bool {
+
+	if !this.Enabled() {
+		return false
+	}
+
+	s := this.state(target)
+	if !s.ejectedUntil.IsZero() {
+		return false
+	}
+
+	s.consecutiveFailures++
+	this.count(s, true, now)
+
+	eject := this.consecutiveFailures > 0 && s.consecutiveFailures >= this.consecutiveFailures
+
+	if this.failureRatio > 0 && s.windowTotal >= this.minRequests &&
+		float64(s.windowFailures)/float64(s.windowTotal) >= this.failureRatio {
+		eject = true
+	}
+
+	if !eject {
+		return false
+	}
+
+	// failures are kept counted, so target is ejected once others are re-admitted
+	if !this.mayEject(backends) {
+		return false
+	}
+
+	// backend that behaved well long enough starts backoff over
+	if now.Sub(s.lastEjection) > this.maxEjectionTime+this.ejectionDuration(s) {
+		s.ejections = 0
+	}
+
+	s.ejections++
+	s.lastEjection = now
+	s.ejectedUntil = now.Add(this.ejectionDuration(s))
+	s.consecutiveFailures = 0
+	s.windowStart = time.Time{}
+
+	return true
+}
+
+/**
+ * Ejection duration growing exponentially with number of ejections in a row
+ */
+func (this *OutlierDetector) ejectionDuration(s *outlierState) time.Duration {
+
+	d := this.ejectionTime
+	for i := 1; i < s.ejections && d < this.maxEjectionTime; i++ {
+		d *= 2
+	}
+
+	if d > this.maxEjectionTime {
+		d = this.maxEjectionTime
+	}
+
+	return d
+}
+
+/**
+ * Returns ejected targets whose ejection time is over,
+ * so that they can be re-admitted
+ */
+func (this *OutlierDetector) Expired(now time.Time) []core.Target {
+
+	result := []core.Target{}
+
+	for target, s := range this.states {
+		if s.ejectedUntil.IsZero() || now.Before(s.ejectedUntil) {
+			continue
+		}
+		s.ejectedUntil = time.Time{}
+		result = append(result, target)
+	}
+
+	return result
+}
+
+/**
+ * Forget target
+ */
+func (this *OutlierDetector) Remove(target core.Target) {
+	delete(this.states, target)
+}
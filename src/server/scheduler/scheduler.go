@@ -7,11 +7,13 @@ package scheduler
  */
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/yyyar/gobetween/core"
 	"github.com/yyyar/gobetween/discovery"
+	"github.com/yyyar/gobetween/events"
 	"github.com/yyyar/gobetween/healthcheck"
 	"github.com/yyyar/gobetween/logging"
 	"github.com/yyyar/gobetween/metrics"
@@ -44,6 +46,11 @@ type Op struct {
 	param  interface{}
 }
 
+/**
+ * How often ejected backends are checked for re-admission
+ */
+const OUTLIER_CHECK_INTERVAL = 1 * time.Second
+
 /**
  * Request to elect backend
  */
@@ -53,6 +60,29 @@ type ElectRequest struct {
 	Err      chan error
 }
 
+/**
+ * Request to override backend properties
+ */
+type OverrideRequest struct {
+	Target   core.Target
+	Override core.BackendOverride
+	Merge    bool
+	Response chan core.Backend
+	Err      chan error
+}
+
+/**
+ * Request to replace scheduler parts on the fly.
+ * Nil parts are kept as is
+ */
+type ReconfigureRequest struct {
+	Balancer    core.Balancer
+	Discovery   *discovery.Discovery
+	Healthcheck *healthcheck.Healthcheck
+	Outlier     *OutlierDetector
+	Done        chan bool
+}
+
 /**
  * Scheduler
  */
@@ -67,11 +97,20 @@ type Scheduler struct {
 	/* Healthcheck impl */
 	Healthcheck *healthcheck.Healthcheck
 
+	/* Passive healthcheck, may be nil */
+	Outlier *OutlierDetector
+
 	/* ----- backends ------*/
 
 	/* Current cached backends map */
 	backends map[core.Target]*core.Backend
 
+	/* Backends as returned by the latest discovery, without overrides */
+	discovered map[core.Target]core.Backend
+
+	/* Manual overrides, kept even if backend is gone from discovery */
+	overrides map[core.Target]*core.BackendOverride
+
 	/* Stats */
 	StatsHandler *stats.Handler
 
@@ -83,8 +122,20 @@ type Scheduler struct {
 	/* Stop channel */
 	stop chan bool
 
+	/* Closed when scheduler goroutine is finished */
+	done chan bool
+
 	/* Elect backend channel */
 	elect chan ElectRequest
+
+	/* Reconfigure channel */
+	reconfigure chan ReconfigureRequest
+
+	/* Override backend channel */
+	override chan OverrideRequest
+
+	/* Current backends request channel */
+	list chan chan []core.Backend
 }
 
 /**
@@ -99,7 +150,17 @@ func (this *Scheduler) Start() {
 	this.ops = make(chan Op)
 	this.elect = make(chan ElectRequest)
 	this.stop = make(chan bool)
+	this.done = make(chan bool)
+	this.reconfigure = make(chan ReconfigureRequest)
+	this.override = make(chan OverrideRequest)
+	this.list = make(chan chan []core.Backend)
 	this.backends = make(map[core.Target]*core.Backend)
+	this.discovered = make(map[core.Target]core.Backend)
+	this.overrides = make(map[core.Target]*core.BackendOverride)
+
+	if this.Outlier == nil {
+		this.Outlier = NewOutlierDetector(nil)
+	}
 
 	this.Discovery.Start()
 	this.Healthcheck.Start()
@@ -107,10 +168,15 @@ func (this *Scheduler) Start() {
 	// backends stats pusher ticker
 	backendsPushTicker := time.NewTicker(2 * time.Second)
 
+	// ejected backends re-admission ticker
+	outlierTicker := time.NewTicker(OUTLIER_CHECK_INTERVAL)
+
 	/**
 	 * Goroutine updates and manages backends
 	 */
 	go func() {
+		defer close(this.done)
+
 		for {
 			select {
 
@@ -122,12 +188,22 @@ func (this *Scheduler) Start() {
 				this.Healthcheck.In <- this.Targets()
 				this.StatsHandler.BackendsCounter.In <- this.Targets()
 
+			// report discovery failure
+			case err := <-this.Discovery.Errors():
+				events.PublishServer(events.DiscoveryError, this.StatsHandler.Name, err.Error())
+
 			/* ------ healthcheck ----- */
 
 			// handle backend healthcheck result
 			case checkResult := <-this.Healthcheck.Out:
 				this.HandleBackendLiveChange(checkResult.Target, checkResult.Status == healthcheck.Healthy)
 
+			// re-admit backends whose ejection is over
+			case now := <-outlierTicker.C:
+				for _, target := range this.Outlier.Expired(now) {
+					this.HandleBackendEjectedChange(target, false)
+				}
+
 			/* ----- stats ----- */
 
 			// push current backends to stats handler
@@ -148,12 +224,26 @@ func (this *Scheduler) Start() {
 			case electReq := <-this.elect:
 				this.HandleBackendElect(electReq)
 
+			// override backend properties
+			case req := <-this.override:
+				this.HandleBackendOverride(req)
+
+			// list current backends
+			case out := <-this.list:
+				out <- this.Backends()
+
+			// replace balancer, discovery or healthcheck
+			case req := <-this.reconfigure:
+				this.HandleReconfigure(req)
+				close(req.Done)
+
 			/* ----- stop ----- */
 
 			// handle scheduler stop
 			case <-this.stop:
 				log.Info("Stopping scheduler ", this.StatsHandler.Name)
 				backendsPushTicker.Stop()
+				outlierTicker.Stop()
 				this.Discovery.Stop()
 				this.Healthcheck.Stop()
 				metrics.RemoveServer(fmt.Sprintf("%s", this.StatsHandler.Name), this.backends)
@@ -219,11 +309,42 @@ func (this *Scheduler) HandleBackendLiveChange(target core.Target, live bool) {
 		return
 	}
 
+	if backend.Stats.Live != live {
+		if live {
+			events.PublishBackend(events.BackendLive, this.StatsHandler.Name, target)
+		} else {
+			events.PublishBackend(events.BackendDead, this.StatsHandler.Name, target)
+		}
+	}
+
 	backend.Stats.Live = live
 
 	metrics.ReportHandleBackendLiveChange(fmt.Sprintf("%s", this.StatsHandler.Name), target, live)
 }
 
+/**
+ * Updated backend ejected status
+ */
+func (this *Scheduler) HandleBackendEjectedChange(target core.Target, ejected bool) {
+
+	log := logging.For("scheduler")
+
+	backend, ok := this.backends[target]
+	if !ok {
+		return
+	}
+
+	backend.Stats.Ejected = ejected
+
+	if ejected {
+		log.Warn("Backend ", target, " of ", this.StatsHandler.Name, " ejected after connection failures")
+	} else {
+		log.Info("Backend ", target, " of ", this.StatsHandler.Name, " re-admitted after ejection")
+	}
+
+	metrics.ReportHandleBackendEjectedChange(this.StatsHandler.Name, target, ejected)
+}
+
 /**
  * Update backends map
  */
@@ -234,22 +355,31 @@ func (this *Scheduler) HandleBackendsUpdate(backends []core.Backend) {
 		b.Stats.Discovered = false
 	}
 
+	this.discovered = make(map[core.Target]core.Backend, len(backends))
+
 	for _, b := range backends {
+		this.discovered[b.Target] = b
+
 		oldB, ok := this.backends[b.Target]
 
 		if ok {
 			// if we have this backend, update it's discovery properties
-			oldB.MergeFrom(b)
-			// mark found backend as discovered
+			// keeping manual overrides on top of them
+			oldB.MergeFrom(b).ApplyOverride(this.overrides[b.Target])
+			// mark found backend as discovered, and whether it came from discovery cache
 			oldB.Stats.Discovered = true
+			oldB.Stats.Stale = b.Stats.Stale
 			continue
 		}
 
 		b := b // b has to be local variable in order to make unique pointers
 		b.Stats.Discovered = true
+		b.ApplyOverride(this.overrides[b.Target])
 		this.backends[b.Target] = &b
 
 		b.Stats.Live = this.Healthcheck.InitialBackendHealthCheckStatus() == healthcheck.Healthy
+
+		events.PublishBackend(events.BackendAdded, this.StatsHandler.Name, b.Target)
 	}
 
 	//remove not discovered backends without active connections
@@ -260,8 +390,28 @@ func (this *Scheduler) HandleBackendsUpdate(backends []core.Backend) {
 
 		metrics.RemoveBackend(this.StatsHandler.Name, b)
 
+		this.Outlier.Remove(t)
 		delete(this.backends, t)
+
+		events.PublishBackend(events.BackendRemoved, this.StatsHandler.Name, t)
 	}
+
+	this.updateBalancer()
+}
+
+/**
+ * Pass discovered backends to balancer, if it keeps state built for them
+ */
+func (this *Scheduler) updateBalancer() {
+
+	backends := make([]*core.Backend, 0, len(this.backends))
+	for _, b := range this.backends {
+		if b.Stats.Discovered {
+			backends = append(backends, b)
+		}
+	}
+
+	core.UpdateBackends(this.Balancer, backends)
 }
 
 /**
@@ -269,6 +419,8 @@ func (this *Scheduler) HandleBackendsUpdate(backends []core.Backend) {
  */
 func (this *Scheduler) HandleBackendElect(req ElectRequest) {
 
+	start := time.Now()
+
 	// Filter only live and discovered backends
 	var backends []*core.Backend
 	for _, b := range this.backends {
@@ -281,11 +433,21 @@ func (this *Scheduler) HandleBackendElect(req ElectRequest) {
 			continue
 		}
 
+		if b.Stats.Ejected {
+			continue
+		}
+
+		if !b.Electable() {
+			continue
+		}
+
 		backends = append(backends, b)
 	}
 
 	// Elect backend
 	backend, err := this.Balancer.Elect(req.Context, backends)
+	metrics.ReportBackendElect(this.StatsHandler.Name, time.Since(start))
+
 	if err != nil {
 		req.Err <- err
 		return
@@ -294,6 +456,88 @@ func (this *Scheduler) HandleBackendElect(req ElectRequest) {
 	req.Response <- *backend
 }
 
+/**
+ * Set or merge manual override of backend properties
+ */
+func (this *Scheduler) HandleBackendOverride(req OverrideRequest) {
+
+	backend, ok := this.backends[req.Target]
+	if !ok {
+		req.Err <- errors.New("Backend not found: " + req.Target.Address())
+		return
+	}
+
+	override := req.Override
+	if current, ok := this.overrides[req.Target]; ok && req.Merge {
+		merged := *current
+		override = *merged.MergeFrom(req.Override)
+	}
+
+	if override.IsEmpty() {
+		delete(this.overrides, req.Target)
+	} else {
+		this.overrides[req.Target] = &override
+	}
+
+	// start over from discovered properties, so removed overrides are reverted
+	if discovered, ok := this.discovered[req.Target]; ok {
+		backend.MergeFrom(discovered)
+	}
+	backend.ApplyOverride(this.overrides[req.Target])
+	this.updateBalancer()
+
+	logging.For("scheduler").Info("Backend ", req.Target, " of ", this.StatsHandler.Name, " overridden: ", backend)
+
+	req.Response <- *backend
+}
+
+/**
+ * Replace scheduler parts, keeping current backends and their stats
+ */
+func (this *Scheduler) HandleReconfigure(req ReconfigureRequest) {
+
+	log := logging.For("scheduler")
+	log.Info("Reconfiguring scheduler ", this.StatsHandler.Name)
+
+	if req.Balancer != nil {
+		this.Balancer = req.Balancer
+		this.updateBalancer()
+	}
+
+	if req.Healthcheck != nil {
+		this.Healthcheck.Stop()
+		this.Healthcheck = req.Healthcheck
+		this.Healthcheck.Start()
+
+		// Without checks nothing will ever mark backends live again,
+		// otherwise keep current status until new checks report
+		if !this.Healthcheck.HasCheck() {
+			for t, b := range this.backends {
+				b.Stats.Live = true
+				metrics.ReportHandleBackendLiveChange(this.StatsHandler.Name, t, true)
+			}
+		}
+
+		this.Healthcheck.In <- this.Targets()
+	}
+
+	if req.Discovery != nil {
+		this.Discovery.Stop()
+		this.Discovery = req.Discovery
+		this.Discovery.Start()
+	}
+
+	// new detector starts from scratch, so re-admit all ejected backends
+	if req.Outlier != nil {
+		this.Outlier = req.Outlier
+		for t, b := range this.backends {
+			if b.Stats.Ejected {
+				this.HandleBackendEjectedChange(t, false)
+			}
+		}
+	}
+}
+
 /**
  * Handle operation on the backend
  */
@@ -321,9 +565,13 @@ func (this *Scheduler) HandleOp(op Op) {
 	switch op.op {
 	case IncrementRefused:
 		backend.Stats.RefusedConnections++
+		if this.Outlier.Failure(op.target, len(this.backends), time.Now()) {
+			this.HandleBackendEjectedChange(op.target, true)
+		}
 	case IncrementConnection:
 		backend.Stats.ActiveConnections++
 		backend.Stats.TotalConnections++
+		this.Outlier.Success(op.target, time.Now())
 	case DecrementConnection:
 		backend.Stats.ActiveConnections--
 	default:
@@ -334,10 +582,58 @@ func (this *Scheduler) HandleOp(op Op) {
 }
 
 /**
- * Stop scheduler
+ * Stop scheduler and wait until operation being handled is finished,
+ * so that stats handler can be stopped after it safely.
+ * Operations requested after stop are ignored
  */
 func (this *Scheduler) Stop() {
-	this.stop <- true
+	close(this.stop)
+	<-this.done
+}
+
+/**
+ * Replace balancer, discovery, healthcheck or outlier detector of running
+ * scheduler and wait until it's done. Nil arguments keep current ones
+ */
+func (this *Scheduler) Reconfigure(balancer core.Balancer, discovery *discovery.Discovery, healthcheck *healthcheck.Healthcheck, outlier *OutlierDetector) {
+	req := ReconfigureRequest{balancer, discovery, healthcheck, outlier, make(chan bool)}
+	select {
+	case this.reconfigure <- req:
+		<-req.Done
+	case <-this.stop:
+	}
+}
+
+/**
+ * Override backend properties at runtime. If merge is true, only
+ * fields set in override are changed, otherwise previous override is replaced
+ */
+func (this *Scheduler) OverrideBackend(target core.Target, override core.BackendOverride, merge bool) (*core.Backend, error) {
+	r := OverrideRequest{target, override, merge, make(chan core.Backend), make(chan error)}
+	select {
+	case this.override <- r:
+	case <-this.stop:
+		return nil, errors.New("Scheduler is stopped")
+	}
+	select {
+	case err := <-r.Err:
+		return nil, err
+	case backend := <-r.Response:
+		return &backend, nil
+	}
+}
+
+/**
+ * Get current backends with their stats and overrides
+ */
+func (this *Scheduler) GetBackends() []core.Backend {
+	out := make(chan []core.Backend)
+	select {
+	case this.list <- out:
+		return <-out
+	case <-this.stop:
+		return []core.Backend{}
+	}
 }
 
 /**
@@ -345,7 +641,11 @@ func (this *Scheduler) Stop() {
  */
 func (this *Scheduler) TakeBackend(context core.Context) (*core.Backend, error) {
 	r := ElectRequest{context, make(chan core.Backend), make(chan error)}
-	this.elect <- r
+	select {
+	case this.elect <- r:
+	case <-this.stop:
+		return nil, errors.New("Scheduler is stopped")
+	}
 	select {
 	case err := <-r.Err:
 		return nil, err
@@ -354,37 +654,47 @@ func (this *Scheduler) TakeBackend(context core.Context) (*core.Backend, error)
 	}
 }
 
+/**
+ * Send operation to scheduler if it's not stopped
+ */
+func (this *Scheduler) op(op Op) {
+	select {
+	case this.ops <- op:
+	case <-this.stop:
+	}
+}
+
 /**
  * Increment connection refused count for backend
  */
 func (this *Scheduler) IncrementRefused(backend core.Backend) {
-	this.ops <- Op{backend.Target, IncrementRefused, nil}
+	this.op(Op{backend.Target, IncrementRefused, nil})
 }
 
 /**
  * Increment backend connection counter
  */
 func (this *Scheduler) IncrementConnection(backend core.Backend) {
-	this.ops <- Op{backend.Target, IncrementConnection, nil}
+	this.op(Op{backend.Target, IncrementConnection, nil})
 }
 
 /**
  * Decrement backends connection counter
  */
 func (this *Scheduler) DecrementConnection(backend core.Backend) {
-	this.ops <- Op{backend.Target, DecrementConnection, nil}
+	this.op(Op{backend.Target, DecrementConnection, nil})
 }
 
 /**
  * Increment Rx stats for backend
  */
 func (this *Scheduler) IncrementRx(backend core.Backend, c uint) {
-	this.ops <- Op{backend.Target, IncrementRx, c}
+	this.op(Op{backend.Target, IncrementRx, c})
 }
 
 /**
  * Increment Tx stats for backends
  */
 func (this *Scheduler) IncrementTx(backend core.Backend, c uint) {
-	this.ops <- Op{backend.Target, IncrementTx, c}
+	this.op(Op{backend.Target, IncrementTx, c})
 }
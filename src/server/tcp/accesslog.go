@@ -0,0 +1,114 @@
+package tcp
+
+/**
+ * accesslog.go - access log records of client connections
+ */
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/yyyar/gobetween/accesslog"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/metrics"
+	"github.com/yyyar/gobetween/telemetry"
+)
+
+/**
+ * Make access log record of client connection
+ */
+func (this *Server) newRecord(ctx *core.TcpContext, cfg config.Server) accesslog.Record {
+
+	accepted := ctx.Accepted
+	if accepted.IsZero() {
+		accepted = time.Now()
+	}
+
+	return accesslog.Record{
+		Time:     accepted,
+		Server:   this.name,
+		Protocol: cfg.Protocol,
+		Client:   ctx.Conn.RemoteAddr().String(),
+		Sni:      ctx.Hostname,
+	}
+}
+
+/**
+ * Complete record of finished client connection, log it and end its trace
+ */
+func (this *Server) logRecord(ctx *core.TcpContext, record *accesslog.Record) {
+
+	record.Duration = time.Since(record.Time)
+
+	if tlsConn, ok := ctx.Conn.(*tls.Conn); ok {
+		if state := tlsConn.ConnectionState(); state.HandshakeComplete {
+			record.TlsVersion = tls.VersionName(state.Version)
+			record.TlsCipher = tls.CipherSuiteName(state.CipherSuite)
+			record.TlsAlpn = state.NegotiatedProtocol
+			if record.Sni == "" {
+				record.Sni = state.ServerName
+			}
+			if len(state.VerifiedChains) > 0 {
+				record.ClientSubject = state.VerifiedChains[0][0].Subject.String()
+			}
+		}
+	}
+
+	accesslog.Log(*record)
+	telemetry.EndConnection(ctx.Trace, *record)
+}
+
+/**
+ * Log client connection rejected before handling it
+ */
+func (this *Server) logRejected(ctx *core.TcpContext, cfg config.Server, termination string) {
+	record := this.newRecord(ctx, cfg)
+	record.Termination = termination
+	this.logRecord(ctx, &record)
+
+	metrics.ReportConnectionRejected(this.name, termination)
+}
+
+/**
+ * Termination reason of connection by the side proxying has ended first
+ */
+func terminationReason(end proxyEnd, clientConn net.Conn) string {
+
+	fromClient := end.from == clientConn
+
+	var netErr net.Error
+	var writeErr writeError
+
+	switch {
+	case end.err == nil:
+		if fromClient {
+			return accesslog.TerminationClientClosed
+		}
+		return accesslog.TerminationBackendClosed
+
+	case errors.Is(end.err, net.ErrClosed):
+		return accesslog.TerminationServerClosed
+
+	case errors.As(end.err, &netErr) && netErr.Timeout():
+		if fromClient {
+			return accesslog.TerminationClientIdleTimeout
+		}
+		return accesslog.TerminationBackendIdleTimeout
+
+	case errors.As(end.err, &writeErr):
+		// writing to the other side failed
+		if fromClient {
+			return accesslog.TerminationBackendError
+		}
+		return accesslog.TerminationClientError
+
+	default:
+		if fromClient {
+			return accesslog.TerminationClientError
+		}
+		return accesslog.TerminationBackendError
+	}
+}
@@ -0,0 +1,186 @@
+package tcp
+
+/**
+ * certificates.go - reloading certificates without restarting listener
+ */
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/metrics"
+	tlsutil "github.com/yyyar/gobetween/utils/tls"
+	"github.com/yyyar/gobetween/utils/watcher"
+)
+
+/**
+ * Certificates expiring within this period are warned about
+ */
+const CERTIFICATE_EXPIRY_WARNING = 7 * 24 * time.Hour
+
+/**
+ * Returns details of certificates currently used by server
+ */
+func (this *Server) Certificates() []core.CertificateInfo {
+
+	result := []core.CertificateInfo{}
+
+	if this.certificates != nil {
+		result = append(result, this.certificates.Info()...)
+	}
+
+	if this.clientAuth != nil {
+		result = append(result, this.clientAuth.Info()...)
+	}
+
+	this.mu.RLock()
+	result = append(result, this.backendsCertificates...)
+	this.mu.RUnlock()
+
+	return result
+}
+
+/**
+ * Load certificates from files again. New connections use reloaded
+ * certificates, established ones are not affected. If any certificate
+ * fails to load, currently used ones are kept
+ */
+func (this *Server) ReloadCertificates() error {
+
+	log := logging.For("server")
+
+	cfg := this.Cfg()
+
+	if this.certificates != nil {
+		if err := this.certificates.Reload(); err != nil {
+			return err
+		}
+	}
+
+	if this.clientAuth != nil {
+		if err := this.clientAuth.Reload(); err != nil {
+			return err
+		}
+	}
+
+	backendsTlsConfig, backendsCertificates, err := tlsutil.MakeBackendTLSConfig(cfg.BackendsTls)
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	// keep config made by Reconfigure if backends tls was changed meanwhile
+	if reflect.DeepEqual(this.cfg.BackendsTls, cfg.BackendsTls) {
+		this.backendsTlsConfg = backendsTlsConfig
+		this.backendsCertificates = backendsCertificates
+	}
+	this.mu.Unlock()
+
+	log.Info("Reloaded certificates of '", this.name, "'")
+
+	this.reportCertificates()
+
+	return nil
+}
+
+/**
+ * Report certificates to metrics, warning about expiring ones
+ */
+func (this *Server) reportCertificates() {
+
+	log := logging.For("server")
+
+	certificates := this.Certificates()
+
+	for _, c := range certificates {
+		if left := time.Until(c.NotAfter); left <= 0 {
+			log.Warn("Certificate ", c.Path, " of '", this.name, "' expired at ", c.NotAfter)
+		} else if left < CERTIFICATE_EXPIRY_WARNING {
+			log.Warn("Certificate ", c.Path, " of '", this.name, "' expires at ", c.NotAfter)
+		}
+	}
+
+	metrics.ReportCertificates(this.name, certificates)
+}
+
+/**
+ * Start watching files certificates of cfg are loaded from,
+ * reloading them on change. Previous watch, if any, is stopped
+ */
+func (this *Server) watchCertificates(cfg config.Server) {
+
+	log := logging.For("server")
+
+	paths := []string{}
+
+	if this.certificates != nil {
+		paths = append(paths, this.certificates.Paths()...)
+	}
+
+	if this.clientAuth != nil {
+		paths = append(paths, this.clientAuth.Paths()...)
+	}
+
+	if b := cfg.BackendsTls; b != nil {
+		for _, path := range []*string{b.CertPath, b.KeyPath, b.RootCaCertPath} {
+			if path != nil {
+				paths = append(paths, *path)
+			}
+		}
+	}
+
+	stop := make(chan bool)
+
+	this.mu.Lock()
+	if this.certificatesWatch != nil {
+		close(this.certificatesWatch)
+		this.certificatesWatch = nil
+	}
+	select {
+	case <-this.stop:
+		this.mu.Unlock()
+		return
+	default:
+		this.certificatesWatch = stop
+	}
+	this.mu.Unlock()
+
+	if len(paths) == 0 {
+		return
+	}
+
+	changes, err := watcher.Watch(stop, paths...)
+	if err != nil {
+		log.Warn("Certificates of '", this.name, "' will be reloaded only on config reload: ", err)
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-changes:
+				if err := this.ReloadCertificates(); err != nil {
+					log.Error("Could not reload certificates of '", this.name, "', keeping current ones: ", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+/**
+ * Stop watching certificates files
+ */
+func (this *Server) unwatchCertificates() {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	if this.certificatesWatch != nil {
+		close(this.certificatesWatch)
+		this.certificatesWatch = nil
+	}
+}
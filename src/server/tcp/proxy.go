@@ -7,6 +7,7 @@ package tcp
  */
 
 import (
+	"errors"
 	"io"
 	"net"
 	"time"
@@ -24,11 +25,32 @@ const (
 	PROXY_STATS_PUSH_INTERVAL = 1 * time.Second
 )
 
+/**
+ * Why proxying from connection has ended: err is nil if 'from'
+ * was closed by peer, writeError if writing to 'to' failed
+ */
+type proxyEnd struct {
+	from net.Conn
+	err  error
+}
+
+/**
+ * Error writing to destination connection
+ */
+type writeError struct {
+	error
+}
+
+func (this writeError) Unwrap() error {
+	return this.error
+}
+
 /**
  * Perform copy/proxy data from 'from' to 'to' socket, counting r/w stats and
- * dropping connection if timeout exceeded
+ * dropping connection if timeout exceeded. When copying ends, its reason
+ * is sent to ended, that should be buffered not to block
  */
-func proxy(to net.Conn, from net.Conn, timeout time.Duration) <-chan core.ReadWriteCount {
+func proxy(to net.Conn, from net.Conn, timeout time.Duration, ended chan<- proxyEnd) <-chan core.ReadWriteCount {
 
 	log := logging.For("proxy")
 
@@ -84,12 +106,13 @@ func proxy(to net.Conn, from net.Conn, timeout time.Duration) <-chan core.ReadWr
 	// Run proxy copier
 	go func() {
 		err := Copy(to, from, stats)
-		// hack to determine normal close. TODO: fix when it will be exposed in golang
-		e, ok := err.(*net.OpError)
-		if err != nil && (!ok || e.Err.Error() != "use of closed network connection") {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Warn(err)
 		}
 
+		// reason is sent before closing, so that the first ended side is reported first
+		ended <- proxyEnd{from: from, err: err}
+
 		to.Close()
 		from.Close()
 
@@ -120,7 +143,7 @@ func Copy(to io.Writer, from io.Reader, ch chan<- core.ReadWriteCount) error {
 			}
 
 			if writeErr != nil {
-				err = writeErr
+				err = writeError{writeErr}
 				break
 			}
 
@@ -0,0 +1,156 @@
+package tcp
+
+/**
+ * routes.go - routing connections by sni to backend pools
+ */
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/yyyar/gobetween/balance"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/discovery"
+	"github.com/yyyar/gobetween/healthcheck"
+	"github.com/yyyar/gobetween/server/scheduler"
+	"github.com/yyyar/gobetween/stats"
+	"github.com/yyyar/gobetween/utils/tls/sni"
+)
+
+/**
+ * Named backend pool with its own scheduler and stats
+ */
+type pool struct {
+	scheduler    *scheduler.Scheduler
+	statsHandler *stats.Handler
+}
+
+/**
+ * Route of connections with matching sni to pool
+ */
+type route struct {
+	matcher *sni.Matcher
+	pool    string
+}
+
+/**
+ * Creates new pool, stats of it are available under statsName
+ */
+func newPool(statsName string, cfg config.Pool) *pool {
+
+	statsHandler := stats.NewHandler(statsName)
+
+	return &pool{
+		statsHandler: statsHandler,
+		scheduler: &scheduler.Scheduler{
+			Balancer:     balance.New(nil, cfg.Balance, cfg.Consistent),
+			Discovery:    discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
+			Healthcheck:  healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
+			Outlier:      scheduler.NewOutlierDetector(cfg.OutlierDetection),
+			StatsHandler: statsHandler,
+		},
+	}
+}
+
+/**
+ * Start pool scheduler and stats
+ */
+func (this *pool) start() {
+	this.statsHandler.Start()
+	this.scheduler.Start()
+}
+
+/**
+ * Stop pool scheduler and stats
+ */
+func (this *pool) stop() {
+	this.scheduler.Stop()
+	this.statsHandler.Stop()
+}
+
+/**
+ * Replace changed parts of running pool
+ */
+func (this *pool) reconfigure(old config.Pool, cfg config.Pool) {
+
+	var balancer core.Balancer
+	if old.Balance != cfg.Balance || !reflect.DeepEqual(old.Consistent, cfg.Consistent) {
+		balancer = balance.New(nil, cfg.Balance, cfg.Consistent)
+	}
+
+	var disc *discovery.Discovery
+	if !reflect.DeepEqual(old.Discovery, cfg.Discovery) {
+		disc = discovery.New(cfg.Discovery.Kind, *cfg.Discovery)
+	}
+
+	var check *healthcheck.Healthcheck
+	if !reflect.DeepEqual(old.Healthcheck, cfg.Healthcheck) {
+		check = healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck)
+	}
+
+	var outlier *scheduler.OutlierDetector
+	if !reflect.DeepEqual(old.OutlierDetection, cfg.OutlierDetection) {
+		outlier = scheduler.NewOutlierDetector(cfg.OutlierDetection)
+	}
+
+	this.scheduler.Reconfigure(balancer, disc, check, outlier)
+}
+
+/**
+ * Compile routes sni patterns
+ */
+func makeRoutes(routes []config.Route) ([]route, error) {
+
+	result := make([]route, 0, len(routes))
+
+	for _, r := range routes {
+		matcher, err := sni.NewMatcher(r.Sni)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, route{matcher, r.Pool})
+	}
+
+	return result, nil
+}
+
+/**
+ * Find scheduler for connection with hostname: pool of the first
+ * matching route, or server's own one if none matches.
+ * Should be called with mu held
+ */
+func (this *Server) routeScheduler(hostname string) *scheduler.Scheduler {
+
+	if hostname != "" {
+		for _, r := range this.routes {
+			if r.matcher.Match(hostname) {
+				if p, ok := this.pools[r.pool]; ok {
+					return p.scheduler
+				}
+			}
+		}
+	}
+
+	return &this.scheduler
+}
+
+/**
+ * Find scheduler of pool by name, empty name is server's own one
+ */
+func (this *Server) poolScheduler(name string) (*scheduler.Scheduler, error) {
+
+	if name == "" {
+		return &this.scheduler, nil
+	}
+
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	p, ok := this.pools[name]
+	if !ok {
+		return nil, errors.New("Pool not found: " + name)
+	}
+
+	return p.scheduler, nil
+}
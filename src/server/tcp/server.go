@@ -8,18 +8,26 @@ package tcp
 
 import (
 	"crypto/tls"
+	"errors"
 	"net"
+	"reflect"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/yyyar/gobetween/accesslog"
 	"github.com/yyyar/gobetween/balance"
 	"github.com/yyyar/gobetween/config"
 	"github.com/yyyar/gobetween/core"
 	"github.com/yyyar/gobetween/discovery"
+	"github.com/yyyar/gobetween/events"
 	"github.com/yyyar/gobetween/healthcheck"
 	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/metrics"
 	"github.com/yyyar/gobetween/server/modules/access"
 	"github.com/yyyar/gobetween/server/scheduler"
 	"github.com/yyyar/gobetween/stats"
+	"github.com/yyyar/gobetween/telemetry"
 	"github.com/yyyar/gobetween/utils"
 	"github.com/yyyar/gobetween/utils/proxyprotocol"
 	tlsutil "github.com/yyyar/gobetween/utils/tls"
@@ -41,12 +49,27 @@ type Server struct {
 	/* Configuration */
 	cfg config.Server
 
+	/* Guards cfg, access, backendsTlsConfg, pools and routes that may be changed by Reconfigure */
+	mu sync.RWMutex
+
 	/* Scheduler deals with discovery, balancing and healthchecks */
 	scheduler scheduler.Scheduler
 
+	/* Named backend pools for routes */
+	pools map[string]*pool
+
+	/* Routes of connections to pools by sni */
+	routes []route
+
 	/* Current clients connection */
 	clients map[string]net.Conn
 
+	/* Clients connections grouped by backend they are proxied to */
+	backendClients map[core.Target]map[net.Conn]bool
+
+	/* Guards backendClients */
+	backendClientsMu sync.Mutex
+
 	/* Stats handler */
 	statsHandler *stats.Handler
 
@@ -58,15 +81,39 @@ type Server struct {
 	/* Channel for dropping connections or connectons to drop */
 	disconnect chan (net.Conn)
 
-	/* Stop channel */
+	/* Stop channel, closed on stop */
 	stop chan bool
 
+	/* Ensures stop is closed once */
+	stopOnce sync.Once
+
+	/* Closed when server is completely stopped */
+	stopped chan bool
+
+	/* Channel for graceful stop request */
+	drain chan bool
+
+	/* Is server waiting for active connections to finish before stop */
+	draining bool
+
 	/* Tls config used to connect to backends */
 	backendsTlsConfg *tls.Config
 
 	/* Tls config used for incoming connections */
 	tlsConfig *tls.Config
 
+	/* Certificates for incoming connections, reloaded on change */
+	certificates *tlsutil.Certificates
+
+	/* Verifies client certificates, nil if they are not verified */
+	clientAuth *tlsutil.ClientAuth
+
+	/* Details of certificates used to connect to backends */
+	backendsCertificates []core.CertificateInfo
+
+	/* Closed to stop watching certificates files */
+	certificatesWatch chan bool
+
 	/* Get certificate filled by external service */
 	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
@@ -74,6 +121,9 @@ type Server struct {
 
 	/* Access module checks if client is allowed to connect */
 	access *access.Access
+
+	/* Reads proxy protocol headers sent by trusted peers */
+	acceptor *proxyprotocol.Acceptor
 }
 
 /**
@@ -91,14 +141,19 @@ func New(name string, cfg config.Server) (*Server, error) {
 		name:         name,
 		cfg:          cfg,
 		stop:         make(chan bool),
+		stopped:      make(chan bool),
+		drain:        make(chan bool),
 		disconnect:   make(chan net.Conn),
 		connect:      make(chan *core.TcpContext),
 		clients:      make(map[string]net.Conn),
 		statsHandler: statsHandler,
+
+		backendClients: make(map[core.Target]map[net.Conn]bool),
 		scheduler: scheduler.Scheduler{
-			Balancer:     balance.New(cfg.Sni, cfg.Balance),
+			Balancer:     balance.New(cfg.Sni, cfg.Balance, cfg.Consistent),
 			Discovery:    discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
 			Healthcheck:  healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
+			Outlier:      scheduler.NewOutlierDetector(cfg.OutlierDetection),
 			StatsHandler: statsHandler,
 		},
 	}
@@ -111,9 +166,40 @@ func New(name string, cfg config.Server) (*Server, error) {
 		}
 	}
 
+	/* Add routes to pools if needed */
+	server.routes, err = makeRoutes(cfg.Routes)
+	if err != nil {
+		return nil, err
+	}
+
+	server.pools = make(map[string]*pool, len(cfg.Pools))
+	for poolName, poolCfg := range cfg.Pools {
+		server.pools[poolName] = newPool(stats.PoolName(name, poolName), poolCfg)
+	}
+
+	/* Add proxy protocol acceptor if needed */
+	if cfg.AcceptProxyProtocol != nil {
+		server.acceptor, err = proxyprotocol.NewAcceptor(cfg.AcceptProxyProtocol)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	/* Add tls configs if needed */
 
-	server.backendsTlsConfg, err = tlsutil.MakeBackendTLSConfig(cfg.BackendsTls)
+	if cfg.Tls != nil {
+		server.certificates, err = tlsutil.LoadCertificates(cfg.Tls)
+		if err != nil {
+			return nil, err
+		}
+
+		server.clientAuth, err = tlsutil.NewClientAuth(cfg.Tls)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	server.backendsTlsConfg, server.backendsCertificates, err = tlsutil.MakeBackendTLSConfig(cfg.BackendsTls)
 	if err != nil {
 		return nil, err
 	}
@@ -127,16 +213,185 @@ func New(name string, cfg config.Server) (*Server, error) {
  * Returns current server configuration
  */
 func (this *Server) Cfg() config.Server {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
 	return this.cfg
 }
 
+/**
+ * Apply new configuration without restarting listener and dropping
+ * active connections. Bind, protocol and tls can't be changed this way
+ */
+func (this *Server) Reconfigure(cfg config.Server) error {
+
+	log := logging.For("server")
+
+	var err error
+	var accessModule *access.Access
+
+	if cfg.Access != nil {
+		accessModule, err = access.NewAccess(cfg.Access)
+		if err != nil {
+			return err
+		}
+	}
+
+	var acceptor *proxyprotocol.Acceptor
+
+	if cfg.AcceptProxyProtocol != nil {
+		acceptor, err = proxyprotocol.NewAcceptor(cfg.AcceptProxyProtocol)
+		if err != nil {
+			return err
+		}
+	}
+
+	backendsTlsConfig, backendsCertificates, err := tlsutil.MakeBackendTLSConfig(cfg.BackendsTls)
+	if err != nil {
+		return err
+	}
+
+	routes, err := makeRoutes(cfg.Routes)
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	old := this.cfg
+	this.cfg = cfg
+	this.access = accessModule
+	this.acceptor = acceptor
+	this.backendsTlsConfg = backendsTlsConfig
+	this.backendsCertificates = backendsCertificates
+	this.routes = routes
+
+	// pools are added and removed right away, changed ones are reconfigured after unlocking
+	changed := map[string]*pool{}
+	for poolName, p := range this.pools {
+		if poolCfg, ok := cfg.Pools[poolName]; !ok {
+			p.stop()
+			delete(this.pools, poolName)
+		} else if !reflect.DeepEqual(old.Pools[poolName], poolCfg) {
+			changed[poolName] = p
+		}
+	}
+	for poolName, poolCfg := range cfg.Pools {
+		if _, ok := this.pools[poolName]; !ok {
+			p := newPool(stats.PoolName(this.name, poolName), poolCfg)
+			p.start()
+			this.pools[poolName] = p
+		}
+	}
+	this.mu.Unlock()
+
+	for poolName, p := range changed {
+		p.reconfigure(old.Pools[poolName], cfg.Pools[poolName])
+	}
+
+	if !reflect.DeepEqual(old.BackendsTls, cfg.BackendsTls) {
+		this.watchCertificates(cfg)
+		this.reportCertificates()
+	}
+
+	var balancer core.Balancer
+	if old.Balance != cfg.Balance || !reflect.DeepEqual(old.Sni, cfg.Sni) || !reflect.DeepEqual(old.Consistent, cfg.Consistent) {
+		balancer = balance.New(cfg.Sni, cfg.Balance, cfg.Consistent)
+	}
+
+	var disc *discovery.Discovery
+	if !reflect.DeepEqual(old.Discovery, cfg.Discovery) {
+		disc = discovery.New(cfg.Discovery.Kind, *cfg.Discovery)
+	}
+
+	var check *healthcheck.Healthcheck
+	if !reflect.DeepEqual(old.Healthcheck, cfg.Healthcheck) {
+		check = healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck)
+	}
+
+	var outlier *scheduler.OutlierDetector
+	if !reflect.DeepEqual(old.OutlierDetection, cfg.OutlierDetection) {
+		outlier = scheduler.NewOutlierDetector(cfg.OutlierDetection)
+	}
+
+	this.scheduler.Reconfigure(balancer, disc, check, outlier)
+
+	log.Info("Reconfigured '", this.name, "': ", cfg.Bind, " ", cfg.Balance, " ", cfg.Discovery.Kind, " ", cfg.Healthcheck.Kind)
+
+	return nil
+}
+
+/**
+ * Returns current backends of pool with their stats
+ */
+func (this *Server) Backends(pool string) ([]core.Backend, error) {
+
+	sched, err := this.poolScheduler(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return sched.GetBackends(), nil
+}
+
+/**
+ * Override backend properties of pool, closing its connections if it gets disabled
+ */
+func (this *Server) OverrideBackend(pool string, target core.Target, override core.BackendOverride, merge bool) (*core.Backend, error) {
+
+	sched, err := this.poolScheduler(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := sched.OverrideBackend(target, override, merge)
+	if err != nil {
+		return nil, err
+	}
+
+	if backend.Override != nil && backend.Override.State == core.BackendDisabled {
+		this.backendClientsMu.Lock()
+		for conn := range this.backendClients[target] {
+			conn.Close()
+		}
+		this.backendClientsMu.Unlock()
+	}
+
+	return backend, nil
+}
+
+/**
+ * Remember client connection proxied to backend target
+ */
+func (this *Server) trackBackendClient(target core.Target, conn net.Conn) {
+	this.backendClientsMu.Lock()
+	defer this.backendClientsMu.Unlock()
+
+	if this.backendClients[target] == nil {
+		this.backendClients[target] = make(map[net.Conn]bool)
+	}
+	this.backendClients[target][conn] = true
+}
+
+/**
+ * Forget client connection proxied to backend target
+ */
+func (this *Server) untrackBackendClient(target core.Target, conn net.Conn) {
+	this.backendClientsMu.Lock()
+	defer this.backendClientsMu.Unlock()
+
+	delete(this.backendClients[target], conn)
+	if len(this.backendClients[target]) == 0 {
+		delete(this.backendClients, target)
+	}
+}
+
 /**
  * Start server
  */
 func (this *Server) Start() error {
 
 	var err error
-	this.tlsConfig, err = tlsutil.MakeTlsConfig(this.cfg.Tls, this.GetCertificate)
+	this.tlsConfig, err = tlsutil.MakeTlsConfig(this.cfg.Tls, this.certificates, this.clientAuth, this.GetCertificate)
 	if err != nil {
 		return err
 	}
@@ -147,20 +402,34 @@ func (this *Server) Start() error {
 			select {
 			case client := <-this.disconnect:
 				this.HandleClientDisconnect(client)
+				if this.draining && len(this.clients) == 0 {
+					this.Stop()
+				}
 
 			case ctx := <-this.connect:
 				this.HandleClientConnect(ctx)
 
+			case <-this.drain:
+				this.draining = true
+				if len(this.clients) == 0 {
+					this.Stop()
+				}
+
 			case <-this.stop:
+				this.unwatchCertificates()
+				metrics.RemoveCertificates(this.name)
 				this.scheduler.Stop()
 				this.statsHandler.Stop()
-				if this.listener != nil {
-					this.listener.Close()
-					for _, conn := range this.clients {
-						conn.Close()
-					}
+				this.mu.Lock()
+				for _, p := range this.pools {
+					p.stop()
+				}
+				this.mu.Unlock()
+				for _, conn := range this.clients {
+					conn.Close()
 				}
 				this.clients = make(map[string]net.Conn)
+				close(this.stopped)
 				return
 			}
 		}
@@ -172,12 +441,23 @@ func (this *Server) Start() error {
 	// Start scheduler
 	this.scheduler.Start()
 
+	// Start pools
+	this.mu.RLock()
+	for _, p := range this.pools {
+		p.start()
+	}
+	this.mu.RUnlock()
+
 	// Start listening
 	if err := this.Listen(); err != nil {
 		this.Stop()
 		return err
 	}
 
+	// Reload certificates when their files change
+	this.watchCertificates(this.Cfg())
+	this.reportCertificates()
+
 	return nil
 }
 
@@ -196,10 +476,20 @@ func (this *Server) HandleClientDisconnect(client net.Conn) {
 func (this *Server) HandleClientConnect(ctx *core.TcpContext) {
 	client := ctx.Conn
 	log := logging.For("server")
+	cfg := this.Cfg()
 
-	if *this.cfg.MaxConnections != 0 && len(this.clients) >= *this.cfg.MaxConnections {
-		log.Warn("Too many connections to ", this.cfg.Bind)
+	if this.draining {
+		log.Debug("Server ", this.name, " is draining, rejecting ", client.RemoteAddr())
 		client.Close()
+		this.logRejected(ctx, cfg, accesslog.TerminationServerClosed)
+		return
+	}
+
+	if *cfg.MaxConnections != 0 && len(this.clients) >= *cfg.MaxConnections {
+		log.Warn("Too many connections to ", cfg.Bind)
+		client.Close()
+		this.logRejected(ctx, cfg, accesslog.TerminationMaxConnections)
+		events.PublishServer(events.MaxConnections, this.name, client.RemoteAddr().String())
 		return
 	}
 
@@ -207,7 +497,10 @@ func (this *Server) HandleClientConnect(ctx *core.TcpContext) {
 	this.statsHandler.Connections <- uint(len(this.clients))
 	go func() {
 		this.handle(ctx)
-		this.disconnect <- client
+		select {
+		case this.disconnect <- client:
+		case <-this.stop:
+		}
 	}()
 }
 
@@ -219,22 +512,103 @@ func (this *Server) Stop() {
 	log := logging.For("server.Listen")
 	log.Info("Stopping ", this.name)
 
-	this.stop <- true
+	// Close listener right away so that bind address
+	// is released by the time Stop returns
+	if this.listener != nil {
+		this.listener.Close()
+	}
+
+	this.stopOnce.Do(func() {
+		close(this.stop)
+	})
+}
+
+/**
+ * Stop accepting connections and wait for active ones to finish,
+ * dropping connections left after drain_timeout
+ */
+func (this *Server) StopGraceful() <-chan bool {
+
+	log := logging.For("server")
+	log.Info("Stopping ", this.name, " gracefully")
+
+	if this.listener != nil {
+		this.listener.Close()
+	}
+
+	select {
+	case this.drain <- true:
+	case <-this.stop:
+		return this.stopped
+	}
+
+	// zero timeout waits for connections to finish however long they last
+	timeout := utils.ParseDurationOrDefault(*this.Cfg().DrainTimeout, 0)
+	if timeout == 0 {
+		return this.stopped
+	}
+
+	go func() {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			log.Warn("Drain timeout for ", this.name, " exceeded, dropping active connections")
+			this.Stop()
+		case <-this.stopped:
+		}
+	}()
+
+	return this.stopped
 }
 
-func (this *Server) wrap(conn net.Conn, sniEnabled bool) {
+func (this *Server) wrap(conn net.Conn) {
 	log := logging.For("server.Listen.wrap")
 
 	var hostname string
 	var err error
 
-	if sniEnabled {
+	accepted := time.Now()
+	metrics.ReportConnectionAccepted(this.name)
+
+	trace := telemetry.StartConnection(this.name, conn.RemoteAddr().String(), accepted)
+
+	this.mu.RLock()
+	cfg, acceptor := this.cfg, this.acceptor
+	this.mu.RUnlock()
+
+	/* Read proxy protocol header first, so that client address is known from now on */
+	if acceptor != nil {
+		proxyConn, err := acceptor.Accept(conn)
+		telemetry.Phase(trace, telemetry.PhaseAccept, accepted, "", err)
+		if err != nil {
+			log.Error("Failed to read proxy_protocol header from ", conn.RemoteAddr(), ": ", err)
+			conn.Close()
+			this.logRejected(&core.TcpContext{Conn: conn, Accepted: accepted, Trace: trace}, cfg, accesslog.TerminationProxyProtocolError)
+			return
+		}
+
+		conn = proxyConn
+	}
+
+	/* Hostname is needed for sni balancing and for routing to pools */
+	if cfg.Sni != nil || len(cfg.Routes) > 0 {
+		readTimeout := time.Second * 2
+		if cfg.Sni != nil {
+			readTimeout = utils.ParseDurationOrDefault(cfg.Sni.ReadTimeout, readTimeout)
+		}
+
+		sniffStart := time.Now()
+
 		var sniConn net.Conn
-		sniConn, hostname, err = sni.Sniff(conn, utils.ParseDurationOrDefault(this.cfg.Sni.ReadTimeout, time.Second*2))
+		sniConn, hostname, err = sni.Sniff(conn, readTimeout)
+		telemetry.Phase(trace, telemetry.PhaseSni, sniffStart, "", err)
 
 		if err != nil {
 			log.Error("Failed to get / parse ClientHello for sni: ", err)
 			conn.Close()
+			this.logRejected(&core.TcpContext{Conn: conn, Accepted: accepted, Trace: trace}, cfg, accesslog.TerminationSniError)
 			return
 		}
 
@@ -245,9 +619,16 @@ func (this *Server) wrap(conn net.Conn, sniEnabled bool) {
 		conn = tls.Server(conn, this.tlsConfig)
 	}
 
-	this.connect <- &core.TcpContext{
-		hostname,
-		conn,
+	select {
+	case this.connect <- &core.TcpContext{
+		Hostname: hostname,
+		Conn:     conn,
+		Accepted: accepted,
+		Trace:    trace,
+	}:
+	case <-this.stop:
+		conn.Close()
+		telemetry.EndConnection(trace, accesslog.Record{Time: accepted, Termination: accesslog.TerminationServerClosed})
 	}
 
 }
@@ -267,8 +648,6 @@ func (this *Server) Listen() (err error) {
 		return err
 	}
 
-	sniEnabled := this.cfg.Sni != nil
-
 	go func() {
 		for {
 			conn, err := this.listener.Accept()
@@ -278,71 +657,199 @@ func (this *Server) Listen() (err error) {
 				return
 			}
 
-			go this.wrap(conn, sniEnabled)
+			go this.wrap(conn)
 		}
 	}()
 
 	return nil
 }
 
+/**
+ * Elect backend and connect to it. If connection fails, elect another
+ * backend excluding failed ones, until backend_connection_retries
+ * or backend_connection_deadline is reached
+ */
+func (this *Server) connectBackend(ctx *core.TcpContext, sched *scheduler.Scheduler, cfg config.Server, backendsTlsConfig *tls.Config) (*core.Backend, net.Conn, error) {
+
+	log := logging.For("server.connect [" + cfg.Bind + "]")
+	server := sched.StatsHandler.Name
+
+	timeout := utils.ParseDurationOrDefault(*cfg.BackendConnectionTimeout, 0)
+
+	var deadline time.Time
+	if d := utils.ParseDurationOrDefault(*cfg.BackendConnectionDeadline, 0); d > 0 {
+		deadline = time.Now().Add(d)
+	}
+
+	for attempt := 0; ; attempt++ {
+
+		electStart := time.Now()
+		backend, err := sched.TakeBackend(ctx)
+		if err != nil {
+			telemetry.Phase(ctx.Trace, telemetry.PhaseElect, electStart, "", err)
+			return nil, nil, err
+		}
+		telemetry.Phase(ctx.Trace, telemetry.PhaseElect, electStart, backend.Address(), nil)
+
+		dialer := &net.Dialer{
+			Timeout:  timeout,
+			Deadline: deadline,
+		}
+
+		dialStart := time.Now()
+
+		var backendConn net.Conn
+		if cfg.BackendsTls != nil {
+			backendConn, err = tls.DialWithDialer(dialer, "tcp", backend.Address(), backendsTlsConfig)
+		} else {
+			backendConn, err = dialer.Dial("tcp", backend.Address())
+		}
+
+		telemetry.Phase(ctx.Trace, telemetry.PhaseDial, dialStart, backend.Address(), err)
+
+		if err == nil {
+			metrics.ReportBackendConnect(server, backend.Target, time.Since(dialStart))
+			return backend, backendConn, nil
+		}
+
+		sched.IncrementRefused(*backend)
+		metrics.ReportBackendDialFailure(server, backend.Target, dialFailureReason(err))
+
+		if attempt >= *cfg.BackendConnectionRetries || !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, nil, err
+		}
+
+		log.Warn(err, "; Retrying with another backend for ", ctx.Conn.RemoteAddr())
+
+		if ctx.Exclude == nil {
+			ctx.Exclude = make(map[core.Target]bool)
+		}
+		ctx.Exclude[backend.Target] = true
+	}
+}
+
+/**
+ * Reason of failed backend dial, used as metrics label
+ */
+func dialFailureReason(err error) string {
+
+	var netErr net.Error
+	var opErr *net.OpError
+
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return "error"
+	default:
+		// connection was established, but tls handshake failed
+		return "tls"
+	}
+}
+
 /**
  * Handle incoming connection and prox it to backend
  */
 func (this *Server) handle(ctx *core.TcpContext) {
 	clientConn := ctx.Conn
-	log := logging.For("server.handle [" + this.cfg.Bind + "]")
 
-	/* Check access if needed */
-	if this.access != nil {
-		if !this.access.Allows(&clientConn.RemoteAddr().(*net.TCPAddr).IP) {
-			log.Debug("Client disallowed to connect ", clientConn.RemoteAddr())
+	this.mu.RLock()
+	cfg, accessModule, backendsTlsConfig := this.cfg, this.access, this.backendsTlsConfg
+	sched := this.routeScheduler(ctx.Hostname)
+	this.mu.RUnlock()
+
+	log := logging.For("server.handle [" + cfg.Bind + "]")
+
+	record := this.newRecord(ctx, cfg)
+	defer this.logRecord(ctx, &record)
+
+	deny := func() {
+		log.Debug("Client disallowed to connect ", clientConn.RemoteAddr())
+		record.Termination = accesslog.TerminationAccessDenied
+		metrics.ReportConnectionRejected(this.name, record.Termination)
+		clientConn.Close()
+	}
+
+	/* Check ip access rules before reading anything from client */
+	if accessModule != nil && !accessModule.MayAllow(&clientConn.RemoteAddr().(*net.TCPAddr).IP) {
+		deny()
+		return
+	}
+
+	/* Complete tls handshake first if client identity is needed for access and balancing,
+	   or if its details are sent to backend in proxy_protocol v2 */
+	if tlsConn, ok := clientConn.(*tls.Conn); ok && (this.clientAuth != nil || cfg.ProxyProtocol != nil && cfg.ProxyProtocol.Version == "2") {
+		if timeout := utils.ParseDurationOrDefault(*cfg.ClientIdleTimeout, 0); timeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
+		handshakeStart := time.Now()
+		err := tlsConn.Handshake()
+		telemetry.Phase(ctx.Trace, telemetry.PhaseTlsHandshake, handshakeStart, "", err)
+		if err != nil {
+			log.Debug("Tls handshake with ", clientConn.RemoteAddr(), " failed: ", err)
+			record.Termination = accesslog.TerminationTlsError
+			metrics.ReportConnectionRejected(this.name, record.Termination)
 			clientConn.Close()
 			return
 		}
+		tlsConn.SetDeadline(time.Time{})
+
+		if state := tlsConn.ConnectionState(); len(state.VerifiedChains) > 0 {
+			ctx.Identity = tlsutil.ClientIdentity(state.VerifiedChains[0][0])
+		}
+	}
+
+	/* Check client rules once identity is known */
+	if accessModule != nil {
+		if !accessModule.Allows(&clientConn.RemoteAddr().(*net.TCPAddr).IP, ctx.Identity) {
+			deny()
+			return
+		}
 	}
 
 	log.Debug("Accepted ", clientConn.RemoteAddr(), " -> ", this.listener.Addr())
 
-	/* Find out backend for proxying */
-	var err error
-	backend, err := this.scheduler.TakeBackend(ctx)
+	/* Find out backend for proxying and connect to it */
+	connectStart := time.Now()
+	backend, backendConn, err := this.connectBackend(ctx, sched, cfg, backendsTlsConfig)
+	record.ConnectTime = time.Since(connectStart)
 	if err != nil {
 		log.Error(err, "; Closing connection: ", clientConn.RemoteAddr())
+		record.Termination = accesslog.TerminationNoBackend
 		return
 	}
+	record.Backend = backend.Address()
 
-	/* Connect to backend */
-	var backendConn net.Conn
-
-	if this.cfg.BackendsTls != nil {
-		backendConn, err = tls.DialWithDialer(&net.Dialer{
-			Timeout: utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0),
-		}, "tcp", backend.Address(), this.backendsTlsConfg)
-
-	} else {
-		backendConn, err = net.DialTimeout("tcp", backend.Address(), utils.ParseDurationOrDefault(*this.cfg.BackendConnectionTimeout, 0))
-	}
+	sched.IncrementConnection(*backend)
+	defer sched.DecrementConnection(*backend)
 
-	if err != nil {
-		this.scheduler.IncrementRefused(*backend)
-		log.Error(err)
-		return
-	}
-	this.scheduler.IncrementConnection(*backend)
-	defer this.scheduler.DecrementConnection(*backend)
+	this.trackBackendClient(backend.Target, clientConn)
+	defer this.untrackBackendClient(backend.Target, clientConn)
 
 	/* Send proxy protocol header if configured */
-	if this.cfg.ProxyProtocol != nil {
-		switch this.cfg.ProxyProtocol.Version {
+	if cfg.ProxyProtocol != nil {
+		switch cfg.ProxyProtocol.Version {
 		case "1":
 			log.Debug("Sending proxy_protocol v1 header ", clientConn.RemoteAddr(), " -> ", this.listener.Addr(), " -> ", backendConn.RemoteAddr())
 			err := proxyprotocol.SendProxyProtocolV1(clientConn, backendConn)
 			if err != nil {
 				log.Error(err)
+				record.Termination = accesslog.TerminationProxyProtocolError
+				return
+			}
+		case "2":
+			log.Debug("Sending proxy_protocol v2 header ", clientConn.RemoteAddr(), " -> ", this.listener.Addr(), " -> ", backendConn.RemoteAddr())
+			err := proxyprotocol.SendProxyProtocolV2(clientConn, backendConn, ctx.Hostname)
+			if err != nil {
+				log.Error(err)
+				record.Termination = accesslog.TerminationProxyProtocolError
 				return
 			}
 		default:
-			log.Error("Unsupported proxy_protocol version " + this.cfg.ProxyProtocol.Version + ", aborting connection")
+			log.Error("Unsupported proxy_protocol version " + cfg.ProxyProtocol.Version + ", aborting connection")
+			record.Termination = accesslog.TerminationProxyProtocolError
 			return
 		}
 	}
@@ -350,8 +857,10 @@ func (this *Server) handle(ctx *core.TcpContext) {
 	/* ----- Stat proxying ----- */
 
 	log.Debug("Begin ", clientConn.RemoteAddr(), " -> ", this.listener.Addr(), " -> ", backendConn.RemoteAddr())
-	cs := proxy(clientConn, backendConn, utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0))
-	bs := proxy(backendConn, clientConn, utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0))
+	proxyStart := time.Now()
+	ended := make(chan proxyEnd, 2)
+	cs := proxy(clientConn, backendConn, utils.ParseDurationOrDefault(*cfg.BackendIdleTimeout, 0), ended)
+	bs := proxy(backendConn, clientConn, utils.ParseDurationOrDefault(*cfg.ClientIdleTimeout, 0), ended)
 
 	isTx, isRx := true, true
 	for isTx || isRx {
@@ -362,16 +871,24 @@ func (this *Server) handle(ctx *core.TcpContext) {
 				cs = nil
 				continue
 			}
-			this.scheduler.IncrementRx(*backend, s.CountWrite)
+			sched.IncrementRx(*backend, s.CountWrite)
+			record.TxBytes += uint64(s.CountWrite)
 		case s, ok := <-bs:
 			isTx = ok
 			if !ok {
 				bs = nil
 				continue
 			}
-			this.scheduler.IncrementTx(*backend, s.CountWrite)
+			sched.IncrementTx(*backend, s.CountWrite)
+			record.RxBytes += uint64(s.CountWrite)
 		}
 	}
 
+	record.Termination = terminationReason(<-ended, clientConn)
+
+	telemetry.Phase(ctx.Trace, telemetry.PhaseProxy, proxyStart, record.Backend, nil)
+
+	metrics.ReportConnectionEnd(sched.StatsHandler.Name, backend.Target, time.Since(record.Time), record.RxBytes, record.TxBytes)
+
 	log.Debug("End ", clientConn.RemoteAddr(), " -> ", this.listener.Addr(), " -> ", backendConn.RemoteAddr())
 }
@@ -0,0 +1,53 @@
+package udp
+
+/**
+ * pktinfo.go - destination addresses of packets received on unspecified address
+ */
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+/**
+ * Size of buffer for packet info control messages of both families
+ */
+var packetInfoSize = max(len(ipv4.NewControlMessage(ipv4.FlagDst)), len(ipv6.NewControlMessage(ipv6.FlagDst)))
+
+/**
+ * Enable receiving destination address with every packet.
+ * Returns false if it's not supported by platform
+ */
+func enablePacketInfo(conn *net.UDPConn) bool {
+
+	// dual stack socket gets ipv6 packet info for ipv4 clients too
+	if err := ipv6.NewPacketConn(conn).SetControlMessage(ipv6.FlagDst, true); err == nil {
+		return true
+	}
+
+	return ipv4.NewPacketConn(conn).SetControlMessage(ipv4.FlagDst, true) == nil
+}
+
+/**
+ * Get destination address from packet info control messages, nil if there is none
+ */
+func packetDestination(oob []byte) net.IP {
+
+	if len(oob) == 0 {
+		return nil
+	}
+
+	cm4 := &ipv4.ControlMessage{}
+	if err := cm4.Parse(oob); err == nil && cm4.Dst != nil {
+		return cm4.Dst
+	}
+
+	cm6 := &ipv6.ControlMessage{}
+	if err := cm6.Parse(oob); err == nil && cm6.Dst != nil {
+		return cm6.Dst
+	}
+
+	return nil
+}
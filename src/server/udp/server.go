@@ -7,24 +7,29 @@ package udp
  */
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/eric-lindau/udpfacade"
+	"github.com/yyyar/gobetween/accesslog"
 	"github.com/yyyar/gobetween/balance"
 	"github.com/yyyar/gobetween/config"
 	"github.com/yyyar/gobetween/core"
 	"github.com/yyyar/gobetween/discovery"
 	"github.com/yyyar/gobetween/healthcheck"
 	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/metrics"
 	"github.com/yyyar/gobetween/server/modules/access"
 	"github.com/yyyar/gobetween/server/scheduler"
 	"github.com/yyyar/gobetween/server/udp/session"
 	"github.com/yyyar/gobetween/stats"
 	"github.com/yyyar/gobetween/utils"
+	"github.com/yyyar/gobetween/utils/proxyprotocol"
 )
 
 const UDP_PACKET_SIZE = 65507
@@ -42,18 +47,36 @@ type Server struct {
 	/* Server configuration */
 	cfg config.Server
 
+	/* Configuration for new sessions */
+	sessionCfg session.Config
+
+	/* Guards cfg, sessionCfg and access that may be changed by Reconfigure */
+	cfgMu sync.RWMutex
+
 	/* Scheduler */
 	scheduler *scheduler.Scheduler
 
 	/* Server connection */
 	serverConn *net.UDPConn
 
+	/* True if destination address is received with every packet, as server listens on unspecified address */
+	packetInfo bool
+
 	/* Flag indicating that server is stopped */
 	stopped uint32
 
-	/* Stop channel */
+	/* Flag indicating that server doesn't accept new sessions and waits for active ones */
+	draining uint32
+
+	/* Stop channel, closed on stop */
 	stop chan bool
 
+	/* Ensures server is stopped once */
+	stopOnce sync.Once
+
+	/* Closed when server is completely stopped */
+	done chan bool
+
 	/* ----- modules ----- */
 
 	/* Access module checks if client is allowed to connect */
@@ -120,18 +143,20 @@ func New(name string, cfg config.Server) (*Server, error) {
 
 	statsHandler := stats.NewHandler(name)
 	scheduler := &scheduler.Scheduler{
-		Balancer:     balance.New(nil, cfg.Balance),
+		Balancer:     balance.New(nil, cfg.Balance, cfg.Consistent),
 		Discovery:    discovery.New(cfg.Discovery.Kind, *cfg.Discovery),
 		Healthcheck:  healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck),
 		StatsHandler: statsHandler,
 	}
 
 	server := &Server{
-		name:      name,
-		cfg:       cfg,
-		scheduler: scheduler,
-		stop:      make(chan bool),
-		sessions:  make(map[string]*session.Session),
+		name:       name,
+		cfg:        cfg,
+		sessionCfg: sessionConfig(name, cfg),
+		scheduler:  scheduler,
+		stop:       make(chan bool),
+		done:       make(chan bool),
+		sessions:   make(map[string]*session.Session),
 	}
 
 	/* Add access if needed */
@@ -151,9 +176,125 @@ func New(name string, cfg config.Server) (*Server, error) {
  * Returns current server configuration
  */
 func (this *Server) Cfg() config.Server {
+	this.cfgMu.RLock()
+	defer this.cfgMu.RUnlock()
+
 	return this.cfg
 }
 
+/**
+ * Apply new configuration without closing server socket and
+ * dropping active sessions. Bind and udp section can't be changed this way
+ */
+func (this *Server) Reconfigure(cfg config.Server) error {
+
+	var accessModule *access.Access
+
+	if cfg.Access != nil {
+		var err error
+		accessModule, err = access.NewAccess(cfg.Access)
+		if err != nil {
+			return fmt.Errorf("Could not initialize access restrictions: %v", err)
+		}
+	}
+
+	this.cfgMu.Lock()
+	old := this.cfg
+	this.cfg = cfg
+	this.sessionCfg = sessionConfig(this.name, cfg)
+	this.access = accessModule
+	this.cfgMu.Unlock()
+
+	var balancer core.Balancer
+	if old.Balance != cfg.Balance || !reflect.DeepEqual(old.Consistent, cfg.Consistent) {
+		balancer = balance.New(nil, cfg.Balance, cfg.Consistent)
+	}
+
+	var disc *discovery.Discovery
+	if !reflect.DeepEqual(old.Discovery, cfg.Discovery) {
+		disc = discovery.New(cfg.Discovery.Kind, *cfg.Discovery)
+	}
+
+	var check *healthcheck.Healthcheck
+	if !reflect.DeepEqual(old.Healthcheck, cfg.Healthcheck) {
+		check = healthcheck.New(cfg.Healthcheck.Kind, *cfg.Healthcheck)
+	}
+
+	this.scheduler.Reconfigure(balancer, disc, check, nil)
+
+	log.Info("Reconfigured UDP server '", this.name, "': ", cfg.Bind, " ", cfg.Balance, " ", cfg.Discovery.Kind, " ", cfg.Healthcheck.Kind)
+
+	return nil
+}
+
+/**
+ * Returns current backends with their stats
+ */
+func (this *Server) Backends(pool string) ([]core.Backend, error) {
+
+	if pool != "" {
+		return nil, errors.New("Pool not found: " + pool)
+	}
+
+	return this.scheduler.GetBackends(), nil
+}
+
+/**
+ * Udp server uses no certificates
+ */
+func (this *Server) Certificates() []core.CertificateInfo {
+	return []core.CertificateInfo{}
+}
+
+/**
+ * Udp server uses no certificates
+ */
+func (this *Server) ReloadCertificates() error {
+	return nil
+}
+
+/**
+ * Override backend properties, closing its sessions if it gets disabled
+ */
+func (this *Server) OverrideBackend(pool string, target core.Target, override core.BackendOverride, merge bool) (*core.Backend, error) {
+
+	if pool != "" {
+		return nil, errors.New("Pool not found: " + pool)
+	}
+
+	backend, err := this.scheduler.OverrideBackend(target, override, merge)
+	if err != nil {
+		return nil, err
+	}
+
+	if backend.Override != nil && backend.Override.State == core.BackendDisabled {
+		this.mu.Lock()
+		for _, s := range this.sessions {
+			if s.Backend().Target == target {
+				s.Close()
+			}
+		}
+		this.mu.Unlock()
+	}
+
+	return backend, nil
+}
+
+/**
+ * Make sessions config from server config
+ */
+func sessionConfig(name string, cfg config.Server) session.Config {
+	return session.Config{
+		ServerName:         name,
+		MaxRequests:        cfg.Udp.MaxRequests,
+		MaxResponses:       cfg.Udp.MaxResponses,
+		ClientIdleTimeout:  utils.ParseDurationOrDefault(*cfg.ClientIdleTimeout, 0),
+		BackendIdleTimeout: utils.ParseDurationOrDefault(*cfg.BackendIdleTimeout, 0),
+		Transparent:        cfg.Udp.Transparent,
+		ProxyProtocol:      cfg.ProxyProtocol != nil,
+	}
+}
+
 /**
  * Starts server
  */
@@ -175,18 +316,16 @@ func (this *Server) Start() error {
 		for {
 			select {
 			case <-ticker.C:
-				this.cleanup()
+				if this.cleanup() == 0 && atomic.LoadUint32(&this.draining) == 1 {
+					this.Stop()
+				}
 				/* handle server stop */
 			case <-this.stop:
-				log.Info("Stopping ", this.name)
-				atomic.StoreUint32(&this.stopped, 1)
-
 				ticker.Stop()
 
-				this.serverConn.Close()
-
-				this.scheduler.StatsHandler.Stop()
+				// scheduler may still report to stats handler until it's stopped
 				this.scheduler.Stop()
+				this.scheduler.StatsHandler.Stop()
 
 				this.mu.Lock()
 				for k, s := range this.sessions {
@@ -195,6 +334,7 @@ func (this *Server) Start() error {
 				}
 				this.mu.Unlock()
 
+				close(this.done)
 				return
 			}
 		}
@@ -218,6 +358,14 @@ func (this *Server) listen() error {
 		return fmt.Errorf("Failed to create listening udp socket: %v", err)
 	}
 
+	// local address doesn't tell which one of host addresses client sent packet to
+	if listenAddr.IP == nil || listenAddr.IP.IsUnspecified() {
+		this.packetInfo = enablePacketInfo(this.serverConn)
+		if !this.packetInfo {
+			log.Warn("Destination addresses of packets are unavailable, proxy_protocol will have unspecified destination")
+		}
+	}
+
 	return nil
 }
 
@@ -226,16 +374,11 @@ func (this *Server) listen() error {
  */
 func (this *Server) serve() {
 
-	cfg := session.Config{
-		MaxRequests:        this.cfg.Udp.MaxRequests,
-		MaxResponses:       this.cfg.Udp.MaxResponses,
-		ClientIdleTimeout:  utils.ParseDurationOrDefault(*this.cfg.ClientIdleTimeout, 0),
-		BackendIdleTimeout: utils.ParseDurationOrDefault(*this.cfg.BackendIdleTimeout, 0),
-		Transparent:        this.cfg.Udp.Transparent,
-	}
+	// udp section can't be reconfigured, so single request mode never changes
+	singleRequest := this.cfg.Udp.MaxRequests == 1
 
 	var cp *connPool
-	if cfg.MaxRequests == 1 {
+	if singleRequest {
 		cp = newConnPool()
 	}
 
@@ -245,8 +388,14 @@ func (this *Server) serve() {
 		defer cp.close()
 
 		buf := make([]byte, UDP_PACKET_SIZE)
+
+		var oob []byte
+		if this.packetInfo {
+			oob = make([]byte, packetInfoSize)
+		}
+
 		for {
-			n, clientAddr, err := this.serverConn.ReadFromUDP(buf)
+			n, oobn, _, clientAddr, err := this.serverConn.ReadMsgUDP(buf, oob)
 
 			if err != nil {
 				if atomic.LoadUint32(&this.stopped) == 1 {
@@ -258,16 +407,47 @@ func (this *Server) serve() {
 				continue
 			}
 
-			if this.access != nil {
-				if !this.access.Allows(&clientAddr.IP) {
+			this.cfgMu.RLock()
+			cfg, accessModule := this.sessionCfg, this.access
+			this.cfgMu.RUnlock()
+
+			if accessModule != nil {
+				if !accessModule.Allows(&clientAddr.IP, nil) {
 					log.Debug("Client disallowed to connect: ", clientAddr.IP)
+					metrics.ReportConnectionRejected(this.name, accesslog.TerminationAccessDenied)
+					continue
+				}
+			}
+
+			// while draining only existing sessions are served
+			if atomic.LoadUint32(&this.draining) == 1 && (singleRequest || !this.hasSession(clientAddr)) {
+				continue
+			}
+
+			data := buf[:n]
+
+			// prefix every datagram with proxy protocol v2 header
+			if cfg.ProxyProtocol {
+				localAddr := this.serverConn.LocalAddr().(*net.UDPAddr)
+				if dst := packetDestination(oob[:oobn]); dst != nil {
+					localAddr = &net.UDPAddr{IP: dst, Port: localAddr.Port}
+				}
+
+				header, err := proxyprotocol.MakeProxyProtocolV2Header(clientAddr, localAddr)
+				if err != nil {
+					log.Error("Could not make proxy_protocol header: ", err)
+					continue
+				}
+				if len(header)+n > UDP_PACKET_SIZE {
+					log.Errorf("Dropping %d bytes packet from %v, too large to add proxy_protocol header", n, clientAddr)
 					continue
 				}
+				data = append(header, data...)
 			}
 
 			//special case for single request mode
-			if cfg.MaxRequests == 1 {
-				err := this.fireAndForget(cp, clientAddr, buf[:n])
+			if singleRequest {
+				err := this.fireAndForget(cp, clientAddr, data)
 
 				if err != nil {
 					log.Errorf("Error sending data to backend: %v ", err)
@@ -276,16 +456,17 @@ func (this *Server) serve() {
 				continue
 			}
 
-			this.proxy(cfg, clientAddr, buf[:n])
+			this.proxy(cfg, clientAddr, data)
 
 		}
 	}()
 }
 
 /**
- * Safely remove connections that have marked themself as done
+ * Safely remove connections that have marked themself as done.
+ * Returns number of sessions left
  */
-func (this *Server) cleanup() {
+func (this *Server) cleanup() int {
 	this.mu.Lock()
 	defer this.mu.Unlock()
 
@@ -297,6 +478,19 @@ func (this *Server) cleanup() {
 	}
 
 	this.scheduler.StatsHandler.Connections <- uint(len(this.sessions))
+
+	return len(this.sessions)
+}
+
+/**
+ * Check if client has active session
+ */
+func (this *Server) hasSession(clientAddr *net.UDPAddr) bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	s, ok := this.sessions[clientAddr.String()]
+	return ok && !s.IsDone()
 }
 
 /**
@@ -370,6 +564,7 @@ func (this *Server) getOrCreateSession(cfg session.Config, clientAddr *net.UDPAd
 	}
 
 	s = session.NewSession(clientAddr, conn, *backend, this.scheduler, cfg)
+	metrics.ReportConnectionAccepted(this.name)
 	if !cfg.Transparent {
 		s.ListenResponses(this.serverConn)
 	}
@@ -429,5 +624,46 @@ func (this *Server) fireAndForget(pool *connPool, clientAddr *net.UDPAddr, buf [
  * Stop, dropping all connections
  */
 func (this *Server) Stop() {
-	this.stop <- true
+	log.Info("Stopping ", this.name)
+
+	// Close socket right away so that bind address
+	// is released by the time Stop returns
+	atomic.StoreUint32(&this.stopped, 1)
+	this.serverConn.Close()
+
+	this.stopOnce.Do(func() {
+		close(this.stop)
+	})
+}
+
+/**
+ * Stop creating new sessions and wait for active ones to finish,
+ * dropping sessions left after drain_timeout.
+ * Server socket is kept open until then to deliver responses
+ */
+func (this *Server) StopGraceful() <-chan bool {
+
+	log.Info("Stopping ", this.name, " gracefully")
+
+	atomic.StoreUint32(&this.draining, 1)
+
+	// zero timeout waits for connections to finish however long they last
+	timeout := utils.ParseDurationOrDefault(*this.Cfg().DrainTimeout, 0)
+	if timeout == 0 {
+		return this.done
+	}
+
+	go func() {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+			log.Warn("Drain timeout for ", this.name, " exceeded, dropping active sessions")
+			this.Stop()
+		case <-this.done:
+		}
+	}()
+
+	return this.done
 }
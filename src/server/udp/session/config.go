@@ -3,9 +3,11 @@ package session
 import "time"
 
 type Config struct {
+	ServerName         string
 	MaxRequests        uint64
 	MaxResponses       uint64
 	ClientIdleTimeout  time.Duration
 	BackendIdleTimeout time.Duration
 	Transparent        bool
+	ProxyProtocol      bool
 }
@@ -7,8 +7,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/yyyar/gobetween/accesslog"
 	"github.com/yyyar/gobetween/core"
 	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/metrics"
 	"github.com/yyyar/gobetween/server/scheduler"
 )
 
@@ -51,6 +53,15 @@ type Session struct {
 	sent uint64
 	recv uint64
 
+	//bytes received from client and sent to it
+	rxBytes uint64
+	txBytes uint64
+
+	//when session was started and why it was closed
+	started     time.Time
+	termination string
+	closeOnce   sync.Once
+
 	//session config
 	cfg Config
 
@@ -78,6 +89,7 @@ func NewSession(clientAddr *net.UDPAddr, conn net.Conn, backend core.Backend, sc
 		conn:       conn,
 		backend:    backend,
 		scheduler:  scheduler,
+		started:    time.Now(),
 		out:        make(chan packet, MAX_PACKETS_QUEUE),
 		stopC:      make(chan struct{}, 1),
 	}
@@ -96,7 +108,7 @@ func NewSession(clientAddr *net.UDPAddr, conn net.Conn, backend core.Backend, sc
 			select {
 
 			case <-tC:
-				s.Close()
+				s.closeWith(accesslog.TerminationClientIdleTimeout)
 			case pkt := <-s.out:
 				if t != nil {
 					if !t.Stop() {
@@ -123,6 +135,7 @@ func NewSession(clientAddr *net.UDPAddr, conn net.Conn, backend core.Backend, sc
 				}
 
 				s.scheduler.IncrementTx(s.backend, uint(n))
+				atomic.AddUint64(&s.rxBytes, uint64(n))
 
 				if s.cfg.MaxRequests > 0 && atomic.AddUint64(&s.sent, 1) > s.cfg.MaxRequests {
 					log.Errorf("Restricted to send more UDP packets")
@@ -135,6 +148,7 @@ func NewSession(clientAddr *net.UDPAddr, conn net.Conn, backend core.Backend, sc
 				}
 				s.conn.Close()
 				s.scheduler.DecrementConnection(s.backend)
+				s.logRecord()
 				// drain output packets channel and free buffers
 				for {
 					select {
@@ -180,7 +194,8 @@ func (s *Session) ListenResponses(sendTo *net.UDPConn) {
 	go func() {
 		b := make([]byte, UDP_PACKET_SIZE)
 
-		defer s.Close()
+		termination := accesslog.TerminationBackendError
+		defer func() { s.closeWith(termination) }()
 
 		for {
 
@@ -192,6 +207,7 @@ func (s *Session) ListenResponses(sendTo *net.UDPConn) {
 
 			if err != nil {
 				if err, ok := err.(net.Error); ok && err.Timeout() {
+					termination = accesslog.TerminationBackendIdleTimeout
 					return
 				}
 
@@ -207,27 +223,69 @@ func (s *Session) ListenResponses(sendTo *net.UDPConn) {
 
 			if err != nil {
 				log.Errorf("Could not send backend response to client: %v", err)
+				termination = accesslog.TerminationClientError
 				return
 			}
 
+			atomic.AddUint64(&s.txBytes, uint64(m))
+
 			if m != n {
+				termination = accesslog.TerminationClientError
 				return
 			}
 
 			if s.cfg.MaxResponses > 0 && atomic.AddUint64(&s.recv, 1) >= s.cfg.MaxResponses {
+				termination = accesslog.TerminationMaxResponses
 				return
 			}
 		}
 	}()
 }
 
+func (s *Session) Backend() core.Backend {
+	return s.backend
+}
+
 func (s *Session) IsDone() bool {
 	return atomic.LoadUint32(&s.stopped) == 1
 }
 
 func (s *Session) Close() {
+	s.closeWith(accesslog.TerminationServerClosed)
+}
+
+/**
+ * Close session, remembering the first reason it was closed for
+ */
+func (s *Session) closeWith(termination string) {
+	s.closeOnce.Do(func() {
+		s.termination = termination
+	})
+
 	select {
 	case s.stopC <- struct{}{}:
 	default:
 	}
 }
+
+/**
+ * Report metrics and log access record of closed session
+ */
+func (s *Session) logRecord() {
+	duration := time.Since(s.started)
+	rxBytes, txBytes := atomic.LoadUint64(&s.rxBytes), atomic.LoadUint64(&s.txBytes)
+
+	metrics.ReportConnectionEnd(s.scheduler.StatsHandler.Name, s.backend.Target, duration, rxBytes, txBytes)
+
+	accesslog.Log(accesslog.Record{
+		Time:        s.started,
+		Server:      s.cfg.ServerName,
+		Protocol:    "udp",
+		Client:      s.clientAddr.String(),
+		Backend:     s.backend.Address(),
+		Duration:    duration,
+		RxBytes:     rxBytes,
+		TxBytes:     txBytes,
+		Termination: s.termination,
+	})
+}
@@ -78,7 +78,6 @@ func (this *BandwidthCounter) Start() {
 			// Stop requested
 			case <-this.stop:
 				this.ticker.Stop()
-				close(this.Traffic)
 				return
 
 				// New counting cycle
@@ -8,6 +8,7 @@ package stats
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/yyyar/gobetween/core"
@@ -33,7 +34,8 @@ type Handler struct {
 	/* Backends counters */
 	BackendsCounter *counters.BackendsBandwidthCounter
 
-	/* Current stats */
+	/* Current stats, guarded by mu */
+	mu          sync.RWMutex
 	latestStats Stats
 
 	/* ----- channels ----- */
@@ -50,6 +52,9 @@ type Handler struct {
 	/* Channel for indicating stop request */
 	stopChan chan bool
 
+	/* Closed when handler is stopped */
+	stopped chan bool
+
 	/* Input channel for latest stats */
 	ServerStats chan counters.BandwidthStats
 }
@@ -67,6 +72,7 @@ func NewHandler(name string) *Handler {
 		Connections: make(chan uint),
 		Backends:    make(chan []core.Backend),
 		stopChan:    make(chan bool),
+		stopped:     make(chan bool),
 		latestStats: Stats{
 			RxTotal:  0,
 			TxTotal:  0,
@@ -102,11 +108,15 @@ func (this *Handler) Start() {
 			/* stop stats processor requested */
 			case <-this.stopChan:
 
+				close(this.stopped)
 				this.serverCounter.Stop()
 				this.BackendsCounter.Stop()
 
+				// server may already be replaced by a new one with the same name
 				Store.Lock()
-				delete(Store.handlers, this.Name)
+				if Store.handlers[this.Name] == this {
+					delete(Store.handlers, this.Name)
+				}
 				Store.Unlock()
 
 				// close channels
@@ -117,29 +127,42 @@ func (this *Handler) Start() {
 
 			/* New server stats available */
 			case b := <-this.ServerStats:
+				this.mu.Lock()
 				this.latestStats.RxTotal = b.RxTotal
 				this.latestStats.TxTotal = b.TxTotal
 				this.latestStats.RxSecond = b.RxSecond
 				this.latestStats.TxSecond = b.TxSecond
+				this.mu.Unlock()
 
 				metrics.ReportHandleStatsChange(fmt.Sprintf("%s", this.Name), b)
 
 			/* New server backends with stats available */
 			case backends := <-this.Backends:
+				this.mu.Lock()
 				this.latestStats.Backends = backends
+				this.mu.Unlock()
 
 			/* New sever connections count available */
 			case connections := <-this.Connections:
+				this.mu.Lock()
 				this.latestStats.ActiveConnections = connections
+				this.mu.Unlock()
 
 				metrics.ReportHandleConnectionsChange(fmt.Sprintf("%s", this.Name), connections)
 
 			/* New traffic stats available */
 			case rwc := <-this.Traffic:
-				// forward to counters
+				// forward to counters, unless they are stopped meanwhile
 				go func() {
-					this.serverCounter.Traffic <- rwc
-					this.BackendsCounter.Traffic <- rwc
+					select {
+					case this.serverCounter.Traffic <- rwc:
+					case <-this.stopped:
+						return
+					}
+					select {
+					case this.BackendsCounter.Traffic <- rwc:
+					case <-this.stopped:
+					}
 				}()
 			}
 		}
@@ -147,6 +170,16 @@ func (this *Handler) Start() {
 
 }
 
+/**
+ * Current stats
+ */
+func (this *Handler) Stats() Stats {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	return this.latestStats
+}
+
 /**
  * Request handler stop and clear resources
  */
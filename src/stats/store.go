@@ -18,6 +18,13 @@ var Store = struct {
 	handlers map[string]*Handler
 }{handlers: make(map[string]*Handler)}
 
+/**
+ * Name of stats handler for server's pool
+ */
+func PoolName(server string, pool string) string {
+	return server + "/" + pool
+}
+
 /**
  * Get stats for the server
  */
@@ -30,5 +37,21 @@ func GetStats(name string) interface{} {
 	if !ok {
 		return nil
 	}
-	return handler.latestStats // TODO: syncronize?
+	return handler.Stats()
+}
+
+/**
+ * Get stats of all servers and their pools by stats handler name
+ */
+func All() map[string]Stats {
+
+	Store.RLock()
+	defer Store.RUnlock()
+
+	all := make(map[string]Stats, len(Store.handlers))
+	for name, handler := range Store.handlers {
+		all[name] = handler.Stats()
+	}
+
+	return all
 }
@@ -0,0 +1,240 @@
+package telemetry
+
+/**
+ * telemetry.go - OpenTelemetry (OTLP) export of metrics and traces
+ */
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/info"
+	"github.com/yyyar/gobetween/logging"
+	"github.com/yyyar/gobetween/metrics"
+	"github.com/yyyar/gobetween/utils"
+)
+
+/**
+ * Time given to exporters to flush on stop
+ */
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
+var (
+	mu             sync.Mutex
+	tracerProvider *sdktrace.TracerProvider
+	meterProvider  *sdkmetric.MeterProvider
+
+	/* Tracer of connections, no-op unless traces are exported */
+	current atomic.Pointer[trace.Tracer]
+)
+
+func init() {
+	setTracer(noop.NewTracerProvider().Tracer(""))
+}
+
+func setTracer(t trace.Tracer) {
+	current.Store(&t)
+}
+
+func tracer() trace.Tracer {
+	return *current.Load()
+}
+
+/**
+ * Start exporting metrics and traces. Export is disabled if cfg is nil
+ */
+func Start(cfg *config.OpenTelemetryConfig) error {
+
+	log := logging.For("telemetry")
+
+	if cfg == nil {
+		log.Info("OpenTelemetry export disabled")
+		return nil
+	}
+
+	if !cfg.Metrics && !cfg.Traces {
+		return errors.New("opentelemetry requires metrics or traces to be enabled")
+	}
+
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = "grpc"
+	}
+
+	if protocol != "grpc" && protocol != "http" {
+		return errors.New("Not supported opentelemetry protocol " + protocol)
+	}
+
+	ratio := 1.0
+	if cfg.TracesSampleRatio != nil {
+		ratio = *cfg.TracesSampleRatio
+	}
+
+	if ratio < 0 || ratio > 1 {
+		return errors.New("opentelemetry traces_sample_ratio should be in [0, 1]")
+	}
+
+	interval := utils.ParseDurationOrDefault(cfg.MetricsInterval, 10*time.Second)
+	if interval <= 0 {
+		return errors.New("opentelemetry metrics_interval should be positive")
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = "gobetween"
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(info.Version),
+	)
+
+	ctx := context.Background()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if cfg.Traces {
+		exporter, err := newTraceExporter(ctx, protocol, cfg)
+		if err != nil {
+			return err
+		}
+
+		tracerProvider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.TraceIDRatioBased(ratio)),
+		)
+		setTracer(tracerProvider.Tracer("github.com/yyyar/gobetween"))
+	}
+
+	if cfg.Metrics {
+		exporter, err := newMetricExporter(ctx, protocol, cfg)
+		if err != nil {
+			return err
+		}
+
+		// export the same metrics that are served to prometheus
+		metrics.Collect()
+
+		reader := sdkmetric.NewPeriodicReader(exporter,
+			sdkmetric.WithInterval(interval),
+			sdkmetric.WithProducer(promBridge.NewMetricProducer()),
+		)
+		meterProvider = sdkmetric.NewMeterProvider(
+			sdkmetric.WithReader(reader),
+			sdkmetric.WithResource(res),
+		)
+	}
+
+	log.Info("Exporting OpenTelemetry metrics: ", cfg.Metrics, ", traces: ", cfg.Traces, " to ", cfg.Endpoint, " over ", protocol)
+
+	return nil
+}
+
+/**
+ * Stop exporting, flushing pending metrics and spans
+ */
+func Stop() {
+
+	log := logging.For("telemetry")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	if tracerProvider != nil {
+		setTracer(noop.NewTracerProvider().Tracer(""))
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			log.Error("Could not flush traces: ", err)
+		}
+		tracerProvider = nil
+	}
+
+	if meterProvider != nil {
+		if err := meterProvider.Shutdown(ctx); err != nil {
+			log.Error("Could not flush metrics: ", err)
+		}
+		meterProvider = nil
+	}
+}
+
+/**
+ * Endpoint is either host:port or url with scheme
+ */
+func isUrl(endpoint string) bool {
+	return strings.Contains(endpoint, "://")
+}
+
+func newTraceExporter(ctx context.Context, protocol string, cfg *config.OpenTelemetryConfig) (sdktrace.SpanExporter, error) {
+
+	if protocol == "http" {
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(cfg.Headers)}
+		if isUrl(cfg.Endpoint) {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(cfg.Headers)}
+	if isUrl(cfg.Endpoint) {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+	} else if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+func newMetricExporter(ctx context.Context, protocol string, cfg *config.OpenTelemetryConfig) (sdkmetric.Exporter, error) {
+
+	if protocol == "http" {
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(cfg.Headers)}
+		if isUrl(cfg.Endpoint) {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(cfg.Endpoint))
+		} else if cfg.Endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithHeaders(cfg.Headers)}
+	if isUrl(cfg.Endpoint) {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.Endpoint))
+	} else if cfg.Endpoint != "" {
+		opts = append(opts, otlpmetricgrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	return otlpmetricgrpc.New(ctx, opts...)
+}
@@ -0,0 +1,119 @@
+package telemetry
+
+/**
+ * trace.go - spans of proxied client connections
+ */
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yyyar/gobetween/accesslog"
+)
+
+/**
+ * Connection phases, recorded as child spans of connection span
+ */
+const (
+	PhaseAccept       = "accept"
+	PhaseSni          = "sni"
+	PhaseTlsHandshake = "tls_handshake"
+	PhaseElect        = "elect"
+	PhaseDial         = "dial"
+	PhaseProxy        = "proxy"
+)
+
+/**
+ * Terminations recorded with error status of connection span
+ */
+var errorTerminations = map[string]bool{
+	accesslog.TerminationClientError:        true,
+	accesslog.TerminationBackendError:       true,
+	accesslog.TerminationTlsError:           true,
+	accesslog.TerminationSniError:           true,
+	accesslog.TerminationProxyProtocolError: true,
+	accesslog.TerminationNoBackend:          true,
+}
+
+/**
+ * Start span of client connection accepted by server.
+ * Returned context carries the span and is passed to other trace functions
+ */
+func StartConnection(server string, client string, accepted time.Time) context.Context {
+
+	ctx, _ := tracer().Start(context.Background(), "connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(accepted),
+		trace.WithAttributes(
+			attribute.String("gobetween.server", server),
+			attribute.String("gobetween.client", client),
+		),
+	)
+
+	return ctx
+}
+
+/**
+ * Record phase of connection, lasted from start until now, as child span.
+ * Backend is address of backend the phase is related to, if any
+ */
+func Phase(ctx context.Context, name string, start time.Time, backend string, err error) {
+
+	if ctx == nil || !trace.SpanFromContext(ctx).IsRecording() {
+		return
+	}
+
+	_, span := tracer().Start(ctx, name, trace.WithTimestamp(start))
+
+	if backend != "" {
+		span.SetAttributes(attribute.String("gobetween.backend", backend))
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+/**
+ * End connection span with details of its access log record
+ */
+func EndConnection(ctx context.Context, record accesslog.Record) {
+
+	if ctx == nil {
+		return
+	}
+
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("gobetween.client", record.Client),
+		attribute.String("gobetween.sni", record.Sni),
+		attribute.String("gobetween.backend", record.Backend),
+		attribute.String("gobetween.termination", record.Termination),
+		attribute.Int64("gobetween.rx_bytes", int64(record.RxBytes)),
+		attribute.Int64("gobetween.tx_bytes", int64(record.TxBytes)),
+	)
+
+	if record.TlsVersion != "" {
+		span.SetAttributes(
+			attribute.String("tls.protocol.version", record.TlsVersion),
+			attribute.String("tls.cipher", record.TlsCipher),
+		)
+	}
+
+	if errorTerminations[record.Termination] {
+		span.SetStatus(codes.Error, record.Termination)
+	}
+
+	span.End(trace.WithTimestamp(record.Time.Add(record.Duration)))
+}
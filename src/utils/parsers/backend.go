@@ -53,12 +53,14 @@ func ParseBackend(line string, pattern string) (*core.Backend, error) {
 	}
 
 	weight, err := strconv.Atoi(result["weight"])
-	if err != nil {
+	weightUnset := err != nil
+	if weightUnset {
 		weight = 1
 	}
 
 	priority, err := strconv.Atoi(result["priority"])
-	if err != nil {
+	priorityUnset := err != nil
+	if priorityUnset {
 		priority = 1
 	}
 
@@ -76,6 +78,8 @@ func ParseBackend(line string, pattern string) (*core.Backend, error) {
 		MaxConnections: maxConnections,
 		Sni:           result["sni"],
 		Priority:      priority,
+		PriorityUnset: priorityUnset,
+		WeightUnset:   weightUnset,
 		Stats: core.BackendStats{
 			Live: true,
 		},
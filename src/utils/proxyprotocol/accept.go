@@ -0,0 +1,136 @@
+package proxyprotocol
+
+/**
+ * accept.go - reading proxy protocol headers sent by trusted peers
+ */
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/utils"
+)
+
+/**
+ * Acceptor reads proxy protocol v1 or v2 headers on connections
+ * from peers in trusted networks
+ */
+type Acceptor struct {
+	Trusted     []*net.IPNet
+	Required    bool
+	ReadTimeout time.Duration
+}
+
+/**
+ * Creates new Acceptor based on config
+ */
+func NewAcceptor(cfg *config.AcceptProxyProtocol) (*Acceptor, error) {
+
+	if cfg == nil {
+		return nil, errors.New("AcceptProxyProtocol is nil")
+	}
+
+	trusted, err := ParseNetworks(cfg.TrustedNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Acceptor{
+		Trusted:     trusted,
+		Required:    cfg.Required,
+		ReadTimeout: utils.ParseDurationOrDefault(cfg.ReadTimeout, 0),
+	}, nil
+}
+
+/**
+ * Parses list of ips and cidrs to networks
+ */
+func ParseNetworks(networks []string) ([]*net.IPNet, error) {
+
+	result := []*net.IPNet{}
+
+	for _, n := range networks {
+
+		if ip := net.ParseIP(n); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, errors.New("Cant parse network, not an ip or cidr: " + n)
+		}
+
+		result = append(result, network)
+	}
+
+	return result, nil
+}
+
+/**
+ * Checks if peer is in trusted networks
+ */
+func (this *Acceptor) Trusts(addr net.Addr) bool {
+
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range this.Trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/**
+ * Accept reads proxy protocol header if connection is from trusted peer.
+ * Returned connection reports client and destination from the header
+ * as RemoteAddr and LocalAddr, so that they are used everywhere instead of peer's ones.
+ * Connections from other peers are returned as is
+ */
+func (this *Acceptor) Accept(conn net.Conn) (net.Conn, error) {
+
+	if !this.Trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	policy := proxyproto.USE
+	if this.Required {
+		policy = proxyproto.REQUIRE
+	}
+
+	proxyConn := proxyproto.NewConn(conn, proxyproto.WithPolicy(policy), proxyproto.SetReadHeaderTimeout(this.ReadTimeout))
+
+	// empty read returns nothing but header parsing error, if any
+	if _, err := proxyConn.Read(nil); err != nil {
+		return nil, err
+	}
+
+	header := proxyConn.ProxyHeader()
+	if header == nil || header.Command.IsLocal() {
+		return proxyConn, nil
+	}
+
+	// local headers keep peer addresses, others should have tcp ones
+	// as everything after accept relies on them
+	_, srcOk := header.SourceAddr.(*net.TCPAddr)
+	_, dstOk := header.DestinationAddr.(*net.TCPAddr)
+	if !srcOk || !dstOk {
+		return nil, fmt.Errorf("Unsupported proxy_protocol header addresses %v -> %v", header.SourceAddr, header.DestinationAddr)
+	}
+
+	return proxyConn, nil
+}
@@ -1,11 +1,29 @@
 package proxyprotocol
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strconv"
+	"strings"
 
 	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/pires/go-proxyproto/tlvparse"
+	tlsutil "github.com/yyyar/gobetween/utils/tls"
+)
+
+/**
+ * Custom TLVs with verified client certificate identity
+ */
+const (
+	/* Client certificate subject, like "CN=client,O=Example" */
+	PP2_TYPE_CLIENT_SUBJECT proxyproto.PP2Type = 0xE0
+
+	/* Client certificate subject alternative names, like "DNS:client.example.com,email:client@example.com,URI:spiffe://example.com/client" */
+	PP2_TYPE_CLIENT_SAN proxyproto.PP2Type = 0xE1
+
+	/* Hex encoded sha256 fingerprint of client certificate */
+	PP2_TYPE_CLIENT_FINGERPRINT proxyproto.PP2Type = 0xE2
 )
 
 func addrToIPAndPort(addr net.Addr) (ip net.IP, port uint16, err error) {
@@ -58,3 +76,168 @@ func SendProxyProtocolV1(client net.Conn, backend net.Conn) error {
 	}
 	return nil
 }
+
+/**
+ * SendProxyProtocolV2 sends a proxy protocol v2 (binary) header to initialize the connection.
+ * SNI hostname is sent as PP2_TYPE_AUTHORITY. If client is tls connection terminated by
+ * gobetween, negotiated ALPN, tls version, cipher and client certificate CN are sent too,
+ * and subject, SANs and fingerprint of verified client certificate in custom TLVs,
+ * so handshake should be completed before calling it.
+ */
+func SendProxyProtocolV2(client net.Conn, backend net.Conn, sni string) error {
+
+	h := proxyproto.HeaderProxyFromAddrs(2, client.RemoteAddr(), client.LocalAddr())
+
+	var state *tls.ConnectionState
+	if tlsConn, ok := client.(*tls.Conn); ok {
+		s := tlsConn.ConnectionState()
+		state = &s
+		if sni == "" {
+			sni = s.ServerName
+		}
+	}
+
+	tlvs, err := makeTLVs(sni, state)
+	if err != nil {
+		return err
+	}
+
+	if err := h.SetTLVs(tlvs); err != nil {
+		return err
+	}
+
+	_, err = h.WriteTo(backend)
+	return err
+}
+
+/**
+ * MakeProxyProtocolV2Header returns proxy protocol v2 header for datagram received
+ * from client on local address. It should prefix every datagram sent to backend
+ */
+func MakeProxyProtocolV2Header(client *net.UDPAddr, local *net.UDPAddr) ([]byte, error) {
+
+	h := &proxyproto.Header{
+		Version:           2,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: proxyproto.UDPv4,
+		SourceAddr:        client,
+		DestinationAddr:   local,
+	}
+
+	// ipv4 client on dual stack socket
+	if client.IP.To4() != nil && local.IP.To4() == nil {
+		if local.IP.IsUnspecified() {
+			h.DestinationAddr = &net.UDPAddr{IP: net.IPv4zero, Port: local.Port}
+		} else {
+			h.TransportProtocol = proxyproto.UDPv6
+		}
+	} else if client.IP.To4() == nil {
+		h.TransportProtocol = proxyproto.UDPv6
+	}
+
+	return h.Format()
+}
+
+/**
+ * Make TLVs for known client connection details
+ */
+func makeTLVs(sni string, state *tls.ConnectionState) ([]proxyproto.TLV, error) {
+
+	tlvs := []proxyproto.TLV{}
+
+	if sni != "" {
+		tlvs = append(tlvs, proxyproto.TLV{
+			Type:  proxyproto.PP2_TYPE_AUTHORITY,
+			Value: []byte(sni),
+		})
+	}
+
+	if state == nil {
+		return tlvs, nil
+	}
+
+	if state.NegotiatedProtocol != "" {
+		tlvs = append(tlvs, proxyproto.TLV{
+			Type:  proxyproto.PP2_TYPE_ALPN,
+			Value: []byte(state.NegotiatedProtocol),
+		})
+	}
+
+	// verify is zero only if client certificate was presented and verified
+	ssl := tlvparse.PP2SSL{
+		Client: tlvparse.PP2_BITFIELD_CLIENT_SSL,
+		Verify: 1,
+		TLV: []proxyproto.TLV{
+			{
+				Type:  proxyproto.PP2_SUBTYPE_SSL_VERSION,
+				Value: []byte(versionName(state.Version)),
+			},
+			{
+				Type:  proxyproto.PP2_SUBTYPE_SSL_CIPHER,
+				Value: []byte(tls.CipherSuiteName(state.CipherSuite)),
+			},
+		},
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		ssl.Client |= tlvparse.PP2_BITFIELD_CLIENT_CERT_SESS
+		if !state.DidResume {
+			ssl.Client |= tlvparse.PP2_BITFIELD_CLIENT_CERT_CONN
+		}
+		if len(state.VerifiedChains) > 0 {
+			ssl.Verify = 0
+		}
+		ssl.TLV = append(ssl.TLV, proxyproto.TLV{
+			Type:  proxyproto.PP2_SUBTYPE_SSL_CN,
+			Value: []byte(state.PeerCertificates[0].Subject.CommonName),
+		})
+	}
+
+	tlv, err := ssl.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	tlvs = append(tlvs, tlv)
+
+	if len(state.VerifiedChains) > 0 {
+
+		identity := tlsutil.ClientIdentity(state.VerifiedChains[0][0])
+
+		san := []string{}
+		for _, name := range identity.DnsNames {
+			san = append(san, "DNS:"+name)
+		}
+		for _, email := range identity.Emails {
+			san = append(san, "email:"+email)
+		}
+		for _, uri := range identity.Uris {
+			san = append(san, "URI:"+uri)
+		}
+
+		tlvs = append(tlvs,
+			proxyproto.TLV{Type: PP2_TYPE_CLIENT_SUBJECT, Value: []byte(identity.Subject)},
+			proxyproto.TLV{Type: PP2_TYPE_CLIENT_SAN, Value: []byte(strings.Join(san, ","))},
+			proxyproto.TLV{Type: PP2_TYPE_CLIENT_FINGERPRINT, Value: []byte(identity.Fingerprint)},
+		)
+	}
+
+	return tlvs, nil
+}
+
+/**
+ * Tls version in the form used by other proxies, i.e. "TLSv1.3"
+ */
+func versionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	}
+	return tls.VersionName(version)
+}
@@ -0,0 +1,265 @@
+package tls
+
+/**
+ * certificates.go - server certificates selected by sni
+ */
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+)
+
+/**
+ * Server certificates. Certificate is selected by exact
+ * or wildcard match of its names, falling back to default one.
+ * Certificates may be reloaded from files at any time
+ */
+type Certificates struct {
+
+	/* Tls config certificates are loaded by */
+	cfg *config.Tls
+
+	/* Guards set */
+	mu sync.RWMutex
+
+	/* Currently loaded certificates */
+	set *certificateSet
+}
+
+/**
+ * Certificates loaded at once
+ */
+type certificateSet struct {
+
+	/* Certificates in load order, first one is default */
+	certs []*tls.Certificate
+
+	/* Certificates by their dns names, including wildcard ones */
+	names map[string]*tls.Certificate
+
+	/* Details of loaded certificates */
+	info []core.CertificateInfo
+}
+
+/**
+ * Load certificates of tls config: cert_path and key_path pair,
+ * certificates list and pem files of cert_dir, in this order
+ */
+func LoadCertificates(tlsC *config.Tls) (*Certificates, error) {
+
+	set, err := loadCertificateSet(tlsC)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificates{cfg: tlsC, set: set}, nil
+}
+
+/**
+ * Load certificates from files again. If any of them fails
+ * to load, currently loaded certificates are kept
+ */
+func (this *Certificates) Reload() error {
+
+	set, err := loadCertificateSet(this.cfg)
+	if err != nil {
+		return err
+	}
+
+	this.mu.Lock()
+	this.set = set
+	this.mu.Unlock()
+
+	return nil
+}
+
+/**
+ * Files and directories certificates are loaded from
+ */
+func (this *Certificates) Paths() []string {
+
+	paths := []string{}
+
+	if this.cfg.CertPath != "" {
+		paths = append(paths, this.cfg.CertPath, this.cfg.KeyPath)
+	}
+
+	for _, c := range this.cfg.Certificates {
+		paths = append(paths, c.CertPath, c.KeyPath)
+	}
+
+	if this.cfg.CertDir != "" {
+		paths = append(paths, this.cfg.CertDir)
+	}
+
+	return paths
+}
+
+/**
+ * Details of loaded certificates
+ */
+func (this *Certificates) Info() []core.CertificateInfo {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	return this.set.info
+}
+
+/**
+ * Number of loaded certificates
+ */
+func (this *Certificates) Len() int {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	return len(this.set.certs)
+}
+
+/**
+ * Get certificate for hostname: exact match, wildcard match of first label,
+ * or default certificate. Returns nil if no certificates are loaded
+ */
+func (this *Certificates) Get(hostname string) *tls.Certificate {
+
+	this.mu.RLock()
+	set := this.set
+	this.mu.RUnlock()
+
+	if len(set.certs) == 0 {
+		return nil
+	}
+
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+
+	if crt, ok := set.names[hostname]; ok {
+		return crt
+	}
+
+	if i := strings.Index(hostname, "."); i > 0 {
+		if crt, ok := set.names["*"+hostname[i:]]; ok {
+			return crt
+		}
+	}
+
+	return set.certs[0]
+}
+
+/**
+ * Load all certificates of tls config
+ */
+func loadCertificateSet(tlsC *config.Tls) (*certificateSet, error) {
+
+	set := &certificateSet{
+		names: make(map[string]*tls.Certificate),
+		info:  []core.CertificateInfo{},
+	}
+
+	if tlsC.CertPath != "" || tlsC.KeyPath != "" {
+		if err := set.load(tlsC.CertPath, tlsC.KeyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range tlsC.Certificates {
+		if err := set.load(c.CertPath, c.KeyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if tlsC.CertDir != "" {
+
+		files, err := filepath.Glob(filepath.Join(tlsC.CertDir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(files) == 0 {
+			return nil, errors.New("No .pem files in cert_dir " + tlsC.CertDir)
+		}
+
+		sort.Strings(files)
+
+		// each file has both certificate chain and its key
+		for _, file := range files {
+			if err := set.load(file, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return set, nil
+}
+
+/**
+ * Load certificate and key pair and index it by its names
+ */
+func (this *certificateSet) load(certPath string, keyPath string) error {
+
+	certPem, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+
+	keyPem, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	crt, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return errors.New(certPath + ": " + err.Error())
+	}
+
+	if crt.Leaf == nil {
+		if crt.Leaf, err = x509.ParseCertificate(crt.Certificate[0]); err != nil {
+			return errors.New(certPath + ": " + err.Error())
+		}
+	}
+
+	this.certs = append(this.certs, &crt)
+	this.info = append(this.info, DescribeCertificate("tls", certPath, crt.Leaf))
+
+	names := crt.Leaf.DNSNames
+	if len(names) == 0 && crt.Leaf.Subject.CommonName != "" {
+		names = []string{crt.Leaf.Subject.CommonName}
+	}
+
+	// first loaded certificate wins for duplicate names
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if _, ok := this.names[name]; !ok {
+			this.names[name] = &crt
+		}
+	}
+
+	return nil
+}
+
+/**
+ * Make details of certificate loaded from path
+ */
+func DescribeCertificate(typ string, path string, crt *x509.Certificate) core.CertificateInfo {
+
+	fingerprint := sha256.Sum256(crt.Raw)
+
+	return core.CertificateInfo{
+		Type:        typ,
+		Path:        path,
+		Subject:     crt.Subject.String(),
+		Names:       crt.DNSNames,
+		NotBefore:   crt.NotBefore,
+		NotAfter:    crt.NotAfter,
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+}
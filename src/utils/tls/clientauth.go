@@ -0,0 +1,276 @@
+package tls
+
+/**
+ * clientauth.go - client certificates verification
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/utils/tls/sni"
+)
+
+/**
+ * Client verify modes mapping
+ */
+var clientVerifyModes = map[string]tls.ClientAuthType{
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+/**
+ * ClientAuth verifies client certificates against client ca,
+ * revocation lists and allowed names. Ca and revocation lists
+ * may be reloaded from files at any time
+ */
+type ClientAuth struct {
+
+	/* Tls config client auth is made by */
+	cfg *config.Tls
+
+	/* Client names allowed to connect, nil if any */
+	allowed *sni.Matcher
+
+	/* Guards pool, crls and info */
+	mu sync.RWMutex
+
+	/* Client ca certificates */
+	pool *x509.CertPool
+
+	/* Revocation lists */
+	crls []*x509.RevocationList
+
+	/* Details of client ca certificates */
+	info []core.CertificateInfo
+}
+
+/**
+ * Make client auth for tls config. Returns nil if client
+ * certificates are not verified
+ */
+func NewClientAuth(tlsC *config.Tls) (*ClientAuth, error) {
+
+	if tlsC == nil || tlsC.ClientVerify == "" || tlsC.ClientVerify == "none" {
+		return nil, nil
+	}
+
+	if _, ok := clientVerifyModes[tlsC.ClientVerify]; !ok {
+		return nil, errors.New("Not supported tls client_verify " + tlsC.ClientVerify)
+	}
+
+	this := &ClientAuth{cfg: tlsC}
+
+	if len(tlsC.ClientAllowed) > 0 {
+		allowed, err := sni.NewMatcher(tlsC.ClientAllowed)
+		if err != nil {
+			return nil, err
+		}
+		this.allowed = allowed
+	}
+
+	if err := this.Reload(); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+/**
+ * Load client ca and revocation lists from files again. If any
+ * of them fails to load, currently loaded ones are kept
+ */
+func (this *ClientAuth) Reload() error {
+
+	caPem, err := os.ReadFile(this.cfg.ClientCaPath)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	info := []core.CertificateInfo{}
+
+	for block, rest := pem.Decode(caPem); block != nil; block, rest = pem.Decode(rest) {
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		crt, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return errors.New(this.cfg.ClientCaPath + ": " + err.Error())
+		}
+
+		pool.AddCert(crt)
+		info = append(info, DescribeCertificate("client_ca", this.cfg.ClientCaPath, crt))
+	}
+
+	if len(info) == 0 {
+		return errors.New("No certificates in " + this.cfg.ClientCaPath)
+	}
+
+	var crls []*x509.RevocationList
+	if this.cfg.ClientCrlPath != "" {
+		if crls, err = loadRevocationLists(this.cfg.ClientCrlPath); err != nil {
+			return err
+		}
+	}
+
+	this.mu.Lock()
+	this.pool, this.crls, this.info = pool, crls, info
+	this.mu.Unlock()
+
+	return nil
+}
+
+/**
+ * Files client ca and revocation lists are loaded from
+ */
+func (this *ClientAuth) Paths() []string {
+
+	paths := []string{this.cfg.ClientCaPath}
+
+	if this.cfg.ClientCrlPath != "" {
+		paths = append(paths, this.cfg.ClientCrlPath)
+	}
+
+	return paths
+}
+
+/**
+ * Details of loaded client ca certificates
+ */
+func (this *ClientAuth) Info() []core.CertificateInfo {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+
+	return this.info
+}
+
+/**
+ * Make tlsConfig request and verify client certificates
+ */
+func (this *ClientAuth) apply(tlsConfig *tls.Config) {
+
+	tlsConfig.ClientAuth = clientVerifyModes[this.cfg.ClientVerify]
+	tlsConfig.VerifyConnection = this.verify
+
+	// client cas may be reloaded, so they are set for every handshake
+	base := tlsConfig.Clone()
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		this.mu.RLock()
+		defer this.mu.RUnlock()
+
+		c := base.Clone()
+		c.ClientCAs = this.pool
+		return c, nil
+	}
+}
+
+/**
+ * Check verified client certificate is not revoked and its name is allowed
+ */
+func (this *ClientAuth) verify(state tls.ConnectionState) error {
+
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+
+	chain := state.VerifiedChains[0]
+
+	this.mu.RLock()
+	crls := this.crls
+	this.mu.RUnlock()
+
+	for i := 0; i < len(chain)-1; i++ {
+		for _, crl := range crls {
+
+			if !bytes.Equal(crl.RawIssuer, chain[i].RawIssuer) || crl.CheckSignatureFrom(chain[i+1]) != nil {
+				continue
+			}
+
+			for _, revoked := range crl.RevokedCertificateEntries {
+				if revoked.SerialNumber.Cmp(chain[i].SerialNumber) == 0 {
+					return errors.New("Client certificate " + chain[i].Subject.String() + " is revoked")
+				}
+			}
+		}
+	}
+
+	if this.allowed == nil {
+		return nil
+	}
+
+	for _, name := range ClientIdentity(chain[0]).Names() {
+		if this.allowed.Match(name) {
+			return nil
+		}
+	}
+
+	return errors.New("Client certificate " + chain[0].Subject.String() + " is not allowed")
+}
+
+/**
+ * Make client identity of its certificate
+ */
+func ClientIdentity(crt *x509.Certificate) *core.ClientIdentity {
+
+	fingerprint := sha256.Sum256(crt.Raw)
+
+	identity := &core.ClientIdentity{
+		Subject:     crt.Subject.String(),
+		CommonName:  crt.Subject.CommonName,
+		DnsNames:    crt.DNSNames,
+		Emails:      crt.EmailAddresses,
+		Uris:        []string{},
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+
+	for _, uri := range crt.URIs {
+		identity.Uris = append(identity.Uris, uri.String())
+	}
+
+	return identity
+}
+
+/**
+ * Load revocation lists from pem or der encoded file
+ */
+func loadRevocationLists(path string) ([]*x509.RevocationList, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ders := [][]byte{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+
+	if len(ders) == 0 {
+		ders = append(ders, data)
+	}
+
+	crls := []*x509.RevocationList{}
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, errors.New(path + ": " + err.Error())
+		}
+		crls = append(crls, crl)
+	}
+
+	return crls, nil
+}
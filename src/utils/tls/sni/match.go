@@ -0,0 +1,88 @@
+package sni
+
+/**
+ * match.go - hostname patterns matcher
+ */
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+/**
+ * Matcher of hostname against list of patterns:
+ * "app.example.com" matches exactly (case insensitive),
+ * "*.example.com" matches any subdomain of example.com,
+ * "~^app-[0-9]+\.example\.com$" matches regexp
+ */
+type Matcher struct {
+	exact    map[string]bool
+	suffixes []string
+	regexps  []*regexp.Regexp
+}
+
+/**
+ * Create new matcher, validating patterns
+ */
+func NewMatcher(patterns []string) (*Matcher, error) {
+
+	if len(patterns) == 0 {
+		return nil, errors.New("No sni patterns")
+	}
+
+	m := &Matcher{
+		exact: make(map[string]bool),
+	}
+
+	for _, p := range patterns {
+		switch {
+		case p == "":
+			return nil, errors.New("Empty sni pattern")
+
+		case strings.HasPrefix(p, "~"):
+			r, err := regexp.Compile(p[1:])
+			if err != nil {
+				return nil, errors.New("Invalid sni regexp " + p[1:] + ": " + err.Error())
+			}
+			m.regexps = append(m.regexps, r)
+
+		case strings.HasPrefix(p, "*."):
+			m.suffixes = append(m.suffixes, strings.ToLower(p[1:]))
+
+		case strings.Contains(p, "*"):
+			return nil, errors.New("Wildcard is allowed only as first label of sni pattern, got " + p)
+
+		default:
+			m.exact[strings.ToLower(p)] = true
+		}
+	}
+
+	return m, nil
+}
+
+/**
+ * Check if hostname matches any of patterns
+ */
+func (this *Matcher) Match(hostname string) bool {
+
+	hostname = strings.ToLower(hostname)
+
+	if this.exact[hostname] {
+		return true
+	}
+
+	for _, suffix := range this.suffixes {
+		if len(hostname) > len(suffix) && strings.HasSuffix(hostname, suffix) {
+			return true
+		}
+	}
+
+	for _, r := range this.regexps {
+		if r.MatchString(hostname) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -9,9 +9,13 @@ package tls
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"os"
+	"strings"
 
 	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
 )
 
 /**
@@ -87,7 +91,13 @@ func MapCiphers(ciphers []string) []uint16 {
 	return result
 }
 
-func MakeTlsConfig(tlsC *config.Tls, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+/**
+ * MakeTlsConfig makes a tls.Config for incoming connections.
+ * Certificates of acme_hosts are got with getCertificate, if it's set,
+ * others are selected by sni from certs, that may be reloaded later.
+ * Client certificates are verified by clientAuth, if it's set
+ */
+func MakeTlsConfig(tlsC *config.Tls, certs *Certificates, clientAuth *ClientAuth, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
 
 	if tlsC == nil {
 		return nil, nil
@@ -99,33 +109,45 @@ func MakeTlsConfig(tlsC *config.Tls, getCertificate func(*tls.ClientHelloInfo) (
 	tlsConfig.MinVersion = MapVersion(tlsC.MinVersion)
 	tlsConfig.MaxVersion = MapVersion(tlsC.MaxVersion)
 	tlsConfig.SessionTicketsDisabled = !tlsC.SessionTickets
+	tlsConfig.NextProtos = tlsC.Alpn
 
-	if getCertificate != nil {
-		tlsConfig.GetCertificate = getCertificate
-		return tlsConfig, nil
+	if certs.Len() == 0 && getCertificate == nil {
+		return nil, errors.New("No certificates for tls")
 	}
 
-	var crt tls.Certificate
-	var err error
-	if crt, err = tls.LoadX509KeyPair(tlsC.CertPath, tlsC.KeyPath); err != nil {
-		return nil, err
+	acmeHosts := make(map[string]bool, len(tlsC.AcmeHosts))
+	for _, host := range tlsC.AcmeHosts {
+		acmeHosts[strings.ToLower(host)] = true
 	}
 
-	tlsConfig.Certificates = []tls.Certificate{crt}
+	tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+		if getCertificate != nil && (acmeHosts[strings.ToLower(hello.ServerName)] || certs.Len() == 0) {
+			return getCertificate(hello)
+		}
+
+		return certs.Get(hello.ServerName), nil
+	}
+
+	if clientAuth != nil {
+		clientAuth.apply(tlsConfig)
+	}
 
 	return tlsConfig, nil
 }
 
 /**
- * MakeBackendTLSConfig makes a tls.Config for connecting to backends
+ * MakeBackendTLSConfig makes a tls.Config for connecting to backends,
+ * returning details of certificates it loaded
  */
-func MakeBackendTLSConfig(backendsTls *config.BackendsTls) (*tls.Config, error) {
+func MakeBackendTLSConfig(backendsTls *config.BackendsTls) (*tls.Config, []core.CertificateInfo, error) {
 
 	if backendsTls == nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	var err error
+	info := []core.CertificateInfo{}
 
 	result := &tls.Config{
 		InsecureSkipVerify:     backendsTls.IgnoreVerify,
@@ -140,10 +162,17 @@ func MakeBackendTLSConfig(backendsTls *config.BackendsTls) (*tls.Config, error)
 		var crt tls.Certificate
 
 		if crt, err = tls.LoadX509KeyPair(*backendsTls.CertPath, *backendsTls.KeyPath); err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		if crt.Leaf == nil {
+			if crt.Leaf, err = x509.ParseCertificate(crt.Certificate[0]); err != nil {
+				return nil, nil, err
+			}
 		}
 
 		result.Certificates = []tls.Certificate{crt}
+		info = append(info, DescribeCertificate("backends_tls", *backendsTls.CertPath, crt.Leaf))
 	}
 
 	if backendsTls.RootCaCertPath != nil {
@@ -151,18 +180,36 @@ func MakeBackendTLSConfig(backendsTls *config.BackendsTls) (*tls.Config, error)
 		var caCertPem []byte
 
 		if caCertPem, err = os.ReadFile(*backendsTls.RootCaCertPath); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		caCertPool := x509.NewCertPool()
-		if ok := caCertPool.AppendCertsFromPEM(caCertPem); !ok {
-			return nil, err
+		roots := 0
+
+		for block, rest := pem.Decode(caCertPem); block != nil; block, rest = pem.Decode(rest) {
+
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+
+			crt, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, errors.New(*backendsTls.RootCaCertPath + ": " + err.Error())
+			}
+
+			caCertPool.AddCert(crt)
+			roots++
+			info = append(info, DescribeCertificate("backends_tls_root_ca", *backendsTls.RootCaCertPath, crt))
+		}
+
+		if roots == 0 {
+			return nil, nil, errors.New("No certificates in " + *backendsTls.RootCaCertPath)
 		}
 
 		result.RootCAs = caCertPool
 
 	}
 
-	return result, nil
+	return result, info, nil
 
 }
@@ -0,0 +1,121 @@
+package watcher
+
+/**
+ * watcher.go - notifies about changes of files on disk
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/yyyar/gobetween/logging"
+)
+
+/**
+ * Events within this period are coalesced into single notification,
+ * so that editors and config management tools writing file in several
+ * steps trigger only one reload
+ */
+const DEBOUNCE = 200 * time.Millisecond
+
+/**
+ * Watch starts watching paths (files or directories) for changes
+ * and sends to returned channel each time something has changed.
+ *
+ * Files are watched through their parent directories, so replacing
+ * file by rename (what most tools do to write atomically) is noticed too.
+ * Watching stops when stop is closed.
+ */
+func Watch(stop <-chan bool, paths ...string) (<-chan bool, error) {
+
+	log := logging.For("watcher")
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("Could not create watcher: %v", err)
+	}
+
+	files := map[string]bool{}
+	dirs := map[string]bool{}
+
+	for _, path := range paths {
+
+		path, err := filepath.Abs(path)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+
+		dir := path
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs[path] = true
+		} else {
+			files[path] = true
+			dir = filepath.Dir(path)
+		}
+
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("Could not watch %s: %v", dir, err)
+		}
+	}
+
+	matches := func(name string) bool {
+		name = filepath.Clean(name)
+		if files[name] {
+			return true
+		}
+		for dir := range dirs {
+			if strings.HasPrefix(name, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	out := make(chan bool, 1)
+
+	go func() {
+
+		defer w.Close()
+
+		debounce := time.NewTimer(DEBOUNCE)
+		debounce.Stop()
+
+		for {
+			select {
+
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod || !matches(event.Name) {
+					continue
+				}
+				debounce.Reset(DEBOUNCE)
+
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Error("Error watching ", paths, ": ", err)
+
+			case <-debounce.C:
+				select {
+				case out <- true:
+				default:
+				}
+
+			case <-stop:
+				debounce.Stop()
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,252 @@
+package test
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/accesslog"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+)
+
+func TestAccessLog(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	if err := accesslog.Start(&config.AccessLogConfig{Output: "file", FilePath: path}); err != nil {
+		t.Fatal(err)
+	}
+	defer accesslog.Stop()
+
+	bind := freeBind(t)
+
+	backend := startEchoBackend(t)
+
+	manager.Initialize(config.Config{})
+
+	err := manager.Create("accesslog", config.Server{
+		Bind:     bind,
+		Protocol: "tcp",
+		Discovery: &config.DiscoveryConfig{
+			Kind: "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+				StaticList: []string{backend},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete("accesslog", false)
+
+	for i := 0; ; i++ {
+		if backends, _ := manager.Backends("accesslog", ""); len(backends) == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("No backends discovered")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	client := conn.LocalAddr().String()
+	conn.Close()
+
+	// wait for record to be queued and flush it
+	time.Sleep(500 * time.Millisecond)
+	accesslog.Stop()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("Could not parse record %q: %v", data, err)
+	}
+
+	expected := map[string]interface{}{
+		"server":      "accesslog",
+		"protocol":    "tcp",
+		"client":      client,
+		"backend":     backend,
+		"rx_bytes":    float64(5),
+		"tx_bytes":    float64(5),
+		"termination": "client_closed",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("Record %s is %v, expected %v", key, record[key], value)
+		}
+	}
+	if _, ok := record["tls_version"]; ok {
+		t.Errorf("Unexpected tls fields in record %q", data)
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+
+	record := accesslog.Record{
+		Time:        time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Server:      "web",
+		Protocol:    "tls",
+		Client:      "10.0.0.1:5000",
+		Sni:         "a.example.com\nforged\x00",
+		Backend:     "10.0.0.2:80",
+		Duration:    1500 * time.Millisecond,
+		RxBytes:     10,
+		TxBytes:     20,
+		Termination: accesslog.TerminationBackendClosed,
+		TlsVersion:  "TLS 1.3",
+	}
+
+	cases := []struct {
+		cfg      config.AccessLogConfig
+		expected string
+	}{
+		{
+			config.AccessLogConfig{Format: "logfmt"},
+			`time=2024-01-02T03:04:05Z server=web protocol=tls client=10.0.0.1:5000 sni="a.example.com\nforged\x00" backend=10.0.0.2:80 ` +
+				`connect_time=0 duration=1.5 rx_bytes=10 tx_bytes=20 termination=backend_closed ` +
+				`tls_version="TLS 1.3" tls_cipher="" tls_alpn="" client_subject=""` + "\n",
+		},
+		{
+			config.AccessLogConfig{Format: "template", Template: "{{.Client}} {{.Sni}} -> {{.Backend}} {{.Termination}}"},
+			"10.0.0.1:5000 a.example.com\\nforged\\x00 -> 10.0.0.2:80 backend_closed\n",
+		},
+	}
+
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "access.log")
+		c.cfg.Output, c.cfg.FilePath = "file", path
+
+		if err := accesslog.Start(&c.cfg); err != nil {
+			t.Fatal(err)
+		}
+		accesslog.Log(record)
+		accesslog.Stop()
+
+		data, _ := os.ReadFile(path)
+		if string(data) != c.expected {
+			t.Errorf("%s format wrote %q, expected %q", c.cfg.Format, data, c.expected)
+		}
+	}
+
+	invalid := []config.AccessLogConfig{
+		{Format: "xml"},
+		{Format: "template"},
+		{Output: "file"},
+		{Output: "syslog", SyslogNetwork: "tcp"},
+		{Output: "syslog", SyslogNetwork: "udp", SyslogFacility: "mail2"},
+	}
+	for _, cfg := range invalid {
+		if err := accesslog.Start(&cfg); err == nil {
+			accesslog.Stop()
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestAccessLogFileRotation(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	// each record is larger than half of megabyte, so every record rotates file
+	padding := strings.Repeat("x", 600*1024)
+
+	err := accesslog.Start(&config.AccessLogConfig{
+		Format:         "template",
+		Template:       "{{.Server}} " + padding,
+		Output:         "file",
+		FilePath:       path,
+		FileMaxSize:    1,
+		FileMaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, server := range []string{"first", "second", "third", "fourth"} {
+		accesslog.Log(accesslog.Record{Server: server})
+	}
+	accesslog.Stop()
+
+	expected := map[string]string{
+		path:        "fourth",
+		path + ".1": "third",
+		path + ".2": "second",
+	}
+	for file, server := range expected {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), server+" ") {
+			t.Errorf("%s starts with %.10q, expected %s", file, data, server)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups, got %v", err)
+	}
+}
+
+func TestAccessLogSyslog(t *testing.T) {
+
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	err = accesslog.Start(&config.AccessLogConfig{
+		Format:         "logfmt",
+		Output:         "syslog",
+		SyslogNetwork:  "udp",
+		SyslogAddress:  l.LocalAddr().String(),
+		SyslogFacility: "local1",
+		SyslogTag:      "lb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	accesslog.Log(accesslog.Record{Server: "syslog", Termination: accesslog.TerminationNoBackend})
+	accesslog.Stop()
+
+	l.SetReadDeadline(time.Now().Add(5 * time.Second))
+	b := make([]byte, 4096)
+	n, _, err := l.ReadFrom(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(b[:n])
+
+	// local1 (17) * 8 + informational (6)
+	if !strings.HasPrefix(msg, "<142>1 ") {
+		t.Errorf("Unexpected syslog header in %q", msg)
+	}
+	if !strings.Contains(msg, " lb ") || !strings.Contains(msg, " access - time=") {
+		t.Errorf("Unexpected syslog message %q", msg)
+	}
+	if !strings.Contains(msg, "server=syslog") || !strings.Contains(msg, "termination=no_backend") || strings.HasSuffix(msg, "\n") {
+		t.Errorf("Unexpected syslog record %q", msg)
+	}
+}
@@ -0,0 +1,219 @@
+package test
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/balance"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/discovery"
+	"github.com/yyyar/gobetween/healthcheck"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/server/scheduler"
+	"github.com/yyyar/gobetween/stats"
+)
+
+/**
+ * Starts scheduler with backends discovered from file at path
+ */
+func startFileScheduler(t *testing.T, path string) *scheduler.Scheduler {
+
+	statsHandler := stats.NewHandler(uniqueName("override"))
+
+	s := &scheduler.Scheduler{
+		Balancer: balance.New(nil, "weight", nil),
+		Discovery: discovery.New("file", config.DiscoveryConfig{
+			Kind:       "file",
+			Failpolicy: "keeplast",
+			Interval:   "0",
+			Timeout:    "0",
+			FileDiscoveryConfig: &config.FileDiscoveryConfig{
+				FilePath:   path,
+				FileFormat: "default",
+			},
+		}),
+		Healthcheck:  healthcheck.New("none", config.HealthcheckConfig{Kind: "none"}),
+		StatsHandler: statsHandler,
+	}
+
+	statsHandler.Start()
+	s.Start()
+
+	t.Cleanup(func() {
+		s.Stop()
+		statsHandler.Stop()
+	})
+
+	return s
+}
+
+/**
+ * Waits until scheduler backends pass check, returns them by address
+ */
+func waitSchedulerBackends(t *testing.T, s *scheduler.Scheduler, check func(map[string]core.Backend) bool) map[string]core.Backend {
+
+	for i := 0; ; i++ {
+		backends := map[string]core.Backend{}
+		for _, b := range s.GetBackends() {
+			backends[b.Address()] = b
+		}
+		if check(backends) {
+			return backends
+		}
+		if i == 50 {
+			t.Fatalf("Unexpected backends %+v", backends)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestBackendOverridesSurviveDiscoveryUpdates(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "backends")
+	writeFileAtomic(t, path, "10.0.0.1:80 weight=1\n10.0.0.2:80 weight=1\n")
+
+	s := startFileScheduler(t, path)
+
+	waitSchedulerBackends(t, s, func(b map[string]core.Backend) bool { return len(b) == 2 })
+
+	a := core.Target{Host: "10.0.0.1", Port: "80"}
+	b := core.Target{Host: "10.0.0.2", Port: "80"}
+	weight := 5
+
+	if _, err := s.OverrideBackend(a, core.BackendOverride{State: core.BackendDrain}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OverrideBackend(b, core.BackendOverride{Weight: &weight}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.OverrideBackend(core.Target{Host: "10.0.0.9", Port: "80"}, core.BackendOverride{State: core.BackendDrain}, false); err == nil {
+		t.Error("Override of unknown backend should fail")
+	}
+
+	// discovery changes properties, overrides stay on top of them
+	writeFileAtomic(t, path, "10.0.0.1:80 weight=3\n10.0.0.2:80 weight=2\n10.0.0.3:80\n")
+
+	backends := waitSchedulerBackends(t, s, func(b map[string]core.Backend) bool {
+		return len(b) == 3 && b["10.0.0.1:80"].Weight == 3
+	})
+
+	if o := backends["10.0.0.1:80"].Override; o == nil || o.State != core.BackendDrain {
+		t.Errorf("Drain override lost after discovery update: %+v", backends["10.0.0.1:80"])
+	}
+	if backends["10.0.0.2:80"].Weight != 5 {
+		t.Errorf("Weight override lost after discovery update: %+v", backends["10.0.0.2:80"])
+	}
+
+	// drained backend is never elected
+	for i := 0; i < 20; i++ {
+		elected, err := s.TakeBackend(DummyContext{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elected.Target == a {
+			t.Fatal("Drained backend elected")
+		}
+	}
+
+	// merge keeps other overridden fields
+	backend, err := s.OverrideBackend(b, core.BackendOverride{State: core.BackendDisabled}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.Weight != 5 || backend.Override.State != core.BackendDisabled {
+		t.Errorf("Unexpected merged override %+v", backend)
+	}
+
+	// replacing with empty override reverts to discovered properties
+	backend, err = s.OverrideBackend(b, core.BackendOverride{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backend.Weight != 2 || backend.Override != nil {
+		t.Errorf("Override not reverted %+v", backend)
+	}
+
+	// override is kept while backend is gone from discovery
+	writeFileAtomic(t, path, "10.0.0.2:80 weight=2\n")
+	waitSchedulerBackends(t, s, func(b map[string]core.Backend) bool { return len(b) == 1 })
+
+	writeFileAtomic(t, path, "10.0.0.1:80 weight=4\n10.0.0.2:80 weight=2\n")
+	backends = waitSchedulerBackends(t, s, func(b map[string]core.Backend) bool { return len(b) == 2 })
+
+	if o := backends["10.0.0.1:80"].Override; o == nil || o.State != core.BackendDrain || backends["10.0.0.1:80"].Weight != 4 {
+		t.Errorf("Override not applied to rediscovered backend: %+v", backends["10.0.0.1:80"])
+	}
+}
+
+func TestBackendDisableClosesConnections(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	name := uniqueName("disable")
+	bind := freeBind(t)
+	backend := startEchoBackend(t)
+	host, port, _ := net.SplitHostPort(backend)
+	target := core.Target{Host: host, Port: port}
+
+	err := manager.Create(name, config.Server{
+		Bind: bind,
+		Discovery: &config.DiscoveryConfig{
+			Kind:                  "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete(name, false)
+
+	waitBackends(t, name, 1)
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	// drain keeps active connections
+	if _, err := manager.OverrideBackend(name, "", target, core.BackendOverride{State: core.BackendDrain}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("again"))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatalf("Connection closed on drain: %v", err)
+	}
+
+	// disable closes them
+	if _, err := manager.OverrideBackend(name, "", target, core.BackendOverride{State: core.BackendDisabled}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Connection not closed on disable: %v", err)
+	}
+
+	// and new ones are not proxied
+	rejected, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rejected.Close()
+
+	rejected.SetDeadline(time.Now().Add(5 * time.Second))
+	rejected.Write([]byte("hello"))
+	if _, err := io.ReadFull(rejected, make([]byte, 5)); err == nil {
+		t.Error("Connection proxied to disabled backend")
+	}
+}
@@ -0,0 +1,240 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/yyyar/gobetween/balance"
+	"github.com/yyyar/gobetween/core"
+)
+
+func TestConsistentAddingBackendsRedistribution(t *testing.T) {
+
+	balancer := &balance.ConsistentBalancer{}
+
+	N := 50   // initial number of backends
+	M := 1    // added number of backends
+	C := 1000 // number of clients
+
+	backends := prepareBackends("127.0.0", N)
+	for _, b := range backends {
+		b.Weight = 1
+	}
+	clients := prepareClients(C)
+
+	d1, err := makeDistribution(balancer, backends, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added := prepareBackends("192.168.1", M)
+	for _, b := range added {
+		b.Weight = 1
+	}
+
+	d2, err := makeDistribution(balancer, append(backends, added...), clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only clients moved to the new backend should be rehashed,
+	// which is about C/(M+N), allow some deviation
+	Q := 0
+	for k, v1 := range d1 {
+		v2 := d2[k]
+		if v1 == v2 {
+			continue
+		}
+		if v2 != added[0] {
+			t.Fatalf("Client %s moved from %s to %s, not to added backend", k, v1.Address(), v2.Address())
+		}
+		Q++
+	}
+
+	if Q > 2*C/(M+N) {
+		t.Fatalf("Too many clients rehashed: %d of %d", Q, C)
+	}
+}
+
+func TestConsistentRemovingBackendsStability(t *testing.T) {
+
+	balancer := &balance.ConsistentBalancer{}
+
+	backends := prepareBackends("127.0.0", 4)
+	for _, b := range backends {
+		b.Weight = 1
+	}
+	clients := prepareClients(100)
+
+	d1, err := makeDistribution(balancer, backends, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removedBackend := backends[1]
+	backends = append(backends[:1], backends[2:]...)
+
+	d2, err := makeDistribution(balancer, backends, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v1 := range d1 {
+		if v1 == removedBackend {
+			continue
+		}
+		if v1 != d2[k] {
+			t.Fatalf("Client %s moved from %s to %s", k, v1.Address(), d2[k].Address())
+		}
+	}
+}
+
+func TestConsistentSameKeySameBackend(t *testing.T) {
+
+	balancer := &balance.ConsistentBalancer{HashKey: "ip"}
+
+	backends := prepareBackends("127.0.0", 10)
+	for _, b := range backends {
+		b.Weight = 1
+	}
+
+	client := prepareClients(1)[0]
+
+	first, err := balancer.Elect(DummyContext{ip: client.ip, port: 1000}, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for port := 1001; port < 1100; port++ {
+		b, err := balancer.Elect(DummyContext{ip: client.ip, port: port}, backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != first {
+			t.Fatalf("Same ip elected different backends %s and %s", first.Address(), b.Address())
+		}
+	}
+}
+
+func TestConsistentBoundedLoad(t *testing.T) {
+
+	balancer := &balance.ConsistentBalancer{LoadFactor: 1.25}
+
+	backends := prepareBackends("127.0.0", 4)
+	for _, b := range backends {
+		b.Weight = 1
+	}
+
+	client := prepareClients(1)[0]
+
+	preferred, err := balancer.Elect(client, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// overload preferred backend far above its fair share
+	preferred.Stats.ActiveConnections = 100
+	for _, b := range backends {
+		if b != preferred {
+			b.Stats.ActiveConnections = 10
+		}
+	}
+
+	spilled, err := balancer.Elect(client, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if spilled == preferred {
+		t.Fatalf("Overloaded backend %s elected with bounded load", preferred.Address())
+	}
+
+	// within its share the preferred backend is elected again
+	preferred.Stats.ActiveConnections = 10
+
+	again, err := balancer.Elect(client, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if again != preferred {
+		t.Fatalf("Expected %s to be elected, got %s", preferred.Address(), again.Address())
+	}
+}
+
+func TestConsistentWeight(t *testing.T) {
+
+	balancer := &balance.ConsistentBalancer{}
+
+	backends := prepareBackends("127.0.0", 2)
+	backends[0].Weight = 1
+	backends[1].Weight = 4
+
+	clients := prepareClients(2000)
+
+	counts := map[*core.Backend]int{}
+	for _, c := range clients {
+		b, err := balancer.Elect(c, backends)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[b]++
+	}
+
+	// expected 1:4 split
+	if counts[backends[1]] < 3*counts[backends[0]] {
+		t.Fatalf("Weights are not respected: %d vs %d", counts[backends[0]], counts[backends[1]])
+	}
+}
+
+func TestConsistentRingOfDiscoveredBackends(t *testing.T) {
+
+	backends := prepareBackends("127.0.0", 10)
+	for _, b := range backends {
+		b.Weight = 1
+	}
+	client := prepareClients(1)[0]
+	ctx := DummyContext{ip: client.ip, port: 1000}
+
+	// backend elected for client is not passed to election, as if it was dead
+	balancer := &balance.ConsistentBalancer{}
+	balancer.UpdateBackends(backends)
+
+	elected, err := balancer.Elect(ctx, backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered := []*core.Backend{}
+	for _, b := range backends {
+		if b != elected {
+			filtered = append(filtered, b)
+		}
+	}
+
+	// elections from filtered backends neither rebuild the ring,
+	// nor do they when excluded backend is back
+	elect := func(electFrom []*core.Backend) float64 {
+		return testing.AllocsPerRun(10, func() {
+			b := &balance.ConsistentBalancer{}
+			b.UpdateBackends(backends)
+			b.Elect(ctx, electFrom)
+			b.Elect(ctx, backends)
+		})
+	}
+
+	if filteredAllocs, allAllocs := elect(filtered), elect(backends); filteredAllocs > allAllocs {
+		t.Errorf("Ring rebuilt on election from filtered backends, %v allocations instead of %v", filteredAllocs, allAllocs)
+	}
+
+	other, err := balancer.Elect(ctx, filtered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == elected {
+		t.Fatal("Elected backend not passed to election")
+	}
+
+	if b, _ := balancer.Elect(ctx, backends); b != elected {
+		t.Errorf("Client moved from %s to %s after backend is back", elected.Address(), b.Address())
+	}
+}
@@ -0,0 +1,95 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/discovery"
+)
+
+func TestDiscoveryCache(t *testing.T) {
+
+	var up atomic.Bool
+	up.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			// drop connection, so fetch fails
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte("10.0.0.1:80 weight=3\n10.0.0.2:80\n"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	newDiscovery := func(endpoint string) *discovery.Discovery {
+		return discovery.New("plaintext", config.DiscoveryConfig{
+			Kind:       "plaintext",
+			Failpolicy: "setempty",
+			Interval:   "200ms",
+			Timeout:    "1s",
+			CacheDir:   dir,
+			PlaintextDiscoveryConfig: &config.PlaintextDiscoveryConfig{
+				PlaintextEndpoint: endpoint,
+			},
+		})
+	}
+
+	// first run fills the cache
+	d := newDiscovery(server.URL)
+	d.Start()
+	backends := nextBackends(t, d)
+	d.Stop()
+
+	if len(backends) != 2 || backends[0].Stats.Stale {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	// cold start with discovery failing serves cached backends
+	up.Store(false)
+
+	d = newDiscovery(server.URL)
+	d.Start()
+	defer d.Stop()
+
+	backends = nextBackends(t, d)
+	if len(backends) != 2 || backends[0].Weight != 3 || backends[1].Address() != "10.0.0.2:80" ||
+		!backends[0].Stats.Stale || !backends[1].Stats.Stale {
+		t.Fatalf("Unexpected cached backends %v", backends)
+	}
+
+	// stale backends are kept while discovery fails, even with setempty failpolicy
+	select {
+	case backends := <-d.Discover():
+		t.Fatalf("Stale backends replaced while discovery fails %v", backends)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// discovery of other source doesn't use the cache
+	other := newDiscovery(server.URL + "/other")
+	other.Start()
+	select {
+	case backends := <-other.Discover():
+		// without cache failpolicy is applied right away
+		if len(backends) != 0 {
+			t.Fatalf("Cache of other source used %v", backends)
+		}
+	case <-time.After(500 * time.Millisecond):
+	}
+	other.Stop()
+
+	// first successful fetch replaces stale backends
+	up.Store(true)
+
+	backends = nextBackends(t, d)
+	if len(backends) != 2 || backends[0].Stats.Stale || backends[1].Stats.Stale {
+		t.Fatalf("Unexpected backends after recovery %v", backends)
+	}
+}
@@ -0,0 +1,226 @@
+package test
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/discovery"
+)
+
+/**
+ * Starts dns server answering with records returned by answer
+ */
+func startDnsServer(t *testing.T, answer func(q dns.Question) []dns.RR) string {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = answer(r.Question[0])
+		w.WriteMsg(m)
+	})}
+
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return conn.LocalAddr().String()
+}
+
+func TestDnsDiscoveryTtl(t *testing.T) {
+
+	var mu sync.Mutex
+	addresses := []string{"10.0.0.1", "10.0.0.2"}
+
+	server := startDnsServer(t, func(q dns.Question) []dns.RR {
+		mu.Lock()
+		defer mu.Unlock()
+
+		rrs := []dns.RR{}
+		if q.Qtype == dns.TypeA {
+			for _, a := range addresses {
+				rr, _ := dns.NewRR(q.Name + " 1 IN A " + a)
+				rrs = append(rrs, rr)
+			}
+		}
+		if q.Qtype == dns.TypeAAAA {
+			rr, _ := dns.NewRR(q.Name + " 60 IN AAAA ::1")
+			rrs = append(rrs, rr)
+		}
+		return rrs
+	})
+
+	newDiscovery := func(family string) *discovery.Discovery {
+		return discovery.New("dns", config.DiscoveryConfig{
+			Kind:       "dns",
+			Failpolicy: "keeplast",
+			Interval:   "0",
+			Timeout:    "1s",
+			DnsDiscoveryConfig: &config.DnsDiscoveryConfig{
+				DnsHostname: "app.example.com",
+				DnsPort:     8080,
+				DnsServer:   server,
+				DnsProtocol: "udp",
+				DnsFamily:   family,
+				DnsMinTtl:   "200ms",
+				DnsMaxTtl:   "5m",
+			},
+		})
+	}
+
+	d := newDiscovery("any")
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 3 || backends[0].Address() != "10.0.0.1:8080" || backends[2].Address() != "[::1]:8080" {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	// refreshed when ttl of 1s expires, not waiting for interval
+	mu.Lock()
+	addresses = []string{"10.0.0.3"}
+	mu.Unlock()
+
+	started := time.Now()
+	backends = nextBackends(t, d)
+	if len(backends) != 2 || backends[0].Address() != "10.0.0.3:8080" {
+		t.Fatalf("Unexpected backends after refresh %v", backends)
+	}
+	if elapsed := time.Since(started); elapsed > 1500*time.Millisecond {
+		t.Fatalf("Refreshed after %v, expected ttl 1s", elapsed)
+	}
+
+	// family preference
+	cases := map[string][]string{
+		"ipv4":        {"10.0.0.3:8080"},
+		"ipv6":        {"[::1]:8080"},
+		"prefer_ipv4": {"10.0.0.3:8080"},
+		"prefer_ipv6": {"[::1]:8080"},
+	}
+
+	for family, expected := range cases {
+		d := newDiscovery(family)
+		d.Start()
+		backends := nextBackends(t, d)
+		d.Stop()
+
+		if len(backends) != len(expected) || backends[0].Address() != expected[0] {
+			t.Fatalf("Unexpected backends for %s: %v", family, backends)
+		}
+	}
+}
+
+func TestSrvDiscoveryTtl(t *testing.T) {
+
+	var mu sync.Mutex
+	port := "8000"
+
+	server := startDnsServer(t, func(q dns.Question) []dns.RR {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch q.Qtype {
+		case dns.TypeSRV:
+			rr, _ := dns.NewRR(q.Name + " 1 IN SRV 1 5 " + port + " node1.example.com.")
+			return []dns.RR{rr}
+		case dns.TypeA:
+			rr, _ := dns.NewRR(q.Name + " 300 IN A 10.0.0.1")
+			return []dns.RR{rr}
+		}
+		return nil
+	})
+
+	d := discovery.New("srv", config.DiscoveryConfig{
+		Kind:       "srv",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "1s",
+		SrvDiscoveryConfig: &config.SrvDiscoveryConfig{
+			SrvLookupServer:  server,
+			SrvLookupPattern: "_app._tcp.example.com.",
+			SrvDnsProtocol:   "udp",
+			SrvMinTtl:        "200ms",
+			SrvMaxTtl:        "5m",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 1 || backends[0].Address() != "10.0.0.1:8000" || backends[0].Weight != 5 {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	mu.Lock()
+	port = "8001"
+	mu.Unlock()
+
+	backends = nextBackends(t, d)
+	if len(backends) != 1 || backends[0].Address() != "10.0.0.1:8001" {
+		t.Fatalf("Unexpected backends after refresh %v", backends)
+	}
+}
+
+func TestSrvDiscoveryInterval(t *testing.T) {
+
+	var mu sync.Mutex
+	port := "8000"
+
+	// records are valid longer than test runs
+	server := startDnsServer(t, func(q dns.Question) []dns.RR {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch q.Qtype {
+		case dns.TypeSRV:
+			rr, _ := dns.NewRR(q.Name + " 600 IN SRV 1 5 " + port + " node1.example.com.")
+			return []dns.RR{rr}
+		case dns.TypeA:
+			rr, _ := dns.NewRR(q.Name + " 600 IN A 10.0.0.1")
+			return []dns.RR{rr}
+		}
+		return nil
+	})
+
+	d := discovery.New("srv", config.DiscoveryConfig{
+		Kind:       "srv",
+		Failpolicy: "keeplast",
+		Interval:   "200ms",
+		Timeout:    "1s",
+		SrvDiscoveryConfig: &config.SrvDiscoveryConfig{
+			SrvLookupServer:  server,
+			SrvLookupPattern: "_app._tcp.example.com.",
+			SrvDnsProtocol:   "udp",
+			SrvMinTtl:        "5m",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 1 || backends[0].Address() != "10.0.0.1:8000" {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	mu.Lock()
+	port = "8001"
+	mu.Unlock()
+
+	// refreshed every interval, not waiting for ttl
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		backends = nextBackends(t, d)
+		if len(backends) == 1 && backends[0].Address() == "10.0.0.1:8001" {
+			return
+		}
+	}
+	t.Fatalf("Unexpected backends after interval %v", backends)
+}
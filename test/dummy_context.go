@@ -3,11 +3,14 @@ package test
 import (
 	"fmt"
 	"net"
+
+	"github.com/yyyar/gobetween/core"
 )
 
 type DummyContext struct {
-	ip   net.IP
-	port int
+	ip      net.IP
+	port    int
+	exclude map[core.Target]bool
 }
 
 func (d DummyContext) String() string {
@@ -28,3 +31,11 @@ func (d DummyContext) Port() int {
 func (d DummyContext) Sni() string {
 	return ""
 }
+
+func (d DummyContext) Client() *core.ClientIdentity {
+	return nil
+}
+
+func (d DummyContext) Excluded(target core.Target) bool {
+	return d.exclude[target]
+}
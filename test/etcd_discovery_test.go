@@ -0,0 +1,119 @@
+package test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/discovery"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func startEtcd(t *testing.T) string {
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+
+	client, _ := url.Parse("http://127.0.0.1:0")
+	peer, _ := url.Parse("http://127.0.0.1:0")
+	cfg.ListenClientUrls = []url.URL{*client}
+	cfg.AdvertiseClientUrls = []url.URL{*client}
+	cfg.ListenPeerUrls = []url.URL{*peer}
+	cfg.AdvertisePeerUrls = []url.URL{*peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd did not start")
+	}
+
+	return e.Clients[0].Addr().String()
+}
+
+func TestEtcdDiscovery(t *testing.T) {
+
+	endpoint := startEtcd(t)
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	put := func(key, value string) {
+		if _, err := client.Put(context.Background(), key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	put("/services/app/1", "10.0.0.1:80 weight=3 sni=a.example.com")
+	put("/services/app/2", `{"host": "10.0.0.2", "port": 80, "priority": 2, "max_connections": 5}`)
+	put("/services/app/3", "not a backend")
+	put("/services/other/1", "10.0.0.9:80")
+
+	d := discovery.New("etcd", config.DiscoveryConfig{
+		Kind:       "etcd",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "5s",
+		EtcdDiscoveryConfig: &config.EtcdDiscoveryConfig{
+			EtcdEndpoints: []string{endpoint},
+			EtcdPrefix:    "/services/app/",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	next := func() []core.Backend {
+		select {
+		case backends := <-d.Discover():
+			return backends
+		case <-time.After(5 * time.Second):
+			t.Fatal("No backends discovered")
+			return nil
+		}
+	}
+
+	// invalid values and keys outside of prefix are skipped
+	backends := next()
+	if len(backends) != 2 {
+		t.Fatalf("Expected 2 backends, got %v", backends)
+	}
+
+	if b := backends[0]; b.Host != "10.0.0.1" || b.Weight != 3 || b.Priority != 1 || b.Sni != "a.example.com" {
+		t.Fatalf("Unexpected backend %v", b)
+	}
+
+	if b := backends[1]; b.Host != "10.0.0.2" || b.Port != "80" || b.Weight != 1 || b.Priority != 2 || b.MaxConnections != 5 {
+		t.Fatalf("Unexpected backend %v", b)
+	}
+
+	// changes are watched
+	put("/services/app/3", "10.0.0.3:8080")
+
+	backends = next()
+	if len(backends) != 3 || backends[2].Address() != "10.0.0.3:8080" {
+		t.Fatalf("Unexpected backends after put %v", backends)
+	}
+
+	if _, err := client.Delete(context.Background(), "/services/app/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	backends = next()
+	if len(backends) != 2 || backends[0].Host != "10.0.0.2" {
+		t.Fatalf("Unexpected backends after delete %v", backends)
+	}
+}
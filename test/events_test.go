@@ -0,0 +1,182 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/api"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/events"
+	"github.com/yyyar/gobetween/manager"
+)
+
+/**
+ * Opens events stream and returns channel of received events
+ */
+func streamEvents(t *testing.T, url string) <-chan events.Event {
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected events response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	out := make(chan events.Event, 100)
+
+	go func() {
+		defer close(out)
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+
+			var event events.Event
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				t.Errorf("Malformed event %q", data)
+				return
+			}
+			out <- event
+		}
+	}()
+
+	return out
+}
+
+/**
+ * Waits for event of type, failing on other events
+ */
+func expectEvent(t *testing.T, stream <-chan events.Event, typ string) events.Event {
+
+	select {
+	case event := <-stream:
+		if event.Type != typ {
+			t.Fatalf("Expected %s event, got %+v", typ, event)
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No %s event received", typ)
+	}
+
+	return events.Event{}
+}
+
+func TestEventsStream(t *testing.T) {
+
+	apiBind := freeBind(t)
+	api.Start(config.ApiConfig{Enabled: true, Bind: apiBind})
+
+	for i := 0; ; i++ {
+		if conn, err := net.Dial("tcp", apiBind); err == nil {
+			conn.Close()
+			break
+		}
+		if i == 50 {
+			t.Fatal("API not started")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	manager.Initialize(config.Config{})
+
+	name := uniqueName("events")
+	bind := freeBind(t)
+	backend := startEchoBackend(t)
+
+	resp, err := http.Get("http://" + apiBind + "/events?type=backend_live,unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected bad request for unknown type, got %d", resp.StatusCode)
+	}
+
+	all := streamEvents(t, "http://"+apiBind+"/events?server="+name)
+	servers := streamEvents(t, "http://"+apiBind+"/events?server="+name+"&type=server_created,server_deleted")
+
+	maxConnections := 1
+	err = manager.Create(name, config.Server{
+		Bind: bind,
+		ConnectionOptions: config.ConnectionOptions{
+			MaxConnections: &maxConnections,
+		},
+		Discovery: &config.DiscoveryConfig{
+			Kind:                  "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectEvent(t, all, events.ServerCreated)
+	event := expectEvent(t, all, events.BackendAdded)
+	if event.Server != name || event.Backend == nil || event.Backend.Address() != backend {
+		t.Errorf("Unexpected backend event %+v", event)
+	}
+
+	// second connection is rejected while first is open
+	first, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	first.Write([]byte("hello"))
+	first.Read(make([]byte, 5))
+
+	second, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Close()
+
+	event = expectEvent(t, all, events.MaxConnections)
+	if event.Server != name || event.Message == "" {
+		t.Errorf("Unexpected max connections event %+v", event)
+	}
+
+	manager.Delete(name, false)
+	expectEvent(t, all, events.ServerDeleted)
+
+	expectEvent(t, servers, events.ServerCreated)
+	expectEvent(t, servers, events.ServerDeleted)
+}
+
+func TestEventsFilter(t *testing.T) {
+
+	target := events.Event{Type: events.BackendDead, Server: "web/api"}
+
+	matching := []events.Filter{
+		{},
+		{Servers: []string{"web"}},
+		{Servers: []string{"db", "web/api"}, Types: []string{events.BackendLive, events.BackendDead}},
+	}
+	for _, f := range matching {
+		if !f.Match(target) {
+			t.Errorf("Expected %+v to match %+v", f, target)
+		}
+	}
+
+	notMatching := []events.Filter{
+		{Servers: []string{"we"}},
+		{Servers: []string{"web/ap"}},
+		{Types: []string{events.BackendLive}},
+	}
+	for _, f := range notMatching {
+		if f.Match(target) {
+			t.Errorf("Expected %+v not to match %+v", f, target)
+		}
+	}
+}
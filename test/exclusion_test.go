@@ -0,0 +1,43 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/yyyar/gobetween/balance"
+	"github.com/yyyar/gobetween/core"
+)
+
+func TestExcludedBackendsNotElected(t *testing.T) {
+
+	backends := prepareBackends("127.0.0", 3)
+	for _, b := range backends {
+		b.Weight = 1
+	}
+
+	for _, kind := range []string{"roundrobin", "weight", "leastconn", "iphash", "iphash1", "leastbandwidth", "consistent"} {
+
+		balancer := balance.New(nil, kind, nil)
+
+		client := prepareClients(1)[0]
+		client.exclude = map[core.Target]bool{}
+
+		// exclude backends one by one as if connecting to them failed
+		for i := 0; i < len(backends); i++ {
+
+			b, err := balancer.Elect(client, backends)
+			if err != nil {
+				t.Fatalf("%s: %v", kind, err)
+			}
+
+			if client.exclude[b.Target] {
+				t.Fatalf("%s: excluded backend %s elected", kind, b.Address())
+			}
+
+			client.exclude[b.Target] = true
+		}
+
+		if _, err := balancer.Elect(client, backends); err == nil {
+			t.Fatalf("%s: expected error when all backends are excluded", kind)
+		}
+	}
+}
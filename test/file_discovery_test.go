@@ -0,0 +1,100 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/discovery"
+)
+
+func writeFileAtomic(t *testing.T, path string, content string) {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "backends")
+	writeFileAtomic(t, path, "# on-prem\n10.0.0.1:80 weight=2\n\n10.0.0.2:80 sni=b.example.com\n")
+
+	d := discovery.New("file", config.DiscoveryConfig{
+		Kind:       "file",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "0",
+		FileDiscoveryConfig: &config.FileDiscoveryConfig{
+			FilePath:   path,
+			FileFormat: "default",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 2 || backends[0].Weight != 2 || backends[1].Sni != "b.example.com" {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	// invalid file is rejected as a whole
+	writeFileAtomic(t, path, "10.0.0.3:80\nnot a backend\n")
+
+	select {
+	case backends := <-d.Discover():
+		t.Fatalf("Invalid file applied %v", backends)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// written in place too
+	if err := os.WriteFile(path, []byte("10.0.0.3:80\n10.0.0.4:80 priority=2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backends = nextBackends(t, d)
+	if len(backends) != 2 || backends[0].Host != "10.0.0.3" || backends[1].Priority != 2 {
+		t.Fatalf("Unexpected backends after change %v", backends)
+	}
+}
+
+func TestFileDiscoveryJson(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "backends.json")
+	writeFileAtomic(t, path, `[{"addr": "10.0.0.1", "port": 80, "weight": 3}, {"addr": "10.0.0.2", "port": "81"}]`)
+
+	d := discovery.New("file", config.DiscoveryConfig{
+		Kind:       "file",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "0",
+		FileDiscoveryConfig: &config.FileDiscoveryConfig{
+			FilePath:   path,
+			FileFormat: "json",
+		},
+		JsonDiscoveryConfig: &config.JsonDiscoveryConfig{
+			JsonHostPattern: "addr",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 2 || backends[0].Address() != "10.0.0.1:80" || backends[0].Weight != 3 ||
+		backends[1].Address() != "10.0.0.2:81" || backends[1].Weight != 1 {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	writeFileAtomic(t, path, `{"broken": `)
+	writeFileAtomic(t, path, `[]`)
+
+	backends = nextBackends(t, d)
+	if len(backends) != 0 {
+		t.Fatalf("Expected no backends, got %v", backends)
+	}
+}
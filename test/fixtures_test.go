@@ -0,0 +1,58 @@
+package test
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+/**
+ * Returns free local address to bind to
+ */
+func freeBind(t *testing.T) string {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().String()
+}
+
+/**
+ * Starts local tcp backend calling handle for every connection in its own goroutine.
+ * Connection is closed after handle returns, backend is closed when test ends
+ */
+func startBackend(t *testing.T, handle func(net.Conn)) string {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+/**
+ * Starts backend echoing everything it reads back to client
+ */
+func startEchoBackend(t *testing.T) string {
+	return startBackend(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+}
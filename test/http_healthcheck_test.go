@@ -0,0 +1,261 @@
+package test
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/healthcheck"
+)
+
+func TestHttpHealthcheck(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Host != "app.local":
+			w.WriteHeader(http.StatusMisdirectedRequest)
+		case r.URL.Path == "/health" && r.Header.Get("X-Check") == "1":
+			w.Write([]byte("status: OK"))
+		case r.URL.Path == "/redirect":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	target := core.Target{Host: host, Port: port}
+
+	base := config.HttpHealthcheckConfig{
+		HttpScheme:         "http",
+		HttpMethod:         "GET",
+		HttpPath:           "/health",
+		HttpHost:           "app.local",
+		HttpHeaders:        map[string]string{"X-Check": "1"},
+		HttpExpectedStatus: []string{"200"},
+	}
+
+	cases := []struct {
+		name     string
+		modify   func(c *config.HttpHealthcheckConfig)
+		expected healthcheck.HealthCheckStatus
+	}{
+		{"ok", func(c *config.HttpHealthcheckConfig) {}, healthcheck.Healthy},
+		{"body matches", func(c *config.HttpHealthcheckConfig) { c.HttpExpectedBody = "status: (OK|WARN)" }, healthcheck.Healthy},
+		{"body mismatch", func(c *config.HttpHealthcheckConfig) { c.HttpExpectedBody = "FAIL" }, healthcheck.Unhealthy},
+		{"wrong host", func(c *config.HttpHealthcheckConfig) { c.HttpHost = "" }, healthcheck.Unhealthy},
+		{"unexpected status", func(c *config.HttpHealthcheckConfig) { c.HttpPath = "/missing" }, healthcheck.Unhealthy},
+		{"status range", func(c *config.HttpHealthcheckConfig) {
+			c.HttpPath = "/missing"
+			c.HttpExpectedStatus = []string{"200", "500-599"}
+		}, healthcheck.Healthy},
+		{"redirect not followed", func(c *config.HttpHealthcheckConfig) { c.HttpPath = "/redirect" }, healthcheck.Unhealthy},
+		{"redirect followed", func(c *config.HttpHealthcheckConfig) {
+			c.HttpPath = "/redirect"
+			c.HttpFollowRedirects = true
+		}, healthcheck.Healthy},
+	}
+
+	for _, c := range cases {
+
+		httpCfg := base
+		c.modify(&httpCfg)
+
+		status := runHealthcheck(t, target, config.HealthcheckConfig{
+			Kind:                  "http",
+			Interval:              "1s",
+			Timeout:               "1s",
+			Passes:                1,
+			Fails:                 1,
+			HttpHealthcheckConfig: &httpCfg,
+		})
+
+		if status != c.expected {
+			t.Errorf("%s: expected status %v, got %v", c.name, c.expected, status)
+		}
+	}
+}
+
+func TestHttpHealthcheckIpv6(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("ipv6 is not available: ", err)
+	}
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "[::1]:"+port {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	// hosts are checked both as they come from discovery bracketed and plain
+	for _, host := range []string{"[::1]", "::1"} {
+		status := runHealthcheck(t, core.Target{Host: host, Port: port}, config.HealthcheckConfig{
+			Kind:     "http",
+			Interval: "1s",
+			Timeout:  "1s",
+			Passes:   1,
+			Fails:    1,
+			HttpHealthcheckConfig: &config.HttpHealthcheckConfig{
+				HttpScheme:         "http",
+				HttpMethod:         "GET",
+				HttpPath:           "/",
+				HttpExpectedStatus: []string{"200"},
+			},
+		})
+
+		if status != healthcheck.Healthy {
+			t.Errorf("%s: expected status %v, got %v", host, healthcheck.Healthy, status)
+		}
+	}
+}
+
+func TestHttpsHealthcheck(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	target := core.Target{Host: host, Port: port}
+
+	for _, skipVerify := range []bool{false, true} {
+
+		status := runHealthcheck(t, target, config.HealthcheckConfig{
+			Kind:     "http",
+			Interval: "1s",
+			Timeout:  "1s",
+			Passes:   1,
+			Fails:    1,
+			HttpHealthcheckConfig: &config.HttpHealthcheckConfig{
+				HttpScheme:         "https",
+				HttpMethod:         "GET",
+				HttpPath:           "/",
+				HttpExpectedStatus: []string{"200"},
+				HttpTlsSkipVerify:  skipVerify,
+			},
+		})
+
+		// test server certificate is self-signed
+		expected := healthcheck.Unhealthy
+		if skipVerify {
+			expected = healthcheck.Healthy
+		}
+
+		if status != expected {
+			t.Errorf("skip verify %v: expected status %v, got %v", skipVerify, expected, status)
+		}
+	}
+}
+
+func TestHttpsHealthcheckRootCa(t *testing.T) {
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	target := core.Target{Host: host, Port: port}
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.HealthcheckConfig{
+		Kind:     "http",
+		Interval: "1s",
+		Timeout:  "1s",
+		Passes:   1,
+		Fails:    1,
+		HttpHealthcheckConfig: &config.HttpHealthcheckConfig{
+			HttpScheme:            "https",
+			HttpMethod:            "GET",
+			HttpPath:              "/",
+			HttpExpectedStatus:    []string{"200"},
+			HttpExpectedBody:      "OK",
+			HttpTlsRootCaCertPath: caPath,
+		},
+	}
+
+	h := healthcheck.New(cfg.Kind, cfg)
+
+	// certificates are read once, when healthcheck is created
+	if err := os.Remove(caPath); err != nil {
+		t.Fatal(err)
+	}
+
+	h.Start()
+	defer h.Stop()
+
+	h.In <- []core.Target{target}
+
+	select {
+	case result := <-h.Out:
+		if result.Status != healthcheck.Healthy {
+			t.Errorf("Expected healthy backend verified with root ca, got %v", result.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for healthcheck result")
+	}
+
+	// unreadable root ca fails checks
+	if status := runHealthcheck(t, target, cfg); status != healthcheck.Unhealthy {
+		t.Errorf("Expected unhealthy backend with missing root ca, got %v", status)
+	}
+}
+
+func TestHttpStatusMatching(t *testing.T) {
+
+	if !healthcheck.MatchesHttpStatus(204, []string{"200-299"}) {
+		t.Error("204 should match 200-299")
+	}
+
+	if healthcheck.MatchesHttpStatus(301, []string{"200", "400-499"}) {
+		t.Error("301 should not match 200, 400-499")
+	}
+
+	for _, invalid := range []string{"abc", "99", "300-200", "200-600"} {
+		if _, _, err := healthcheck.ParseHttpStatus(invalid); err == nil {
+			t.Errorf("Expected error parsing %s", invalid)
+		}
+	}
+}
+
+/**
+ * Run healthcheck on target until first result
+ */
+func runHealthcheck(t *testing.T, target core.Target, cfg config.HealthcheckConfig) healthcheck.HealthCheckStatus {
+
+	h := healthcheck.New(cfg.Kind, cfg)
+	h.Start()
+	defer h.Stop()
+
+	h.In <- []core.Target{target}
+
+	select {
+	case result := <-h.Out:
+		return result.Status
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timeout waiting for healthcheck result")
+	}
+
+	return healthcheck.Initial
+}
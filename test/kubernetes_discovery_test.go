@@ -0,0 +1,170 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/discovery"
+)
+
+const kubernetesSlice = `{
+	"metadata": {"name": "app-abc", "resourceVersion": "%s"},
+	"endpoints": [%s],
+	"ports": [{"name": "metrics", "port": 9090}, {"name": "http", "port": 8080}]
+}`
+
+func kubernetesSliceEndpoint(ip, pod string, ready bool) string {
+	return fmt.Sprintf(`{"addresses": ["%s"], "conditions": {"ready": %v},
+		"targetRef": {"kind": "Pod", "name": "%s", "uid": "uid-%s"}}`, ip, ready, pod, pod)
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+
+	events := make(chan string)
+
+	var podAWeight atomic.Int32
+	podAWeight.Store(5)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+
+		case "/api/v1/namespaces/apps/pods/pod-a":
+			fmt.Fprintf(w, `{"metadata": {"name": "pod-a", "annotations": {
+				"gobetween.io/weight": "%d", "gobetween.io/priority": "2",
+				"gobetween.io/sni": "a.example.com", "gobetween.io/max-connections": "10"}}}`, podAWeight.Load())
+
+		case "/api/v1/namespaces/apps/pods/pod-b", "/api/v1/namespaces/apps/pods/pod-c":
+			w.Write([]byte(`{"metadata": {}}`))
+
+		case "/apis/discovery.k8s.io/v1/namespaces/apps/endpointslices":
+
+			if r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=app" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			if r.URL.Query().Get("watch") == "" {
+				slice := fmt.Sprintf(kubernetesSlice, "1",
+					kubernetesSliceEndpoint("10.0.0.1", "pod-a", true)+","+
+						kubernetesSliceEndpoint("10.0.0.2", "pod-b", false))
+				fmt.Fprintf(w, `{"metadata": {"resourceVersion": "1"}, "items": [%s]}`, slice)
+				return
+			}
+
+			if r.URL.Query().Get("resourceVersion") != "1" {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case event := <-events:
+					w.Write([]byte(event + "\n"))
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	err := os.WriteFile(kubeconfig, []byte(`
+apiVersion: v1
+kind: Config
+current-context: test
+clusters:
+- name: test
+  cluster:
+    server: `+server.URL+`
+users:
+- name: test
+  user:
+    token: secret
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+    namespace: apps
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := discovery.New("kubernetes", config.DiscoveryConfig{
+		Kind:       "kubernetes",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "2s",
+		KubernetesDiscoveryConfig: &config.KubernetesDiscoveryConfig{
+			KubernetesService:          "app",
+			KubernetesPortName:         "http",
+			KubernetesResource:         "endpointslices",
+			KubernetesKubeconfig:       kubeconfig,
+			KubernetesAnnotationPrefix: "gobetween.io/",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	next := func() []core.Backend {
+		select {
+		case backends := <-d.Discover():
+			return backends
+		case <-time.After(5 * time.Second):
+			t.Fatal("No backends discovered")
+			return nil
+		}
+	}
+
+	// not ready endpoint is skipped, annotations are applied
+	backends := next()
+	if len(backends) != 1 {
+		t.Fatalf("Expected 1 backend, got %v", backends)
+	}
+
+	b := backends[0]
+	if b.Host != "10.0.0.1" || b.Port != "8080" || b.Weight != 5 || b.Priority != 2 ||
+		b.Sni != "a.example.com" || b.MaxConnections != 10 {
+		t.Fatalf("Unexpected backend %v sni %s", b, b.Sni)
+	}
+
+	// changes are streamed, annotations of pods of changed slice are read again
+	podAWeight.Store(7)
+	events <- `{"type": "MODIFIED", "object": ` + fmt.Sprintf(kubernetesSlice, "2",
+		kubernetesSliceEndpoint("10.0.0.1", "pod-a", true)+","+
+			kubernetesSliceEndpoint("10.0.0.2", "pod-b", true)+","+
+			kubernetesSliceEndpoint("10.0.0.3", "pod-c", true)) + `}`
+
+	backends = next()
+	if len(backends) != 3 || backends[0].Weight != 7 || backends[1].Host != "10.0.0.2" || backends[1].Weight != 1 || backends[2].Host != "10.0.0.3" {
+		t.Fatalf("Unexpected backends after change %v", backends)
+	}
+
+	events <- `{"type": "DELETED", "object": ` + fmt.Sprintf(kubernetesSlice, "3", "") + `}`
+
+	backends = next()
+	if len(backends) != 0 {
+		t.Fatalf("Expected no backends after delete, got %v", backends)
+	}
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/metrics"
+)
+
+/**
+ * Returns metrics exposed on bind in text format
+ */
+func scrapeMetrics(t *testing.T, bind string) string {
+
+	for i := 0; ; i++ {
+		resp, err := http.Get("http://" + bind + "/metrics")
+		if err == nil {
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(b)
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+/**
+ * Returns name with unique suffix
+ */
+func uniqueName(name string) string {
+	return name + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func TestMetricsHistogramsAndCounters(t *testing.T) {
+
+	metricsBind := freeBind(t)
+	metrics.Start(config.MetricsConfig{Enabled: true, Bind: metricsBind})
+	defer metrics.Start(config.MetricsConfig{})
+
+	manager.Initialize(config.Config{})
+
+	backend := startEchoBackend(t)
+	refused := freeBind(t)
+
+	// counters of deleted servers may outlive them, so names are unique per run
+	echo, refusing, denied := uniqueName("metrics-echo"), uniqueName("metrics-refused"), uniqueName("metrics-denied")
+
+	servers := map[string]config.Server{
+		echo: {
+			Bind: freeBind(t),
+			Discovery: &config.DiscoveryConfig{
+				Kind:                  "static",
+				StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+			},
+		},
+		refusing: {
+			Bind: freeBind(t),
+			Discovery: &config.DiscoveryConfig{
+				Kind:                  "static",
+				StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{refused}},
+			},
+		},
+		denied: {
+			Bind:   freeBind(t),
+			Access: &config.AccessConfig{Default: "deny"},
+			Discovery: &config.DiscoveryConfig{
+				Kind:                  "static",
+				StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+			},
+		},
+	}
+
+	for name, cfg := range servers {
+		if err := manager.Create(name, cfg); err != nil {
+			t.Fatal(err)
+		}
+		defer manager.Delete(name, false)
+	}
+
+	for name := range servers {
+		for i := 0; ; i++ {
+			if backends, _ := manager.Backends(name, ""); len(backends) == 1 {
+				break
+			}
+			if i == 50 {
+				t.Fatal("No backends discovered for ", name)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	// proxied connection
+	conn, err := net.Dial("tcp", servers[echo].Bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	conn.Close()
+
+	// connections closed by server without proxying
+	for _, name := range []string{refusing, denied} {
+		conn, err := net.Dial("tcp", servers[name].Bind)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+
+	refusedHost, refusedPort, _ := net.SplitHostPort(refused)
+	backendHost, backendPort, _ := net.SplitHostPort(backend)
+	echoLabels := `host="` + backendHost + `",port="` + backendPort + `",server="` + echo + `"`
+
+	expected := []string{
+		`gobetween_server_connections_accepted_total{server="` + echo + `"} 1`,
+		`gobetween_server_connections_accepted_total{server="` + denied + `"} 1`,
+		`gobetween_server_connections_rejected_total{reason="access_denied",server="` + denied + `"} 1`,
+		`gobetween_backend_dial_failures_total{host="` + refusedHost + `",port="` + refusedPort + `",reason="refused",server="` + refusing + `"} 1`,
+		`gobetween_server_elect_duration_seconds_count{server="` + echo + `"} 1`,
+		`gobetween_backend_connect_duration_seconds_count{` + echoLabels + `} 1`,
+		`gobetween_backend_connection_duration_seconds_count{` + echoLabels + `} 1`,
+		`gobetween_backend_connection_rx_bytes_sum{` + echoLabels + `} 5`,
+		`gobetween_backend_connection_tx_bytes_sum{` + echoLabels + `} 5`,
+		`gobetween_server_rx_bytes_total{server="` + echo + `"} 5`,
+		`gobetween_server_tx_bytes_total{server="` + echo + `"} 5`,
+		`gobetween_backend_rx_bytes_total{` + echoLabels + `} 5`,
+		`gobetween_backend_tx_bytes_total{` + echoLabels + `} 5`,
+	}
+
+	// connection end and bandwidth stats are reported asynchronously
+	var text string
+	for i := 0; i < 50; i++ {
+		text = scrapeMetrics(t, metricsBind)
+		found := true
+		for _, line := range expected {
+			found = found && strings.Contains(text, line+"\n")
+		}
+		if found {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Metric %s not found", line)
+		}
+	}
+}
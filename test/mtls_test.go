@@ -0,0 +1,348 @@
+package test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/server/modules/access"
+	"github.com/yyyar/gobetween/utils/proxyprotocol"
+)
+
+/**
+ * Certificate authority issuing client certificates
+ */
+type testCa struct {
+	crt *x509.Certificate
+	key *ecdsa.PrivateKey
+	pem []byte
+}
+
+func newTestCa(t *testing.T, cn string) *testCa {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCa{crt: crt, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+/**
+ * Issue client certificate with serial number for dns names
+ */
+func (this *testCa) issue(t *testing.T, serial int64, cn string, dnsNames ...string) tls.Certificate {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, this.crt, &key.PublicKey, this.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+/**
+ * Make pem encoded revocation list of serial numbers
+ */
+func (this *testCa) revoke(t *testing.T, serials ...int64) []byte {
+
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+
+	for _, serial := range serials {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now(),
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, this.crt, this.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+/**
+ * Starts backend replying with client subject and san got in proxy_protocol v2 header
+ */
+func startIdentityBackend(t *testing.T) string {
+	return startBackend(t, func(conn net.Conn) {
+		h, err := proxyproto.Read(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+
+		tlvs, _ := h.TLVs()
+		identity := map[proxyproto.PP2Type]string{}
+		for _, tlv := range tlvs {
+			identity[tlv.Type] = string(tlv.Value)
+		}
+
+		conn.Write([]byte(identity[proxyprotocol.PP2_TYPE_CLIENT_SUBJECT] + " " + identity[proxyprotocol.PP2_TYPE_CLIENT_SAN]))
+	})
+}
+
+/**
+ * Connect to bind with client certificates, returns what backend replied
+ */
+func mtlsRequest(bind string, certificates ...tls.Certificate) string {
+
+	conn, err := tls.Dial("tcp", bind, &tls.Config{InsecureSkipVerify: true, Certificates: certificates})
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reply, _ := io.ReadAll(conn)
+	return string(reply)
+}
+
+func TestMtls(t *testing.T) {
+
+	dir := t.TempDir()
+
+	certPath, keyPath := writeCertificate(t, dir, "server", "server")
+
+	ca := newTestCa(t, "clients ca")
+	caPath := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caPath, ca.pem, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	crlPath := filepath.Join(dir, "ca.crl")
+	if err := os.WriteFile(crlPath, ca.revoke(t, 3), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bind := freeBind(t)
+
+	manager.Initialize(config.Config{})
+
+	err := manager.Create("mtls", config.Server{
+		Bind:     bind,
+		Protocol: "tls",
+		Discovery: &config.DiscoveryConfig{
+			Kind: "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+				StaticList: []string{startIdentityBackend(t)},
+			},
+		},
+		Tls: &config.Tls{
+			CertPath:      certPath,
+			KeyPath:       keyPath,
+			ClientCaPath:  caPath,
+			ClientCrlPath: crlPath,
+			ClientAllowed: []string{"*.clients.example.com"},
+		},
+		Access: &config.AccessConfig{
+			Default: "allow",
+			Rules:   []string{"deny client b.clients.example.com"},
+		},
+		ProxyProtocol: &config.ProxyProtocol{Version: "2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete("mtls", false)
+
+	for i := 0; ; i++ {
+		if backends, _ := manager.Backends("mtls", ""); len(backends) == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("No backends discovered")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if reply := mtlsRequest(bind, ca.issue(t, 2, "client-a", "a.clients.example.com")); reply != "CN=client-a DNS:a.clients.example.com" {
+		t.Errorf("Unexpected client identity sent to backend: %q", reply)
+	}
+
+	rejected := map[string][]tls.Certificate{
+		"no certificate":     nil,
+		"revoked":            {ca.issue(t, 3, "client-r", "r.clients.example.com")},
+		"not allowed name":   {ca.issue(t, 4, "client-o", "other.example.com")},
+		"denied by access":   {ca.issue(t, 5, "client-b", "b.clients.example.com")},
+		"issued by other ca": {newTestCa(t, "other ca").issue(t, 2, "client-a", "a.clients.example.com")},
+	}
+
+	for name, certificates := range rejected {
+		if reply := mtlsRequest(bind, certificates...); reply != "" {
+			t.Errorf("Client with %s should be rejected, got %q", name, reply)
+		}
+	}
+
+	certificates, _ := manager.Certificates("mtls")
+	if len(certificates) != 2 || certificates[1].Type != "client_ca" {
+		t.Errorf("Unexpected certificates %+v", certificates)
+	}
+}
+
+func TestMtlsDeniedIpRejectedBeforeHandshake(t *testing.T) {
+
+	dir := t.TempDir()
+
+	certPath, keyPath := writeCertificate(t, dir, "server", "server")
+
+	ca := newTestCa(t, "clients ca")
+	caPath := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caPath, ca.pem, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.Initialize(config.Config{})
+
+	name := uniqueName("mtls-deny")
+	bind := freeBind(t)
+
+	err := manager.Create(name, config.Server{
+		Bind:     bind,
+		Protocol: "tls",
+		Discovery: &config.DiscoveryConfig{
+			Kind: "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+				StaticList: []string{startIdentityBackend(t)},
+			},
+		},
+		Tls: &config.Tls{
+			CertPath:     certPath,
+			KeyPath:      keyPath,
+			ClientCaPath: caPath,
+		},
+		Access: &config.AccessConfig{
+			Default: "allow",
+			Rules:   []string{"deny 127.0.0.0/8"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete(name, false)
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// connection is closed without waiting for ClientHello
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Denied client was not rejected before handshake: %v", err)
+	}
+}
+
+func TestAccessMayAllowBeforeIdentity(t *testing.T) {
+
+	ip := net.ParseIP("10.0.0.1")
+
+	cases := []struct {
+		rules    []string
+		mayAllow bool
+	}{
+		{[]string{"deny 10.0.0.0/8"}, false},
+		{[]string{"allow 10.0.0.1", "deny 10.0.0.0/8"}, true},
+		{[]string{"deny client a.example.com", "deny 10.0.0.0/8"}, false},
+		{[]string{"allow client a.example.com", "deny 10.0.0.0/8"}, true},
+		{[]string{"deny 10.0.0.0/8", "allow client a.example.com"}, false},
+	}
+
+	for _, c := range cases {
+		a, err := access.NewAccess(&config.AccessConfig{Default: "allow", Rules: c.rules})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.MayAllow(&ip) != c.mayAllow {
+			t.Errorf("Rules %q: expected may allow %v", c.rules, c.mayAllow)
+		}
+	}
+}
+
+func TestMtlsValidation(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	discovery := &config.DiscoveryConfig{
+		Kind: "static",
+		StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+			StaticList: []string{"127.0.0.1:1"},
+		},
+	}
+
+	invalid := map[string]*config.Tls{
+		"verify without ca":      {CertPath: "a.crt", KeyPath: "a.key", ClientVerify: "require"},
+		"unknown verify mode":    {CertPath: "a.crt", KeyPath: "a.key", ClientCaPath: "ca.crt", ClientVerify: "optional"},
+		"allowed without verify": {CertPath: "a.crt", KeyPath: "a.key", ClientVerify: "none", ClientAllowed: []string{"a"}},
+		"invalid allowed":        {CertPath: "a.crt", KeyPath: "a.key", ClientCaPath: "ca.crt", ClientAllowed: []string{"~("}},
+	}
+
+	for name, tlsC := range invalid {
+		err := manager.Create("invalid-mtls", config.Server{
+			Bind:      "127.0.0.1:0",
+			Protocol:  "tls",
+			Discovery: discovery,
+			Tls:       tlsC,
+		})
+		if err == nil {
+			manager.Delete("invalid-mtls", false)
+			t.Errorf("Tls with %s should be rejected", name)
+		}
+	}
+}
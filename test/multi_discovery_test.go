@@ -0,0 +1,141 @@
+package test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/discovery"
+	"github.com/yyyar/gobetween/utils/codec"
+)
+
+func TestMultiDiscoveryConfig(t *testing.T) {
+
+	var cfg config.Config
+	err := codec.Decode(`
+[servers.sample]
+bind = "localhost:3000"
+
+  [servers.sample.discovery]
+  kind = "multi"
+
+    [[servers.sample.discovery.multi_sources]]
+    kind = "static"
+    static_list = ["localhost:8000"]
+    priority = 1
+
+    [[servers.sample.discovery.multi_sources]]
+    kind = "consul"
+    failpolicy = "setempty"
+    consul_host = "localhost:8500"
+    consul_service_name = "app"
+    priority = 2
+    weight = 5
+`, &cfg, "toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := cfg.Servers["sample"].Discovery.MultiSources
+	if len(sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %v", sources)
+	}
+
+	if sources[0].Kind != "static" || len(sources[0].StaticList) != 1 || *sources[0].Priority != 1 || sources[0].Weight != nil {
+		t.Fatalf("Unexpected first source %+v", sources[0])
+	}
+
+	if sources[1].Kind != "consul" || sources[1].Failpolicy != "setempty" || sources[1].ConsulServiceName != "app" ||
+		*sources[1].Priority != 2 || *sources[1].Weight != 5 {
+		t.Fatalf("Unexpected second source %+v", sources[1])
+	}
+}
+
+func TestMultiDiscovery(t *testing.T) {
+
+	// source that is always failing
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	priority, weight := 2, 3
+
+	d := discovery.New("multi", config.DiscoveryConfig{
+		Kind:       "multi",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "0",
+		MultiDiscoveryConfig: &config.MultiDiscoveryConfig{
+			MultiSources: []config.MultiDiscoverySource{
+				{
+					DiscoveryConfig: config.DiscoveryConfig{
+						Kind:       "static",
+						Failpolicy: "keeplast",
+						Interval:   "0",
+						Timeout:    "0",
+						StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+							StaticList: []string{"10.0.0.1:80", "10.0.0.2:80 weight=5", "10.0.0.3:80", "10.0.0.4:80 weight=1 priority=1"},
+						},
+					},
+					Priority: &priority,
+					Weight:   &weight,
+				},
+				{
+					DiscoveryConfig: config.DiscoveryConfig{
+						Kind:       "plaintext",
+						Failpolicy: "setempty",
+						Interval:   "1s",
+						Timeout:    "1s",
+						PlaintextDiscoveryConfig: &config.PlaintextDiscoveryConfig{
+							PlaintextEndpoint: server.URL,
+						},
+					},
+				},
+				{
+					DiscoveryConfig: config.DiscoveryConfig{
+						Kind:       "static",
+						Failpolicy: "keeplast",
+						Interval:   "0",
+						Timeout:    "0",
+						StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+							StaticList: []string{"10.0.0.3:80 priority=7", "10.0.1.1:80"},
+						},
+					},
+				},
+			},
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	// every source reports once, failing one with empty set
+	nextBackends(t, d)
+	nextBackends(t, d)
+
+	backends := nextBackends(t, d)
+	if len(backends) != 5 {
+		t.Fatalf("Expected 5 backends, got %v", backends)
+	}
+
+	expected := []struct {
+		address  string
+		priority int
+		weight   int
+	}{
+		{"10.0.0.1:80", 2, 3},
+		{"10.0.0.2:80", 2, 5},
+		{"10.0.0.3:80", 2, 3},
+		// explicit values of 1 are not replaced by source defaults
+		{"10.0.0.4:80", 1, 1},
+		{"10.0.1.1:80", 1, 1},
+	}
+
+	for i, e := range expected {
+		b := backends[i]
+		if b.Address() != e.address || b.Priority != e.priority || b.Weight != e.weight {
+			t.Fatalf("Expected %v, got %v", e, b)
+		}
+	}
+}
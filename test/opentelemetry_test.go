@@ -0,0 +1,206 @@
+package test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/metrics"
+	"github.com/yyyar/gobetween/telemetry"
+)
+
+/**
+ * In-process OTLP collector remembering exported spans and metric names
+ */
+type testCollector struct {
+	collectortrace.UnimplementedTraceServiceServer
+	collectormetrics.UnimplementedMetricsServiceServer
+
+	mu      sync.Mutex
+	spans   []*tracepb.Span
+	metrics map[string]bool
+}
+
+func (this *testCollector) Export(ctx context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			this.spans = append(this.spans, ss.Spans...)
+		}
+	}
+
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+/**
+ * Metrics service has the same Export method name, so it's served by wrapper
+ */
+type testMetricsCollector struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	*testCollector
+}
+
+func (this testMetricsCollector) Export(ctx context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				this.metrics[m.Name] = true
+			}
+		}
+	}
+
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+/**
+ * Starts in-process OTLP gRPC collector, returns it and its address
+ */
+func startTestCollector(t *testing.T) (*testCollector, string) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector := &testCollector{metrics: map[string]bool{}}
+
+	server := grpc.NewServer()
+	collectortrace.RegisterTraceServiceServer(server, collector)
+	collectormetrics.RegisterMetricsServiceServer(server, testMetricsCollector{testCollector: collector})
+
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	return collector, l.Addr().String()
+}
+
+func TestOpenTelemetryExport(t *testing.T) {
+
+	collector, endpoint := startTestCollector(t)
+
+	err := telemetry.Start(&config.OpenTelemetryConfig{
+		Endpoint:        endpoint,
+		Insecure:        true,
+		Metrics:         true,
+		MetricsInterval: "100ms",
+		Traces:          true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer metrics.Start(config.MetricsConfig{})
+	defer telemetry.Stop()
+
+	manager.Initialize(config.Config{})
+
+	name := uniqueName("otel")
+	bind := freeBind(t)
+	backend := startEchoBackend(t)
+
+	err = manager.Create(name, config.Server{
+		Bind: bind,
+		Discovery: &config.DiscoveryConfig{
+			Kind:                  "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete(name, false)
+
+	for i := 0; ; i++ {
+		if backends, _ := manager.Backends(name, ""); len(backends) == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("No backends discovered")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	conn.Close()
+
+	// wait for connection to end, then flush
+	time.Sleep(500 * time.Millisecond)
+	telemetry.Stop()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	spans := map[string]*tracepb.Span{}
+	for _, span := range collector.spans {
+		spans[span.Name] = span
+	}
+
+	connection, ok := spans["connection"]
+	if !ok {
+		t.Fatalf("No connection span exported, got %v", spans)
+	}
+
+	attributes := map[string]string{}
+	for _, kv := range connection.Attributes {
+		attributes[kv.Key] = kv.Value.GetStringValue()
+	}
+	if attributes["gobetween.server"] != name || attributes["gobetween.backend"] != backend ||
+		attributes["gobetween.termination"] != "client_closed" {
+		t.Errorf("Unexpected connection span attributes %v", attributes)
+	}
+
+	for _, phase := range []string{telemetry.PhaseElect, telemetry.PhaseDial, telemetry.PhaseProxy} {
+		span, ok := spans[phase]
+		if !ok {
+			t.Errorf("No %s span exported", phase)
+			continue
+		}
+		if string(span.ParentSpanId) != string(connection.SpanId) || string(span.TraceId) != string(connection.TraceId) {
+			t.Errorf("Span %s is not child of connection span", phase)
+		}
+	}
+
+	for _, metric := range []string{"gobetween_server_connections_accepted_total", "gobetween_backend_connect_duration_seconds"} {
+		if !collector.metrics[metric] {
+			t.Errorf("Metric %s not exported, got %v", metric, collector.metrics)
+		}
+	}
+}
+
+func TestOpenTelemetryValidation(t *testing.T) {
+
+	ratio := 2.0
+
+	invalid := []config.OpenTelemetryConfig{
+		{},
+		{Traces: true, Protocol: "thrift"},
+		{Traces: true, TracesSampleRatio: &ratio},
+		{Metrics: true, MetricsInterval: "-1s"},
+	}
+
+	for _, cfg := range invalid {
+		if err := telemetry.Start(&cfg); err == nil {
+			telemetry.Stop()
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
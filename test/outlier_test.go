@@ -0,0 +1,154 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/server/scheduler"
+)
+
+func TestOutlierConsecutiveFailures(t *testing.T) {
+
+	detector := scheduler.NewOutlierDetector(&config.OutlierDetection{
+		ConsecutiveFailures: 3,
+		Window:              "10s",
+		MinRequests:         10,
+		EjectionTime:        "10s",
+		MaxEjectionTime:     "35s",
+	})
+
+	target := core.Target{Host: "127.0.0.1", Port: "8000"}
+	now := time.Now()
+
+	// success resets consecutive failures
+	detector.Failure(target, 1, now)
+	detector.Failure(target, 1, now)
+	detector.Success(target, now)
+
+	if detector.Failure(target, 1, now) || detector.Failure(target, 1, now) {
+		t.Fatal("Ejected before consecutive_failures reached")
+	}
+
+	if !detector.Failure(target, 1, now) {
+		t.Fatal("Not ejected after consecutive_failures")
+	}
+
+	// exponential backoff: 10s, 20s, then capped to 35s
+	for _, ejection := range []time.Duration{10 * time.Second, 20 * time.Second, 35 * time.Second} {
+
+		if len(detector.Expired(now.Add(ejection-time.Second))) != 0 {
+			t.Fatalf("Re-admitted before %v", ejection)
+		}
+
+		now = now.Add(ejection)
+		if expired := detector.Expired(now); len(expired) != 1 || expired[0] != target {
+			t.Fatalf("Not re-admitted after %v", ejection)
+		}
+
+		for i := 0; i < 3; i++ {
+			detector.Failure(target, 1, now)
+		}
+	}
+}
+
+func TestOutlierFailureRatio(t *testing.T) {
+
+	detector := scheduler.NewOutlierDetector(&config.OutlierDetection{
+		FailureRatio:    0.5,
+		Window:          "10s",
+		MinRequests:     10,
+		EjectionTime:    "30s",
+		MaxEjectionTime: "5m",
+	})
+
+	target := core.Target{Host: "127.0.0.1", Port: "8000"}
+	now := time.Now()
+
+	for i := 0; i < 4; i++ {
+		detector.Success(target, now)
+		if detector.Failure(target, 1, now) {
+			t.Fatal("Ejected before min_requests reached")
+		}
+	}
+
+	detector.Success(target, now)
+	if !detector.Failure(target, 1, now) {
+		t.Fatal("Not ejected after failure_ratio reached")
+	}
+
+	// results of previous window are not counted
+	other := core.Target{Host: "127.0.0.1", Port: "8001"}
+	for i := 0; i < 9; i++ {
+		detector.Success(other, now)
+	}
+	now = now.Add(11 * time.Second)
+	for i := 0; i < 9; i++ {
+		if detector.Failure(other, 2, now) {
+			t.Fatal("Ejected before min_requests reached in new window")
+		}
+	}
+}
+
+func TestOutlierDisabled(t *testing.T) {
+
+	detector := scheduler.NewOutlierDetector(nil)
+	target := core.Target{Host: "127.0.0.1", Port: "8000"}
+
+	for i := 0; i < 100; i++ {
+		if detector.Failure(target, 1, time.Now()) {
+			t.Fatal("Disabled detector ejected backend")
+		}
+	}
+}
+
+func TestOutlierMaxEjectionPercent(t *testing.T) {
+
+	detector := scheduler.NewOutlierDetector(&config.OutlierDetection{
+		ConsecutiveFailures: 1,
+		Window:              "10s",
+		MinRequests:         10,
+		EjectionTime:        "10s",
+		MaxEjectionTime:     "10s",
+		MaxEjectionPercent:  50,
+	})
+
+	now := time.Now()
+	targets := []core.Target{
+		{Host: "127.0.0.1", Port: "8000"},
+		{Host: "127.0.0.1", Port: "8001"},
+		{Host: "127.0.0.1", Port: "8002"},
+		{Host: "127.0.0.1", Port: "8003"},
+	}
+
+	for i, target := range targets {
+		if ejected := detector.Failure(target, len(targets), now); ejected != (i < 2) {
+			t.Fatalf("Backend %d of %d ejected %v with max_ejection_percent 50", i+1, len(targets), ejected)
+		}
+	}
+
+	// ejected once others are re-admitted
+	now = now.Add(10 * time.Second)
+	if len(detector.Expired(now)) != 2 {
+		t.Fatal("Ejected backends not re-admitted")
+	}
+
+	if !detector.Failure(targets[2], len(targets), now) {
+		t.Fatal("Backend not ejected after others re-admitted")
+	}
+
+	// single backend may be ejected even if it's over percent
+	single := scheduler.NewOutlierDetector(&config.OutlierDetection{
+		ConsecutiveFailures: 1,
+		Window:              "10s",
+		MinRequests:         10,
+		EjectionTime:        "10s",
+		MaxEjectionTime:     "10s",
+		MaxEjectionPercent:  10,
+	})
+
+	if !single.Failure(targets[0], 1, now) {
+		t.Fatal("Single backend not ejected")
+	}
+}
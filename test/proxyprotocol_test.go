@@ -0,0 +1,326 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/utils/proxyprotocol"
+)
+
+func TestProxyProtocolV2Tcp(t *testing.T) {
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	if err := proxyprotocol.SendProxyProtocolV2(server, &writerConn{Conn: server, w: buf}, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := proxyproto.Read(bufio.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Version != 2 || h.TransportProtocol != proxyproto.TCPv4 {
+		t.Fatalf("Unexpected header version %d, protocol %v", h.Version, h.TransportProtocol)
+	}
+
+	if h.SourceAddr.String() != client.LocalAddr().String() {
+		t.Fatalf("Expected source %s, got %s", client.LocalAddr(), h.SourceAddr)
+	}
+
+	tlvs, err := h.TLVs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tlvs) != 1 || tlvs[0].Type != proxyproto.PP2_TYPE_AUTHORITY || string(tlvs[0].Value) != "example.com" {
+		t.Fatalf("Unexpected TLVs %v", tlvs)
+	}
+}
+
+func TestProxyProtocolV2Udp(t *testing.T) {
+
+	client := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	local := &net.UDPAddr{IP: net.IPv6unspecified, Port: 53}
+
+	header, err := proxyprotocol.MakeProxyProtocolV2Header(client, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := proxyproto.Read(bufio.NewReader(bytes.NewReader(append(header, []byte("payload")...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.TransportProtocol != proxyproto.UDPv4 {
+		t.Fatalf("Expected UDPv4, got %v", h.TransportProtocol)
+	}
+
+	if h.SourceAddr.String() != client.String() {
+		t.Fatalf("Expected source %s, got %s", client, h.SourceAddr)
+	}
+}
+
+func TestProxyProtocolV2UdpServerOnUnspecifiedAddress(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	_, port, _ := net.SplitHostPort(freeBind(t))
+	name := uniqueName("udp-proxy-protocol")
+
+	err = manager.Create(name, config.Server{
+		Bind:          "0.0.0.0:" + port,
+		Protocol:      "udp",
+		Udp:           &config.Udp{MaxResponses: 1},
+		ProxyProtocol: &config.ProxyProtocol{Version: "2"},
+		Discovery: &config.DiscoveryConfig{
+			Kind:                  "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend.LocalAddr().String()}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete(name, false)
+
+	waitBackends(t, name, 1)
+
+	client, err := net.Dial("udp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("payload"))
+
+	backend.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := backend.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := proxyproto.Read(bufio.NewReader(bytes.NewReader(buf[:n])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// destination is address client sent packet to, not the one server listens on
+	if h.DestinationAddr.String() != "127.0.0.1:"+port {
+		t.Errorf("Expected destination 127.0.0.1:%s, got %s", port, h.DestinationAddr)
+	}
+
+	if h.SourceAddr.String() != client.LocalAddr().String() {
+		t.Errorf("Expected source %s, got %s", client.LocalAddr(), h.SourceAddr)
+	}
+}
+
+func TestProxyProtocolAccept(t *testing.T) {
+
+	acceptor, err := proxyprotocol.NewAcceptor(&config.AcceptProxyProtocol{
+		TrustedNetworks: []string{"127.0.0.0/8"},
+		ReadTimeout:     "1s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	if _, err := proxyproto.HeaderProxyFromAddrs(2, src, dst).WriteTo(client); err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("data"))
+
+	conn, err := acceptor.Accept(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn.RemoteAddr().String() != src.String() {
+		t.Fatalf("Expected client address %s, got %s", src, conn.RemoteAddr())
+	}
+
+	buf := make([]byte, 4)
+	if _, err := conn.Read(buf); err != nil || string(buf) != "data" {
+		t.Fatalf("Expected data after header, got %q, %v", buf, err)
+	}
+}
+
+func TestProxyProtocolAcceptUntrusted(t *testing.T) {
+
+	acceptor, err := proxyprotocol.NewAcceptor(&config.AcceptProxyProtocol{
+		TrustedNetworks: []string{"10.0.0.1"},
+		Required:        true,
+		ReadTimeout:     "1s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	conn, err := acceptor.Accept(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conn != server {
+		t.Fatal("Connection from untrusted peer should be returned as is")
+	}
+}
+
+func TestProxyProtocolAcceptRequired(t *testing.T) {
+
+	acceptor, err := proxyprotocol.NewAcceptor(&config.AcceptProxyProtocol{
+		TrustedNetworks: []string{"127.0.0.1"},
+		Required:        true,
+		ReadTimeout:     "100ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+
+	if _, err := acceptor.Accept(server); err == nil {
+		t.Fatal("Connection without header should be rejected")
+	}
+}
+
+func TestProxyProtocolAcceptNonTcpAddresses(t *testing.T) {
+
+	acceptor, err := proxyprotocol.NewAcceptor(&config.AcceptProxyProtocol{
+		TrustedNetworks: []string{"127.0.0.1"},
+		ReadTimeout:     "1s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := map[string]*proxyproto.Header{
+		"unix": {
+			Version:           2,
+			Command:           proxyproto.PROXY,
+			TransportProtocol: proxyproto.UnixStream,
+			SourceAddr:        &net.UnixAddr{Net: "unix", Name: "/tmp/client.sock"},
+			DestinationAddr:   &net.UnixAddr{Net: "unix", Name: "/tmp/server.sock"},
+		},
+		"udp": proxyproto.HeaderProxyFromAddrs(2,
+			&net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4242},
+			&net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 53}),
+	}
+
+	for name, header := range rejected {
+
+		client, server := tcpPair(t)
+
+		if _, err := header.WriteTo(client); err != nil {
+			t.Fatal(err)
+		}
+
+		if conn, err := acceptor.Accept(server); err == nil {
+			t.Errorf("Header with %s addresses should be rejected, got client %v", name, conn.RemoteAddr())
+		}
+
+		client.Close()
+		server.Close()
+	}
+
+	// local header keeps peer's addresses
+	client, server := tcpPair(t)
+	defer client.Close()
+	defer server.Close()
+
+	local := &proxyproto.Header{Version: 2, Command: proxyproto.LOCAL, TransportProtocol: proxyproto.UNSPEC}
+	if _, err := local.WriteTo(client); err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("data"))
+
+	conn, err := acceptor.Accept(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := conn.RemoteAddr().(*net.TCPAddr); !ok || conn.RemoteAddr().String() != client.LocalAddr().String() {
+		t.Errorf("Expected peer address %s, got %v", client.LocalAddr(), conn.RemoteAddr())
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "data" {
+		t.Fatalf("Expected data after header, got %q, %v", buf, err)
+	}
+}
+
+/**
+ * Make pair of connected tcp connections on loopback
+ */
+func tcpPair(t *testing.T) (client net.Conn, server net.Conn) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err = net.DialTimeout("tcp", l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server = <-accepted
+	if server == nil {
+		t.Fatal("Could not accept connection")
+	}
+
+	return client, server
+}
+
+/**
+ * Conn writing to buffer instead of socket
+ */
+type writerConn struct {
+	net.Conn
+	w *bytes.Buffer
+}
+
+func (c *writerConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
@@ -0,0 +1,335 @@
+package test
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+)
+
+/**
+ * Starts udp backend reading packets without replying
+ */
+func startSilentUdpBackend(t *testing.T) string {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		b := make([]byte, 65536)
+		for {
+			if _, _, err := conn.ReadFrom(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+/**
+ * Waits until server has n backends
+ */
+func waitBackends(t *testing.T, name string, n int) {
+
+	for i := 0; ; i++ {
+		if backends, _ := manager.Backends(name, ""); len(backends) == n {
+			return
+		}
+		if i == 50 {
+			t.Fatalf("Server %s has no %d backends", name, n)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+/**
+ * Proxies one connection through bind and waits until backend counts it
+ */
+func proxyOnce(t *testing.T, name string, bind string) {
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	conn.Close()
+
+	for i := 0; ; i++ {
+		if totalConnections(name) == 1 {
+			return
+		}
+		if i == 50 {
+			t.Fatalf("Connection to %s was not counted", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+/**
+ * Returns total connections of the only backend of server
+ */
+func totalConnections(name string) int64 {
+	backends, _ := manager.Backends(name, "")
+	if len(backends) != 1 {
+		return -1
+	}
+	return backends[0].Stats.TotalConnections
+}
+
+func TestReload(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	backend := startEchoBackend(t)
+
+	server := func(bind string, balance string) config.Server {
+		return config.Server{
+			Bind:    bind,
+			Balance: balance,
+			Discovery: &config.DiscoveryConfig{
+				Kind:                  "static",
+				StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+			},
+		}
+	}
+
+	unchanged := uniqueName("unchanged")
+	reconfigured := uniqueName("reconfigured")
+	recreated := uniqueName("recreated")
+	removed := uniqueName("removed")
+	added := uniqueName("added")
+
+	binds := map[string]string{}
+	for _, name := range []string{unchanged, reconfigured, recreated, removed, added} {
+		binds[name] = freeBind(t)
+	}
+
+	cfg := config.Config{Servers: map[string]config.Server{
+		unchanged:    server(binds[unchanged], "weight"),
+		reconfigured: server(binds[reconfigured], "weight"),
+		recreated:    server(binds[recreated], "weight"),
+		removed:      server(binds[removed], "weight"),
+	}}
+
+	if err := manager.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		manager.Reload(config.Config{})
+	}()
+
+	for _, name := range []string{unchanged, reconfigured, recreated} {
+		waitBackends(t, name, 1)
+		proxyOnce(t, name, binds[name])
+	}
+
+	// invalid config is rejected as a whole
+	invalid := config.Config{Servers: map[string]config.Server{
+		unchanged: server(binds[unchanged], "weight"),
+		added:     server(binds[added], "unknown"),
+	}}
+	if err := manager.Reload(invalid); err == nil {
+		t.Fatal("Invalid config should be rejected")
+	}
+	if len(manager.All()) != 4 {
+		t.Fatalf("Rejected config was partially applied: %v", manager.All())
+	}
+
+	newBind := freeBind(t)
+
+	cfg = config.Config{Servers: map[string]config.Server{
+		unchanged:    server(binds[unchanged], "weight"),
+		reconfigured: server(binds[reconfigured], "roundrobin"),
+		recreated:    server(newBind, "weight"),
+		added:        server(binds[added], "weight"),
+	}}
+
+	if err := manager.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	all := manager.All()
+
+	if _, ok := all[removed]; ok {
+		t.Error("Removed server is still running")
+	}
+
+	if _, ok := all[added]; !ok {
+		t.Error("Added server is not running")
+	}
+
+	// servers kept running have their stats
+	if n := totalConnections(unchanged); n != 1 {
+		t.Errorf("Unchanged server was restarted, connections %d", n)
+	}
+
+	if n := totalConnections(reconfigured); n != 1 {
+		t.Errorf("Reconfigured server was restarted, connections %d", n)
+	}
+
+	if all[reconfigured].Balance != "roundrobin" {
+		t.Errorf("Server was not reconfigured, balance %s", all[reconfigured].Balance)
+	}
+
+	if all[recreated].Bind != newBind {
+		t.Errorf("Server was not recreated, bind %s", all[recreated].Bind)
+	}
+
+	waitBackends(t, recreated, 1)
+	if n := totalConnections(recreated); n != 0 {
+		t.Errorf("Recreated server kept old stats, connections %d", n)
+	}
+
+	if conn, err := net.Dial("tcp", binds[recreated]); err == nil {
+		conn.Close()
+		t.Error("Old bind of recreated server still accepts connections")
+	}
+
+	proxyOnce(t, recreated, newBind)
+}
+
+func TestReloadRecreatesDrainingUdpServerInBackground(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	name := uniqueName("udp-reload")
+	drainTimeout := "1s"
+
+	cfg := config.Server{
+		Bind:     freeBind(t),
+		Protocol: "udp",
+		Udp:      &config.Udp{MaxResponses: 1},
+		ConnectionOptions: config.ConnectionOptions{
+			DrainTimeout: &drainTimeout,
+		},
+		Discovery: &config.DiscoveryConfig{
+			Kind:                  "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{startSilentUdpBackend(t)}},
+		},
+	}
+
+	if err := manager.Create(name, cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete(name, false)
+
+	waitBackends(t, name, 1)
+
+	// session stays active, as backend never replies
+	client, err := net.Dial("udp", cfg.Bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("hello"))
+	time.Sleep(200 * time.Millisecond)
+
+	// changed udp section requires recreate on the same bind
+	cfg.Udp = &config.Udp{MaxResponses: 5}
+
+	start := time.Now()
+	if err := manager.Reload(config.Config{Servers: map[string]config.Server{name: cfg}}); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Reload waited %v for server to drain", elapsed)
+	}
+
+	if err := manager.Create(name, cfg); err == nil {
+		t.Error("Server draining to be recreated should not be created again")
+	}
+
+	for i := 0; ; i++ {
+		if c, ok := manager.All()[name]; ok && c.Udp != nil && c.Udp.MaxResponses == 5 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("Server was not recreated after drain")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+/**
+ * Opens connection through bind checking it's proxied
+ */
+func dialEcho(t *testing.T, bind string) net.Conn {
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	conn.Write([]byte("hello"))
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestGracefulStop(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	backend := startEchoBackend(t)
+
+	for _, drainTimeout := range []string{"0", "1s"} {
+
+		name := uniqueName("graceful")
+		bind := freeBind(t)
+		timeout := drainTimeout
+
+		err := manager.Create(name, config.Server{
+			Bind: bind,
+			ConnectionOptions: config.ConnectionOptions{
+				DrainTimeout: &timeout,
+			},
+			Discovery: &config.DiscoveryConfig{
+				Kind:                  "static",
+				StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitBackends(t, name, 1)
+
+		conn := dialEcho(t, bind)
+
+		if err := manager.Delete(name, true); err != nil {
+			t.Fatal(err)
+		}
+
+		// new connections are not accepted while draining
+		if c, err := net.Dial("tcp", bind); err == nil {
+			c.Close()
+			t.Errorf("Draining server with drain_timeout %s accepts connections", drainTimeout)
+		}
+
+		// active ones are served until they are finished or timeout is over
+		time.Sleep(1500 * time.Millisecond)
+
+		conn.Write([]byte("again"))
+		_, err = io.ReadFull(conn, make([]byte, 5))
+
+		if drainTimeout == "0" && err != nil {
+			t.Errorf("Connection dropped while draining without timeout: %v", err)
+		}
+
+		if drainTimeout != "0" && err == nil {
+			t.Errorf("Connection not dropped after drain_timeout %s", drainTimeout)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package test
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/utils/tls/sni"
+)
+
+func TestSniMatcher(t *testing.T) {
+
+	m, err := sni.NewMatcher([]string{"api.example.com", "*.apps.example.com", `~^v[0-9]+\.example\.com$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]bool{
+		"api.example.com":      true,
+		"API.Example.com":      true,
+		"www.api.example.com":  false,
+		"a.apps.example.com":   true,
+		"a.b.apps.example.com": true,
+		"apps.example.com":     false,
+		"v2.example.com":       true,
+		"vx.example.com":       false,
+		"":                     false,
+	}
+
+	for hostname, expected := range cases {
+		if m.Match(hostname) != expected {
+			t.Errorf("Match(%q) should be %v", hostname, expected)
+		}
+	}
+
+	for _, patterns := range [][]string{{}, {""}, {"api.*.com"}, {"~("}} {
+		if _, err := sni.NewMatcher(patterns); err == nil {
+			t.Errorf("Patterns %q should be rejected", patterns)
+		}
+	}
+}
+
+/**
+ * Starts backend reporting name to hits on every connection
+ */
+func startNamedBackend(t *testing.T, name string, hits chan<- string) string {
+	return startBackend(t, func(conn net.Conn) {
+		conn.Close()
+		hits <- name
+	})
+}
+
+func staticPool(backend string) config.Pool {
+	return config.Pool{
+		Discovery: &config.DiscoveryConfig{
+			Kind: "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+				StaticList: []string{backend},
+			},
+		},
+	}
+}
+
+func TestSniRoutes(t *testing.T) {
+
+	hits := make(chan string)
+
+	bind := freeBind(t)
+
+	manager.Initialize(config.Config{})
+
+	err := manager.Create("routes", config.Server{
+		Bind: bind,
+		Discovery: &config.DiscoveryConfig{
+			Kind: "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+				StaticList: []string{startNamedBackend(t, "default", hits)},
+			},
+		},
+		Routes: []config.Route{
+			{Sni: []string{"api.example.com", `~^v[0-9]+\.example\.com$`}, Pool: "api"},
+			{Sni: []string{"*.apps.example.com"}, Pool: "apps"},
+		},
+		Pools: map[string]config.Pool{
+			"api":  staticPool(startNamedBackend(t, "api", hits)),
+			"apps": staticPool(startNamedBackend(t, "apps", hits)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete("routes", false)
+
+	// wait for pools discovery
+	for _, pool := range []string{"", "api", "apps"} {
+		for i := 0; ; i++ {
+			backends, err := manager.Backends("routes", pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backends) == 1 {
+				break
+			}
+			if i == 50 {
+				t.Fatalf("No backends discovered in pool %q", pool)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	if _, err := manager.Backends("routes", "unknown"); err == nil {
+		t.Fatal("Unknown pool should not be found")
+	}
+
+	cases := map[string]string{
+		"api.example.com":    "api",
+		"API.example.com":    "api",
+		"v2.example.com":     "api",
+		"a.apps.example.com": "apps",
+		"other.example.com":  "default",
+		"":                   "default",
+	}
+
+	for hostname, expected := range cases {
+
+		conn, err := net.Dial("tcp", bind)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go tls.Client(conn, &tls.Config{ServerName: hostname, InsecureSkipVerify: true}).Handshake()
+
+		select {
+		case name := <-hits:
+			if name != expected {
+				t.Errorf("Connection with sni %q routed to %s, expected %s", hostname, name, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Connection with sni %q was not proxied", hostname)
+		}
+
+		conn.Close()
+	}
+}
+
+func TestSniRoutesValidation(t *testing.T) {
+
+	manager.Initialize(config.Config{})
+
+	discovery := &config.DiscoveryConfig{
+		Kind: "static",
+		StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+			StaticList: []string{"127.0.0.1:1"},
+		},
+	}
+
+	invalid := map[string]config.Server{
+		"unknown pool": {
+			Bind:      "127.0.0.1:0",
+			Discovery: discovery,
+			Routes:    []config.Route{{Sni: []string{"a.example.com"}, Pool: "missing"}},
+		},
+		"invalid pattern": {
+			Bind:      "127.0.0.1:0",
+			Discovery: discovery,
+			Routes:    []config.Route{{Sni: []string{"~("}, Pool: "a"}},
+			Pools:     map[string]config.Pool{"a": staticPool("127.0.0.1:1")},
+		},
+		"pool without discovery": {
+			Bind:      "127.0.0.1:0",
+			Discovery: discovery,
+			Pools:     map[string]config.Pool{"a": {}},
+		},
+		"empty pool name": {
+			Bind:      "127.0.0.1:0",
+			Discovery: discovery,
+			Routes:    []config.Route{{Sni: []string{"a.example.com"}, Pool: ""}},
+			Pools:     map[string]config.Pool{"": staticPool("127.0.0.1:1")},
+		},
+	}
+
+	for name, cfg := range invalid {
+		if err := manager.Create("invalid-routes", cfg); err == nil {
+			manager.Delete("invalid-routes", false)
+			t.Errorf("Config with %s should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package test
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	"github.com/yyyar/gobetween/metrics/statsd"
+)
+
+/**
+ * Local statsd agent, keeping last gauges and sums of counters by metric name with tags
+ */
+type testStatsdAgent struct {
+	mu       sync.Mutex
+	gauges   map[string]uint64
+	counters map[string]uint64
+}
+
+func startTestStatsdAgent(t *testing.T) (*testStatsdAgent, string) {
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	agent := &testStatsdAgent{gauges: map[string]uint64{}, counters: map[string]uint64{}}
+
+	go func() {
+		b := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			for _, line := range strings.Split(string(b[:n]), "\n") {
+				agent.add(t, line)
+			}
+		}
+	}()
+
+	return agent, conn.LocalAddr().String()
+}
+
+/**
+ * Parse line in <name>:<value>|<type>[|#<tags>] format
+ */
+func (this *testStatsdAgent) add(t *testing.T, line string) {
+
+	name, rest, _ := strings.Cut(line, ":")
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		t.Errorf("Malformed statsd line %q", line)
+		return
+	}
+
+	value, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		t.Errorf("Malformed statsd value in %q", line)
+		return
+	}
+
+	key := name
+	if len(parts) > 2 {
+		key += "|" + parts[2]
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	switch parts[1] {
+	case "g":
+		this.gauges[key] = value
+	case "c":
+		this.counters[key] += value
+	default:
+		t.Errorf("Unexpected statsd metric type in %q", line)
+	}
+}
+
+func (this *testStatsdAgent) gauge(key string) (uint64, bool) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	v, ok := this.gauges[key]
+	return v, ok
+}
+
+func (this *testStatsdAgent) counter(key string) uint64 {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.counters[key]
+}
+
+func TestStatsd(t *testing.T) {
+
+	agent, address := startTestStatsdAgent(t)
+
+	err := statsd.Start(&config.StatsdConfig{
+		Address:  address,
+		Interval: "100ms",
+		Format:   "dogstatsd",
+		Tags:     []string{"env:test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer statsd.Stop()
+
+	manager.Initialize(config.Config{})
+
+	name := uniqueName("statsd")
+	bind := freeBind(t)
+	backend := startEchoBackend(t)
+	host, port, _ := net.SplitHostPort(backend)
+
+	err = manager.Create(name, config.Server{
+		Bind: bind,
+		Discovery: &config.DiscoveryConfig{
+			Kind:                  "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{StaticList: []string{backend}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete(name, false)
+
+	for i := 0; ; i++ {
+		if backends, _ := manager.Backends(name, ""); len(backends) == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("No backends discovered")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 5))
+	conn.Close()
+
+	backendTags := "#env:test,server:" + name + ",host:" + host + ",port:" + port
+
+	// stats are aggregated every few seconds
+	for i := 0; ; i++ {
+		live, _ := agent.gauge("gobetween.backend.live|" + backendTags)
+		if live == 1 && agent.counter("gobetween.backend.connections|"+backendTags) == 1 &&
+			agent.counter("gobetween.backend.rx_bytes|"+backendTags) == 5 &&
+			agent.counter("gobetween.server.rx_bytes|#env:test,server:"+name) == 5 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected stats not pushed")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, ok := agent.gauge("gobetween.server.active_connections|#env:test,server:" + name); !ok {
+		t.Errorf("Server active connections not pushed, got %v", agent.gauges)
+	}
+
+	statsd.Stop()
+
+	// plain statsd has server and backend in metric names
+	err = statsd.Start(&config.StatsdConfig{Address: address, Interval: "100ms", Prefix: "lb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsd.Stop()
+
+	key := "lb.backend." + name + "." + strings.ReplaceAll(host, ".", "_") + "_" + port + ".live"
+	for i := 0; ; i++ {
+		if live, _ := agent.gauge(key); live == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("Gauge %s not pushed", key)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	invalid := []config.StatsdConfig{
+		{Format: "graphite"},
+		{Tags: []string{"env:test"}},
+		{Interval: "-1s"},
+	}
+	for _, cfg := range invalid {
+		if err := statsd.Start(&cfg); err == nil {
+			statsd.Stop()
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	tlsutil "github.com/yyyar/gobetween/utils/tls"
+)
+
+/**
+ * Generates self-signed certificate for dns names, returns pem encoded certificate and key
+ */
+func generateCertificate(t *testing.T, cn string, dnsNames ...string) ([]byte, []byte) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+/**
+ * Writes new self-signed certificate and its key to files in dir, returns their paths
+ */
+func writeCertificate(t *testing.T, dir string, name string, cn string, dnsNames ...string) (string, string) {
+
+	certPem, keyPem := generateCertificate(t, cn, dnsNames...)
+
+	certPath := filepath.Join(dir, name+".crt")
+	keyPath := filepath.Join(dir, name+".key")
+
+	if err := os.WriteFile(certPath, certPem, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestTlsCertificatesBySni(t *testing.T) {
+
+	dir := t.TempDir()
+	pemDir := filepath.Join(dir, "pem")
+	if err := os.Mkdir(pemDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	defaultCert, defaultKey := writeCertificate(t, dir, "default", "default.example.com")
+	apiCert, apiKey := writeCertificate(t, dir, "api", "api", "api.example.com")
+
+	certPem, keyPem := generateCertificate(t, "apps", "*.apps.example.com")
+	if err := os.WriteFile(filepath.Join(pemDir, "apps.pem"), append(certPem, keyPem...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	acme := &tls.Certificate{}
+
+	tlsC := &config.Tls{
+		CertPath:     defaultCert,
+		KeyPath:      defaultKey,
+		Certificates: []config.TlsCertificate{{CertPath: apiCert, KeyPath: apiKey}},
+		CertDir:      pemDir,
+		AcmeHosts:    []string{"acme.example.com"},
+	}
+
+	certs, err := tlsutil.LoadCertificates(tlsC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := tlsutil.MakeTlsConfig(tlsC, certs, nil, func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return acme, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"api.example.com":      "api",
+		"API.example.com":      "api",
+		"a.apps.example.com":   "apps",
+		"a.b.apps.example.com": "default.example.com",
+		"other.example.com":    "default.example.com",
+		"":                     "default.example.com",
+	}
+
+	for hostname, expected := range cases {
+		crt, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: hostname})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crt.Leaf.Subject.CommonName != expected {
+			t.Errorf("Certificate %s selected for %q, expected %s", crt.Leaf.Subject.CommonName, hostname, expected)
+		}
+	}
+
+	if crt, _ := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "acme.example.com"}); crt != acme {
+		t.Errorf("Acme certificate expected for acme host")
+	}
+
+	// broken pem file in cert_dir fails
+	if err := os.WriteFile(filepath.Join(pemDir, "broken.pem"), certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsutil.LoadCertificates(&config.Tls{CertDir: pemDir}); err == nil {
+		t.Errorf("Pem file without key should be rejected")
+	}
+}
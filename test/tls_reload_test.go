@@ -0,0 +1,130 @@
+package test
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/manager"
+	tlsutil "github.com/yyyar/gobetween/utils/tls"
+)
+
+/**
+ * Returns common name of certificate served on bind
+ */
+func servedCertificate(t *testing.T, bind string) string {
+
+	conn, err := tls.Dial("tcp", bind, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestTlsCertificatesReload(t *testing.T) {
+
+	dir := t.TempDir()
+
+	certPath, keyPath := writeCertificate(t, dir, "server", "first")
+
+	bind := freeBind(t)
+
+	manager.Initialize(config.Config{})
+
+	err := manager.Create("reload", config.Server{
+		Bind: bind,
+		Discovery: &config.DiscoveryConfig{
+			Kind: "static",
+			StaticDiscoveryConfig: &config.StaticDiscoveryConfig{
+				StaticList: []string{startEchoBackend(t)},
+			},
+		},
+		Tls: &config.Tls{CertPath: certPath, KeyPath: keyPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Delete("reload", false)
+
+	// wait for discovery
+	for i := 0; ; i++ {
+		if backends, _ := manager.Backends("reload", ""); len(backends) == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("No backends discovered")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if cn := servedCertificate(t, bind); cn != "first" {
+		t.Fatalf("Certificate %s served, expected first", cn)
+	}
+
+	certificates, err := manager.Certificates("reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates) != 1 || certificates[0].Type != "tls" || certificates[0].Subject != "CN=first" {
+		t.Fatalf("Unexpected certificates %+v", certificates)
+	}
+	fingerprint := certificates[0].Fingerprint
+
+	// rotated certificate is served without restarting server
+	writeCertificate(t, dir, "server", "second")
+
+	for i := 0; servedCertificate(t, bind) != "second"; i++ {
+		if i == 50 {
+			t.Fatal("Rotated certificate was not reloaded")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	certificates, _ = manager.Certificates("reload")
+	if certificates[0].Fingerprint == fingerprint || certificates[0].NotAfter.IsZero() {
+		t.Errorf("Certificate details were not updated: %+v", certificates[0])
+	}
+
+	// broken certificate is rejected and current one is kept
+	if err := os.WriteFile(certPath, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+
+	if cn := servedCertificate(t, bind); cn != "second" {
+		t.Errorf("Certificate %s served after broken rotation, expected second", cn)
+	}
+}
+
+func TestBackendsTlsCertificates(t *testing.T) {
+
+	dir := t.TempDir()
+
+	certPath, keyPath := writeCertificate(t, dir, "client", "client")
+	caPath, _ := writeCertificate(t, dir, "ca", "ca")
+
+	_, certificates, err := tlsutil.MakeBackendTLSConfig(&config.BackendsTls{
+		CertPath:       &certPath,
+		KeyPath:        &keyPath,
+		RootCaCertPath: &caPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certificates) != 2 || certificates[0].Type != "backends_tls" || certificates[1].Type != "backends_tls_root_ca" {
+		t.Fatalf("Unexpected certificates %+v", certificates)
+	}
+
+	// root ca file without certificates is rejected
+	if err := os.WriteFile(caPath, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tlsutil.MakeBackendTLSConfig(&config.BackendsTls{RootCaCertPath: &caPath}); err == nil {
+		t.Errorf("Root ca file without certificates should be rejected")
+	}
+}
@@ -0,0 +1,179 @@
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yyyar/gobetween/config"
+	"github.com/yyyar/gobetween/core"
+	"github.com/yyyar/gobetween/discovery"
+)
+
+func nextBackends(t *testing.T, d *discovery.Discovery) []core.Backend {
+	select {
+	case backends := <-d.Discover():
+		return backends
+	case <-time.After(5 * time.Second):
+		t.Fatal("No backends discovered")
+		return nil
+	}
+}
+
+func TestConsulDiscoveryBlockingQuery(t *testing.T) {
+
+	var mu sync.Mutex
+	index := 10
+	ports := []int{8000}
+	changed := make(chan bool)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/v1/health/service/app" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// block while client has current index
+		mu.Lock()
+		current := index
+		mu.Unlock()
+
+		if r.URL.Query().Get("index") == strconv.Itoa(current) {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		entries := ""
+		for i, port := range ports {
+			if i > 0 {
+				entries += ","
+			}
+			entries += fmt.Sprintf(`{"Node": {"Address": "10.0.0.1"}, "Service": {"Port": %d, "Tags": ["sni=app.local"]}}`, port)
+		}
+
+		w.Header().Set("X-Consul-Index", strconv.Itoa(index))
+		w.Write([]byte("[" + entries + "]"))
+	}))
+	defer server.Close()
+
+	d := discovery.New("consul", config.DiscoveryConfig{
+		Kind:       "consul",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "2s",
+		ConsulDiscoveryConfig: &config.ConsulDiscoveryConfig{
+			ConsulHost:        server.Listener.Addr().String(),
+			ConsulServiceName: "app",
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 1 || backends[0].Port != "8000" || backends[0].Sni != "app.local" {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	// change is delivered without waiting for interval
+	mu.Lock()
+	index++
+	ports = append(ports, 8001)
+	mu.Unlock()
+	changed <- true
+
+	backends = nextBackends(t, d)
+	if len(backends) != 2 || backends[1].Port != "8001" {
+		t.Fatalf("Unexpected backends after change %v", backends)
+	}
+}
+
+func TestDockerDiscoveryEvents(t *testing.T) {
+
+	var mu sync.Mutex
+	ports := []int{32000}
+	events := make(chan string)
+	done := make(chan bool)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		switch r.URL.Path {
+
+		case "/containers/json":
+			mu.Lock()
+			defer mu.Unlock()
+
+			containers := ""
+			for i, port := range ports {
+				if i > 0 {
+					containers += ","
+				}
+				containers += fmt.Sprintf(`{"Id": "c%d", "Ports": [{"PrivatePort": 80, "PublicPort": %d, "IP": "10.0.0.1"}]}`, i, port)
+			}
+			w.Write([]byte("[" + containers + "]"))
+
+		case "/events":
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case event := <-events:
+					w.Write([]byte(event + "\n"))
+					w.(http.Flusher).Flush()
+				case <-r.Context().Done():
+					return
+				case <-done:
+					return
+				}
+			}
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// events connection is not closed by client on stop
+	defer close(done)
+
+	d := discovery.New("docker", config.DiscoveryConfig{
+		Kind:       "docker",
+		Failpolicy: "keeplast",
+		Interval:   "0",
+		Timeout:    "2s",
+		DockerDiscoveryConfig: &config.DockerDiscoveryConfig{
+			DockerEndpoint:             server.URL,
+			DockerContainerPrivatePort: 80,
+		},
+	})
+	d.Start()
+	defer d.Stop()
+
+	backends := nextBackends(t, d)
+	if len(backends) != 1 || backends[0].Address() != "10.0.0.1:32000" {
+		t.Fatalf("Unexpected backends %v", backends)
+	}
+
+	mu.Lock()
+	ports = append(ports, 32001)
+	mu.Unlock()
+
+	// irrelevant events do not cause listing
+	now := time.Now().Unix()
+	events <- fmt.Sprintf(`{"Type": "container", "Action": "exec_start", "Actor": {"ID": "c0"}, "time": %d}`, now)
+	events <- fmt.Sprintf(`{"Type": "container", "Action": "start", "Actor": {"ID": "c1"}, "time": %d}`, now)
+
+	backends = nextBackends(t, d)
+	if len(backends) != 2 || backends[1].Address() != "10.0.0.1:32001" {
+		t.Fatalf("Unexpected backends after start event %v", backends)
+	}
+}